@@ -127,7 +127,11 @@ func TestGetAttachment(t *testing.T) {
 			}
 			// Actual test
 			req := NewRequest(t, "GET", "/attachments/"+tc.uuid)
-			tc.session.MakeRequest(t, req, tc.want)
+			resp := tc.session.MakeRequest(t, req, tc.want)
+			if tc.want == http.StatusOK {
+				// attachments never change once they are uploaded
+				assert.Equal(t, "public,max-age=31536000,immutable", resp.Header().Get("Cache-Control"))
+			}
 		})
 	}
 }
@@ -78,6 +78,7 @@ func TestDownloadRawTextFileWithoutMimeTypeMapping(t *testing.T) {
 
 func TestDownloadRawTextFileWithMimeTypeMapping(t *testing.T) {
 	defer prepareTestEnv(t)()
+	defer func(enabled bool) { setting.MimeTypeMap.Enabled = enabled }(setting.MimeTypeMap.Enabled)
 	setting.MimeTypeMap.Map[".xml"] = "text/xml"
 	setting.MimeTypeMap.Enabled = true
 
@@ -89,5 +90,4 @@ func TestDownloadRawTextFileWithMimeTypeMapping(t *testing.T) {
 	assert.Equal(t, "text/xml; charset=utf-8", resp.HeaderMap.Get("Content-Type"))
 
 	delete(setting.MimeTypeMap.Map, ".xml")
-	setting.MimeTypeMap.Enabled = false
 }
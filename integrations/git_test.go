@@ -282,6 +282,13 @@ func mediaTest(t *testing.T, ctx *APITestContext, little, big, littleLFS, bigLFS
 				req = NewRequest(t, "GET", path.Join("/", username, reponame, "/media/branch/master/", bigLFS))
 				resp = session.MakeRequestNilResponseRecorder(t, req, http.StatusOK)
 				assert.Equal(t, bigSize, resp.Length)
+
+				// ranges of LFS objects are served without sending the whole object
+				req = NewRequest(t, "GET", path.Join("/", username, reponame, "/media/branch/master/", bigLFS))
+				req.Header.Set("Range", "bytes=0-9")
+				rangeResp := session.MakeRequest(t, req, http.StatusPartialContent)
+				assert.Equal(t, fmt.Sprintf("bytes 0-9/%d", bigSize), rangeResp.Header().Get("Content-Range"))
+				assert.Equal(t, 10, rangeResp.Body.Len())
 			}
 		}
 	})
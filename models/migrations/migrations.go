@@ -370,6 +370,8 @@ var migrations = []Migration{
 	NewMigration("Add webauthn table and migrate u2f data to webauthn", addWebAuthnCred),
 	// v208 -> v209
 	NewMigration("Use base32.HexEncoding instead of base64 encoding for cred ID as it is case insensitive", useBase32HexForCredIDInWebAuthnCredential),
+	// v209 -> v210
+	NewMigration("Add MIME type map column to repository table", addMimeTypeMapToRepository),
 }
 
 // GetCurrentDBVersion returns the current db version
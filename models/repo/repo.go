@@ -8,9 +8,11 @@ import (
 	"context"
 	"fmt"
 	"html/template"
+	"mime"
 	"net"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -22,6 +24,7 @@ import (
 	"code.gitea.io/gitea/modules/setting"
 	api "code.gitea.io/gitea/modules/structs"
 	"code.gitea.io/gitea/modules/timeutil"
+	"code.gitea.io/gitea/modules/typesniffer"
 	"code.gitea.io/gitea/modules/util"
 )
 
@@ -144,6 +147,7 @@ type Repository struct {
 	IsFsckEnabled                   bool               `xorm:"NOT NULL DEFAULT true"`
 	CloseIssuesViaCommitInAnyBranch bool               `xorm:"NOT NULL DEFAULT false"`
 	Topics                          []string           `xorm:"TEXT JSON"`
+	MimeTypeMap                     map[string]string  `xorm:"TEXT JSON"`
 
 	TrustModel TrustModelType
 
@@ -593,6 +597,46 @@ func (repo *Repository) GetTrustModel() TrustModelType {
 	return trustModel
 }
 
+// MimeTypeMapString returns the MIME type overrides of the repository as "extension = type" lines
+func (repo *Repository) MimeTypeMapString() string {
+	exts := make([]string, 0, len(repo.MimeTypeMap))
+	for ext := range repo.MimeTypeMap {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	var sb strings.Builder
+	for _, ext := range exts {
+		sb.WriteString(ext + " = " + repo.MimeTypeMap[ext] + "\n")
+	}
+	return sb.String()
+}
+
+// ParseMimeTypeMap parses "extension = type" lines into a map of MIME type overrides keyed by lowercased extension.
+// Invalid MIME types and those of content which may run scripts, like HTML, are left out.
+func ParseMimeTypeMap(s string) map[string]string {
+	m := make(map[string]string)
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		ext := strings.ToLower(strings.TrimSpace(fields[0]))
+		mimeType := strings.TrimSpace(fields[1])
+		if len(ext) == 0 || len(mimeType) == 0 {
+			continue
+		}
+		if _, _, err := mime.ParseMediaType(mimeType); err != nil || typesniffer.IsScriptableMimeType(mimeType) {
+			continue
+		}
+		if ext[0] != '.' {
+			ext = "." + ext
+		}
+		m[ext] = mimeType
+	}
+	return m
+}
+
 // GetRepositoryByOwnerAndName returns the repository by given ownername and reponame.
 func GetRepositoryByOwnerAndName(ownerName, repoName string) (*Repository, error) {
 	return GetRepositoryByOwnerAndNameCtx(db.DefaultContext, ownerName, repoName)
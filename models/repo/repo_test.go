@@ -49,3 +49,12 @@ func TestRepoAPIURL(t *testing.T) {
 
 	assert.Equal(t, "https://try.gitea.io/api/v1/repos/user12/repo10", repo.APIURL())
 }
+
+func TestParseMimeTypeMap(t *testing.T) {
+	m := ParseMimeTypeMap(".ts = video/mp2t\r\nJSON=application/json\n\ninvalid\n.empty =\n.bad = not/a/type\n.html = text/html\n.png = image/svg+xml\n")
+	assert.Equal(t, map[string]string{".ts": "video/mp2t", ".json": "application/json"}, m)
+
+	repo := &Repository{MimeTypeMap: m}
+	assert.Equal(t, ".json = application/json\n.ts = video/mp2t\n", repo.MimeTypeMapString())
+	assert.Equal(t, m, ParseMimeTypeMap(repo.MimeTypeMapString()))
+}
@@ -31,7 +31,7 @@ func ToUTF8WithFallbackReader(rd io.Reader) io.Reader {
 		return io.MultiReader(bytes.NewReader(RemoveBOMIfPresent(buf[:n])), rd)
 	}
 
-	charsetLabel, err := DetectEncoding(buf[:n])
+	charsetLabel, _, err := DetectEncoding(buf[:n])
 	if err != nil || charsetLabel == "UTF-8" {
 		return io.MultiReader(bytes.NewReader(RemoveBOMIfPresent(buf[:n])), rd)
 	}
@@ -52,7 +52,7 @@ func ToUTF8WithFallbackReader(rd io.Reader) io.Reader {
 
 // ToUTF8WithErr converts content to UTF8 encoding
 func ToUTF8WithErr(content []byte) (string, error) {
-	charsetLabel, err := DetectEncoding(content)
+	charsetLabel, _, err := DetectEncoding(content)
 	if err != nil {
 		return "", err
 	} else if charsetLabel == "UTF-8" {
@@ -90,7 +90,7 @@ func ToUTF8(content string) string {
 
 // ToUTF8DropErrors makes sure the return string is valid utf-8; attempts conversion if possible
 func ToUTF8DropErrors(content []byte) []byte {
-	charsetLabel, err := DetectEncoding(content)
+	charsetLabel, _, err := DetectEncoding(content)
 	if err != nil || charsetLabel == "UTF-8" {
 		return RemoveBOMIfPresent(content)
 	}
@@ -129,11 +129,25 @@ func RemoveBOMIfPresent(content []byte) []byte {
 	return content
 }
 
-// DetectEncoding detect the encoding of content
-func DetectEncoding(content []byte) (string, error) {
+// DetectBOM returns the charset and the length of the byte-order marker content starts with,
+// or an empty charset and zero if it does not start with one
+func DetectBOM(content []byte) (string, int) {
+	switch {
+	case bytes.HasPrefix(content, UTF8BOM):
+		return "UTF-8", len(UTF8BOM)
+	case bytes.HasPrefix(content, []byte{'\xff', '\xfe'}):
+		return "UTF-16LE", 2
+	case bytes.HasPrefix(content, []byte{'\xfe', '\xff'}):
+		return "UTF-16BE", 2
+	}
+	return "", 0
+}
+
+// DetectEncoding detect the encoding of content and how confident the detection is, from 0 to 100
+func DetectEncoding(content []byte) (string, int, error) {
 	if utf8.Valid(content) {
 		log.Debug("Detected encoding: utf-8 (fast)")
-		return "UTF-8", nil
+		return "UTF-8", 100, nil
 	}
 
 	textDetector := chardet.NewTextDetector()
@@ -141,7 +155,7 @@ func DetectEncoding(content []byte) (string, error) {
 	if len(content) < 1024 {
 		// Check if original content is valid
 		if _, err := textDetector.DetectBest(content); err != nil {
-			return "", err
+			return "", 0, err
 		}
 		times := 1024 / len(content)
 		detectContent = make([]byte, 0, times*len(content))
@@ -156,10 +170,11 @@ func DetectEncoding(content []byte) (string, error) {
 	results, err := textDetector.DetectAll(detectContent)
 	if err != nil {
 		if err == chardet.NotDetectedError && len(setting.Repository.AnsiCharset) > 0 {
+			// the configured charset is not a guess, it must not be overruled by a minimum confidence
 			log.Debug("Using default AnsiCharset: %s", setting.Repository.AnsiCharset)
-			return setting.Repository.AnsiCharset, nil
+			return setting.Repository.AnsiCharset, 100, nil
 		}
-		return "", err
+		return "", 0, err
 	}
 
 	topConfidence := results[0].Confidence
@@ -184,9 +199,9 @@ func DetectEncoding(content []byte) (string, error) {
 	// FIXME: to properly decouple this function the fallback ANSI charset should be passed as an argument
 	if topResult.Charset != "UTF-8" && len(setting.Repository.AnsiCharset) > 0 {
 		log.Debug("Using default AnsiCharset: %s", setting.Repository.AnsiCharset)
-		return setting.Repository.AnsiCharset, err
+		return setting.Repository.AnsiCharset, 100, err
 	}
 
-	log.Debug("Detected encoding: %s", topResult.Charset)
-	return topResult.Charset, err
+	log.Debug("Detected encoding: %s (confidence %d)", topResult.Charset, topResult.Confidence)
+	return topResult.Charset, topResult.Confidence, err
 }
@@ -37,6 +37,28 @@ func TestRemoveBOMIfPresent(t *testing.T) {
 	assert.Equal(t, []byte{0xc3, 0xa1, 0xc3, 0xa9, 0xc3, 0xad, 0xc3, 0xb3, 0xc3, 0xba}, res)
 }
 
+func TestDetectBOM(t *testing.T) {
+	cs, n := DetectBOM([]byte{0xef, 0xbb, 0xbf, 'a'})
+	assert.Equal(t, "UTF-8", cs)
+	assert.Equal(t, 3, n)
+
+	cs, n = DetectBOM([]byte{0xff, 0xfe, 'a', 0x00})
+	assert.Equal(t, "UTF-16LE", cs)
+	assert.Equal(t, 2, n)
+
+	cs, n = DetectBOM([]byte{0xfe, 0xff, 0x00, 'a'})
+	assert.Equal(t, "UTF-16BE", cs)
+	assert.Equal(t, 2, n)
+
+	cs, n = DetectBOM([]byte{0xef, 0xbb})
+	assert.Empty(t, cs)
+	assert.Zero(t, n)
+
+	cs, n = DetectBOM([]byte("plain text"))
+	assert.Empty(t, cs)
+	assert.Zero(t, n)
+}
+
 func TestToUTF8WithErr(t *testing.T) {
 	resetDefaultCharsetsOrder()
 	var res string
@@ -258,7 +280,7 @@ func TestToUTF8DropErrors(t *testing.T) {
 func TestDetectEncoding(t *testing.T) {
 	resetDefaultCharsetsOrder()
 	testSuccess := func(b []byte, expected string) {
-		encoding, err := DetectEncoding(b)
+		encoding, _, err := DetectEncoding(b)
 		assert.NoError(t, err)
 		assert.Equal(t, expected, encoding)
 	}
@@ -276,7 +298,7 @@ func TestDetectEncoding(t *testing.T) {
 
 	// iso-8859-1: d<accented e>cor<newline>
 	b = []byte{0x44, 0xe9, 0x63, 0x6f, 0x72, 0x0a}
-	encoding, err := DetectEncoding(b)
+	encoding, _, err := DetectEncoding(b)
 	assert.NoError(t, err)
 	assert.Contains(t, encoding, "ISO-8859-1")
 
@@ -289,10 +311,44 @@ func TestDetectEncoding(t *testing.T) {
 
 	// invalid bytes
 	b = []byte{0xfa}
-	_, err = DetectEncoding(b)
+	_, _, err = DetectEncoding(b)
 	assert.Error(t, err)
 }
 
+func TestDetectEncodingConfidence(t *testing.T) {
+	resetDefaultCharsetsOrder()
+
+	_, confidence, err := DetectEncoding([]byte("just some ascii"))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, confidence)
+
+	// iso-8859-1: d<accented e>cor<newline>
+	_, confidence, err = DetectEncoding([]byte{0x44, 0xe9, 0x63, 0x6f, 0x72, 0x0a})
+	assert.NoError(t, err)
+	assert.Greater(t, confidence, 50)
+
+	// iso-8859-1: caf<accented e>, too short to tell it from a multi-byte charset
+	_, confidence, err = DetectEncoding([]byte{0x63, 0x61, 0x66, 0xe9})
+	assert.NoError(t, err)
+	assert.Less(t, confidence, 50)
+
+	// the configured ANSI charset is used with full confidence
+	defer func(charset string) {
+		setting.Repository.AnsiCharset = charset
+	}(setting.Repository.AnsiCharset)
+	setting.Repository.AnsiCharset = "windows-1252"
+	encoding, confidence, err := DetectEncoding([]byte{0x63, 0x61, 0x66, 0xe9})
+	assert.NoError(t, err)
+	assert.Equal(t, "windows-1252", encoding)
+	assert.Equal(t, 100, confidence)
+
+	// UTF-8 is still detected with the ANSI charset configured
+	encoding, confidence, err = DetectEncoding([]byte("just some ascii"))
+	assert.NoError(t, err)
+	assert.Equal(t, "UTF-8", encoding)
+	assert.Equal(t, 100, confidence)
+}
+
 func stringMustStartWith(t *testing.T, expected, value string) {
 	assert.Equal(t, expected, string(value[:len(expected)]))
 }
@@ -93,6 +93,22 @@ func GetRepoRawDiffForFile(repo *Repository, startCommit, endCommit string, diff
 	return nil
 }
 
+// GetRawDiffForBlobs dumps the unified diff between two blobs of the repository to the writer.
+// Binary blobs are reported by a "Binary files ... differ" line.
+func GetRawDiffForBlobs(repo *Repository, oldBlobID, newBlobID string, writer io.Writer) error {
+	stderr := new(bytes.Buffer)
+	cmd := NewCommandContext(repo.Ctx, "diff", "--no-color", "--no-ext-diff", oldBlobID, newBlobID)
+	if err := cmd.RunWithContext(&RunContext{
+		Timeout: -1,
+		Dir:     repo.Path,
+		Stdout:  writer,
+		Stderr:  stderr,
+	}); err != nil {
+		return fmt.Errorf("Run: %v - %s", err, stderr)
+	}
+	return nil
+}
+
 // ParseDiffHunkString parse the diffhunk content and return
 func ParseDiffHunkString(diffhunk string) (leftLine, leftHunk, rightLine, righHunk int) {
 	ss := strings.Split(diffhunk, "@@")
@@ -104,6 +104,27 @@ func Code(fileName, language, code string) string {
 	return CodeFromLexer(lexer, code)
 }
 
+// IsRecognized returns whether the language of the named file is recognized by its name, so that Code highlights it
+func IsRecognized(fileName string) bool {
+	NewContext()
+
+	if val, ok := highlightMapping[filepath.Ext(fileName)]; ok && lexers.Get(val) != nil {
+		return true
+	}
+	return lexers.Match(fileName) != nil
+}
+
+// Stylesheet returns the CSS of the classes code is highlighted with, for documents which don't load the styles of Gitea.
+// Its rules apply to descendants of an element of the chroma class.
+func Stylesheet() string {
+	var buf bytes.Buffer
+	if err := html.New(html.WithClasses(true)).WriteCSS(&buf, styles.GitHub); err != nil {
+		log.Error("Can't write the stylesheet of highlighted code: %v", err)
+		return ""
+	}
+	return buf.String()
+}
+
 // CodeFromLexer returns a HTML version of code string with chroma syntax highlighting classes
 func CodeFromLexer(lexer chroma.Lexer, code string) string {
 	formatter := html.New(html.WithClasses(true),
@@ -40,7 +40,7 @@ func HandleTimeCache(req *http.Request, w http.ResponseWriter, fi os.FileInfo) (
 	AddCacheControlToHeader(w.Header(), setting.StaticCacheTime)
 
 	ifModifiedSince := req.Header.Get("If-Modified-Since")
-	if ifModifiedSince != "" {
+	if ifModifiedSince != "" && !IsNoCacheRequested(req) {
 		t, err := time.Parse(http.TimeFormat, ifModifiedSince)
 		if err == nil && fi.ModTime().Unix() <= t.Unix() {
 			w.WriteHeader(http.StatusNotModified)
@@ -63,7 +63,7 @@ func HandleFileETagCache(req *http.Request, w http.ResponseWriter, fi os.FileInf
 func HandleGenericETagCache(req *http.Request, w http.ResponseWriter, etag string) (handled bool) {
 	if len(etag) > 0 {
 		w.Header().Set("Etag", etag)
-		if checkIfNoneMatchIsValid(req, etag) {
+		if !IsNoCacheRequested(req) && checkIfNoneMatchIsValid(req, etag) {
 			w.WriteHeader(http.StatusNotModified)
 			return true
 		}
@@ -72,16 +72,78 @@ func HandleGenericETagCache(req *http.Request, w http.ResponseWriter, etag strin
 	return false
 }
 
-// checkIfNoneMatchIsValid tests if the header If-None-Match matches the ETag
+// WeakETag returns the weak form W/"..." of an entity-tag, which only promises that the content is semantically
+// equivalent rather than byte for byte the same, e.g. because it has been compressed or transcoded on the fly
+func WeakETag(etag string) string {
+	if len(etag) == 0 || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// IsNoCacheRequested returns whether the client asks for fresh content with a Cache-Control: no-cache request
+// directive, or with Pragma: no-cache which is only heeded without Cache-Control as RFC 7234 requires.
+// Such requests are always answered with the full content instead of 304 Not Modified.
+func IsNoCacheRequested(req *http.Request) bool {
+	cacheControl := req.Header.Values("Cache-Control")
+	if len(cacheControl) == 0 {
+		cacheControl = req.Header.Values("Pragma")
+	}
+	for _, value := range cacheControl {
+		for _, directive := range strings.Split(value, ",") {
+			// no-cache may come with a list of fields in responses, but clients aren't supposed to send one
+			name := strings.SplitN(directive, "=", 2)[0]
+			if strings.EqualFold(strings.TrimSpace(name), "no-cache") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkIfNoneMatchIsValid tests if the header If-None-Match matches the ETag.
+// As required by RFC 7232 a "*" matches any ETag and the weak comparison is used,
+// so that W/"etag" matches "etag" as well.
 func checkIfNoneMatchIsValid(req *http.Request, etag string) bool {
-	ifNoneMatch := req.Header.Get("If-None-Match")
-	if len(ifNoneMatch) > 0 {
-		for _, item := range strings.Split(ifNoneMatch, ",") {
-			item = strings.TrimSpace(item)
-			if item == etag {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, ifNoneMatch := range req.Header.Values("If-None-Match") {
+		for {
+			ifNoneMatch = strings.TrimLeft(ifNoneMatch, " \t,")
+			if len(ifNoneMatch) == 0 {
+				break
+			}
+			if ifNoneMatch[0] == '*' {
+				return true
+			}
+			item, remain := scanETag(ifNoneMatch)
+			if len(item) == 0 {
+				// the rest of a malformed list cannot be parsed reliably
+				break
+			}
+			if strings.TrimPrefix(item, "W/") == etag {
 				return true
 			}
+			ifNoneMatch = remain
 		}
 	}
 	return false
 }
+
+// scanETag returns the entity-tag at the start of s and the remainder of s.
+// The entity-tag is empty if s doesn't start with a valid one.
+func scanETag(s string) (etag, remain string) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s[start:]) < 2 || s[start] != '"' {
+		return "", ""
+	}
+	// an entity-tag may contain commas, so it ends at the closing quote only
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", ""
+	}
+	end += start + 2
+	return s[:end], s[end:]
+}
@@ -152,4 +152,84 @@ func TestHandleGenericETagCache(t *testing.T) {
 		assert.Equal(t, etag, w.Header().Get("Etag"))
 		assert.Equal(t, http.StatusNotModified, w.Code)
 	})
+	t.Run("Correct_If-None-Match_No-Cache", func(t *testing.T) {
+		for _, header := range []string{"Cache-Control", "Pragma"} {
+			req := &http.Request{Header: make(http.Header)}
+			w := httptest.NewRecorder()
+
+			req.Header.Set("If-None-Match", etag)
+			req.Header.Set(header, "no-cache")
+
+			handled := HandleGenericETagCache(req, w, etag)
+
+			assert.False(t, handled, header)
+			assert.Equal(t, 2, countFormalHeaders(w.Header()), header)
+			assert.Equal(t, etag, w.Header().Get("Etag"), header)
+		}
+	})
+}
+
+func TestWeakETag(t *testing.T) {
+	assert.Equal(t, `W/"test"`, WeakETag(`"test"`))
+	assert.Equal(t, `W/"test"`, WeakETag(`W/"test"`))
+	assert.Empty(t, WeakETag(""))
+
+	// a weak ETag is compared weakly against If-None-Match
+	for _, ifNoneMatch := range []string{`"test"`, `W/"test"`} {
+		req := &http.Request{Header: make(http.Header)}
+		w := httptest.NewRecorder()
+		req.Header.Set("If-None-Match", ifNoneMatch)
+
+		assert.True(t, HandleGenericETagCache(req, w, WeakETag(`"test"`)), ifNoneMatch)
+		assert.Equal(t, `W/"test"`, w.Header().Get("Etag"), ifNoneMatch)
+		assert.Equal(t, http.StatusNotModified, w.Code, ifNoneMatch)
+	}
+}
+
+func TestIsNoCacheRequested(t *testing.T) {
+	kases := map[string]struct {
+		header  http.Header
+		noCache bool
+	}{
+		"none":           {http.Header{}, false},
+		"no-cache":       {http.Header{"Cache-Control": {"no-cache"}}, true},
+		"list":           {http.Header{"Cache-Control": {"max-age=0, No-Cache"}}, true},
+		"max-age":        {http.Header{"Cache-Control": {"max-age=0"}}, false},
+		"no-store":       {http.Header{"Cache-Control": {"no-store"}}, false},
+		"pragma":         {http.Header{"Pragma": {"no-cache"}}, true},
+		"pragma ignored": {http.Header{"Cache-Control": {"max-age=60"}, "Pragma": {"no-cache"}}, false},
+	}
+	for name, kase := range kases {
+		assert.Equal(t, kase.noCache, IsNoCacheRequested(&http.Request{Header: kase.header}), name)
+	}
+}
+
+func TestCheckIfNoneMatchIsValid(t *testing.T) {
+	kases := map[string]struct {
+		ifNoneMatch []string
+		etag        string
+		expected    bool
+	}{
+		"list":              {[]string{`"a", "b", "c"`}, `"b"`, true},
+		"list no match":     {[]string{`"a", "b", "c"`}, `"d"`, false},
+		"comma in etag":     {[]string{`"a,b", "c"`}, `"a,b"`, true},
+		"comma split":       {[]string{`"a,b", "c"`}, `"b"`, false},
+		"multiple headers":  {[]string{`"a"`, `"b"`}, `"b"`, true},
+		"wildcard":          {[]string{`*`}, `"a"`, true},
+		"weak validator":    {[]string{`W/"a"`}, `"a"`, true},
+		"weak etag":         {[]string{`"a"`}, `W/"a"`, true},
+		"weak both":         {[]string{`"x", W/"a"`}, `W/"a"`, true},
+		"unquoted":          {[]string{`a`}, `"a"`, false},
+		"malformed":         {[]string{`"a`}, `"a"`, false},
+		"no if-none-match":  {nil, `"a"`, false},
+		"empty":             {[]string{``}, `"a"`, false},
+		"spaces and commas": {[]string{` , "a" ,, `}, `"a"`, true},
+	}
+	for name, kase := range kases {
+		req := &http.Request{Header: make(http.Header)}
+		for _, value := range kase.ifNoneMatch {
+			req.Header.Add("If-None-Match", value)
+		}
+		assert.Equal(t, kase.expected, checkIfNoneMatchIsValid(req, kase.etag), name)
+	}
 }
@@ -0,0 +1,249 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// ErrInvalidImage is returned if an image is truncated or doesn't have the structure of its format
+var ErrInvalidImage = errors.New("invalid image")
+
+// IsStrippable returns whether metadata can be stripped from images of the given MIME type
+func IsStrippable(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Strip removes the metadata, like the EXIF data with the location an image was taken at and the camera
+// it was taken with, from a JPEG, PNG or WebP image of the given MIME type. Only the parts of the image
+// containing the metadata are removed, the pixels are left untouched. The EXIF orientation is kept, since
+// images taken upright are often stored rotated. Images of other types are returned as they are.
+func Strip(content []byte, mimeType string) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(content)
+	case "image/png":
+		return stripPNG(content)
+	case "image/webp":
+		return stripWebP(content)
+	}
+	return content, nil
+}
+
+// stripJPEG removes the APP1 (EXIF and XMP), APP13 (IPTC) and comment segments in front of the image data.
+// JFIF, the ICC profile and the Adobe segment affect how the image is displayed, so they are kept, and so is
+// an EXIF segment with nothing but the orientation.
+func stripJPEG(content []byte) ([]byte, error) {
+	if len(content) < 2 || content[0] != 0xff || content[1] != 0xd8 {
+		return nil, ErrInvalidImage
+	}
+	stripped := make([]byte, 0, len(content))
+	stripped = append(stripped, content[:2]...)
+	pos := 2
+	for {
+		if pos+4 > len(content) || content[pos] != 0xff {
+			return nil, ErrInvalidImage
+		}
+		marker := content[pos+1]
+		if marker == 0xda {
+			// start of scan, the entropy-coded image data follows up to the end
+			return append(stripped, content[pos:]...), nil
+		}
+		length := int(binary.BigEndian.Uint16(content[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(content) {
+			return nil, ErrInvalidImage
+		}
+		switch marker {
+		case 0xe1:
+			if data := content[pos+4 : end]; bytes.HasPrefix(data, exifHeader) {
+				if exif := orientationOnlyEXIF(data[len(exifHeader):]); exif != nil {
+					length := 2 + len(exifHeader) + len(exif)
+					stripped = append(stripped, 0xff, 0xe1, byte(length>>8), byte(length))
+					stripped = append(stripped, exifHeader...)
+					stripped = append(stripped, exif...)
+				}
+			}
+		case 0xed, 0xfe:
+		default:
+			stripped = append(stripped, content[pos:end]...)
+		}
+		pos = end
+	}
+}
+
+// pngSignature starts every PNG image
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are the types of the PNG chunks which contain metadata, an eXIf chunk is replaced by
+// one with nothing but the orientation
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNG removes the chunks containing metadata. Every chunk carries its own checksum,
+// so the remaining chunks stay valid.
+func stripPNG(content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, pngSignature) {
+		return nil, ErrInvalidImage
+	}
+	stripped := make([]byte, 0, len(content))
+	stripped = append(stripped, pngSignature...)
+	pos := len(pngSignature)
+	for pos < len(content) {
+		if pos+8 > len(content) {
+			return nil, ErrInvalidImage
+		}
+		length := int(binary.BigEndian.Uint32(content[pos:]))
+		typ := string(content[pos+4 : pos+8])
+		// length, type, data and CRC
+		end := pos + 12 + length
+		if length < 0 || end > len(content) || end < pos {
+			return nil, ErrInvalidImage
+		}
+		if !pngMetadataChunks[typ] {
+			stripped = append(stripped, content[pos:end]...)
+		} else if typ == "eXIf" {
+			if exif := orientationOnlyEXIF(content[pos+8 : end-4]); exif != nil {
+				chunk := make([]byte, 8+len(exif)+4)
+				binary.BigEndian.PutUint32(chunk, uint32(len(exif)))
+				copy(chunk[4:], typ)
+				copy(chunk[8:], exif)
+				binary.BigEndian.PutUint32(chunk[8+len(exif):], crc32.ChecksumIEEE(chunk[4:8+len(exif)]))
+				stripped = append(stripped, chunk...)
+			}
+		}
+		pos = end
+		if typ == "IEND" {
+			break
+		}
+	}
+	return stripped, nil
+}
+
+// the flags of the VP8X chunk of a WebP image telling which metadata chunks are present
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+// stripWebP removes the XMP chunk and replaces the EXIF chunk by one with nothing but the orientation,
+// clearing the flags of the removed chunks in the VP8X chunk
+func stripWebP(content []byte) ([]byte, error) {
+	if len(content) < 12 || string(content[:4]) != "RIFF" || string(content[8:12]) != "WEBP" {
+		return nil, ErrInvalidImage
+	}
+	stripped := make([]byte, 0, len(content))
+	stripped = append(stripped, content[:12]...)
+	pos := 12
+	flags, exifKept := -1, false
+	for pos < len(content) {
+		if pos+8 > len(content) {
+			return nil, ErrInvalidImage
+		}
+		typ := string(content[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(content[pos+4:]))
+		// chunks of an odd length are padded to an even one
+		end := pos + 8 + length + length&1
+		if length < 0 || end > len(content) || end < pos {
+			return nil, ErrInvalidImage
+		}
+		switch typ {
+		case "EXIF":
+			// some writers put the header of a JPEG APP1 segment in front of the TIFF data
+			data := bytes.TrimPrefix(content[pos+8:pos+8+length], exifHeader)
+			if exif := orientationOnlyEXIF(data); exif != nil {
+				chunk := make([]byte, 8+len(exif))
+				copy(chunk, typ)
+				binary.LittleEndian.PutUint32(chunk[4:], uint32(len(exif)))
+				copy(chunk[8:], exif)
+				stripped = append(stripped, chunk...)
+				exifKept = true
+			}
+		case "XMP ":
+		case "VP8X":
+			start := len(stripped)
+			stripped = append(stripped, content[pos:end]...)
+			if length > 0 {
+				flags = start + 8
+				stripped[flags] &^= webpFlagEXIF | webpFlagXMP
+			}
+		default:
+			stripped = append(stripped, content[pos:end]...)
+		}
+		pos = end
+	}
+	if flags >= 0 && exifKept {
+		stripped[flags] |= webpFlagEXIF
+	}
+	// the RIFF size covers everything after itself
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, nil
+}
+
+// exifHeader starts the EXIF data of a JPEG APP1 segment, the TIFF data with the tags follows it
+var exifHeader = []byte("Exif\x00\x00")
+
+// exifOrientationTag is the TIFF tag of the orientation, which tells how an image has to be rotated and mirrored
+const exifOrientationTag = 0x0112
+
+// orientationOnlyEXIF returns TIFF data with nothing but the orientation tag of the given TIFF data of an EXIF block,
+// or nil if it has no orientation other than the default one or cannot be parsed
+func orientationOnlyEXIF(tiff []byte) []byte {
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			return nil
+		}
+		// the orientation is a single SHORT
+		if order.Uint16(tiff[entry:]) != exifOrientationTag || order.Uint16(tiff[entry+2:]) != 3 {
+			continue
+		}
+		orientation := order.Uint16(tiff[entry+8:])
+		if orientation <= 1 || orientation > 8 {
+			return nil
+		}
+		// the TIFF header, the first IFD with its single entry and the offset of the next IFD, 0 as there is none
+		exif := make([]byte, 8+2+12+4)
+		copy(exif, tiff[:2])
+		order.PutUint16(exif[2:], 42)
+		order.PutUint32(exif[4:], 8)
+		order.PutUint16(exif[8:], 1)
+		order.PutUint16(exif[10:], exifOrientationTag)
+		order.PutUint16(exif[12:], 3)
+		order.PutUint32(exif[14:], 1)
+		order.PutUint16(exif[18:], orientation)
+		return exif
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 32), B: 0x80, A: 0xff})
+		}
+	}
+	return img
+}
+
+// exifSample is the start of an EXIF block naming the camera
+var exifSample = []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08Camera Model GPS 52.5N 13.4E")
+
+// exifWithOrientation returns the TIFF data of an EXIF block with the given orientation and the camera model
+func exifWithOrientation(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	if order == binary.LittleEndian {
+		tiff = []byte("II\x2a\x00\x08\x00\x00\x00")
+	}
+	model := []byte("Camera Model GPS 52.5N 13.4E\x00")
+	entries := make([]byte, 2+2*12+4)
+	order.PutUint16(entries, 2)
+	// the model as an ASCII string behind the IFD
+	order.PutUint16(entries[2:], 0x0110)
+	order.PutUint16(entries[4:], 2)
+	order.PutUint32(entries[6:], uint32(len(model)))
+	order.PutUint32(entries[10:], uint32(len(tiff)+len(entries)))
+	order.PutUint16(entries[14:], exifOrientationTag)
+	order.PutUint16(entries[16:], 3)
+	order.PutUint32(entries[18:], 1)
+	order.PutUint16(entries[22:], orientation)
+	tiff = append(tiff, entries...)
+	return append(tiff, model...)
+}
+
+func TestOrientationOnlyEXIF(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		exif := orientationOnlyEXIF(exifWithOrientation(order, 6))
+		assert.Len(t, exif, 26)
+		assert.EqualValues(t, 1, order.Uint16(exif[8:]))
+		assert.EqualValues(t, exifOrientationTag, order.Uint16(exif[10:]))
+		assert.EqualValues(t, 6, order.Uint16(exif[18:]))
+		assert.NotContains(t, string(exif), "GPS")
+
+		// the default orientation needs no EXIF data at all
+		assert.Nil(t, orientationOnlyEXIF(exifWithOrientation(order, 1)))
+	}
+	assert.Nil(t, orientationOnlyEXIF(exifSample[6:]))
+	assert.Nil(t, orientationOnlyEXIF(nil))
+}
+
+func assertSamePixels(t *testing.T, expected, actual []byte) {
+	expectedImg, _, err := image.Decode(bytes.NewReader(expected))
+	assert.NoError(t, err)
+	actualImg, _, err := image.Decode(bytes.NewReader(actual))
+	assert.NoError(t, err)
+	assert.Equal(t, expectedImg, actualImg)
+}
+
+func TestStripJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, sampleImage(), nil))
+	original := buf.Bytes()
+
+	// insert an APP1 segment with EXIF data and a comment behind the start of image marker
+	segment := func(marker byte, data []byte) []byte {
+		return append([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)}, data...)
+	}
+	withExif := append([]byte{}, original[:2]...)
+	withExif = append(withExif, segment(0xe1, exifSample)...)
+	withExif = append(withExif, segment(0xfe, []byte("taken by someone"))...)
+	withExif = append(withExif, original[2:]...)
+	assertSamePixels(t, original, withExif)
+
+	stripped, err := Strip(withExif, "image/jpeg")
+	assert.NoError(t, err)
+	assert.Equal(t, original, stripped)
+	assert.NotContains(t, string(stripped), "Exif")
+	assertSamePixels(t, withExif, stripped)
+
+	_, err = Strip(withExif[:20], "image/jpeg")
+	assert.Equal(t, ErrInvalidImage, err)
+
+	// only the orientation is kept of an EXIF block with one
+	withOrientation := append([]byte{}, original[:2]...)
+	withOrientation = append(withOrientation, segment(0xe1, append([]byte("Exif\x00\x00"), exifWithOrientation(binary.BigEndian, 6)...))...)
+	withOrientation = append(withOrientation, original[2:]...)
+	stripped, err = Strip(withOrientation, "image/jpeg")
+	assert.NoError(t, err)
+	expected := append([]byte{}, original[:2]...)
+	expected = append(expected, segment(0xe1, append([]byte("Exif\x00\x00"), orientationOnlyEXIF(exifWithOrientation(binary.BigEndian, 6))...))...)
+	expected = append(expected, original[2:]...)
+	assert.Equal(t, expected, stripped)
+	assertSamePixels(t, withOrientation, stripped)
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, sampleImage()))
+	original := buf.Bytes()
+
+	chunk := func(typ string, data []byte) []byte {
+		c := make([]byte, 4, 12+len(data))
+		binary.BigEndian.PutUint32(c, uint32(len(data)))
+		c = append(c, typ...)
+		c = append(c, data...)
+		c = append(c, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(c[len(c)-4:], crc32.ChecksumIEEE(c[4:len(c)-4]))
+		return c
+	}
+	// insert the metadata behind the IHDR chunk, which is the first one
+	ihdrEnd := len(pngSignature) + 12 + 13
+	withExif := append([]byte{}, original[:ihdrEnd]...)
+	withExif = append(withExif, chunk("eXIf", exifSample[6:])...)
+	withExif = append(withExif, chunk("tEXt", []byte("Author\x00someone"))...)
+	withExif = append(withExif, original[ihdrEnd:]...)
+	assertSamePixels(t, original, withExif)
+
+	stripped, err := Strip(withExif, "image/png")
+	assert.NoError(t, err)
+	assert.Equal(t, original, stripped)
+	assertSamePixels(t, withExif, stripped)
+
+	_, err = Strip(withExif[:ihdrEnd+4], "image/png")
+	assert.Equal(t, ErrInvalidImage, err)
+
+	withOrientation := append([]byte{}, original[:ihdrEnd]...)
+	withOrientation = append(withOrientation, chunk("eXIf", exifWithOrientation(binary.LittleEndian, 8))...)
+	withOrientation = append(withOrientation, original[ihdrEnd:]...)
+	stripped, err = Strip(withOrientation, "image/png")
+	assert.NoError(t, err)
+	expected := append([]byte{}, original[:ihdrEnd]...)
+	expected = append(expected, chunk("eXIf", orientationOnlyEXIF(exifWithOrientation(binary.LittleEndian, 8)))...)
+	expected = append(expected, original[ihdrEnd:]...)
+	assert.Equal(t, expected, stripped)
+	assertSamePixels(t, withOrientation, stripped)
+}
+
+func TestStripWebP(t *testing.T) {
+	chunk := func(typ string, data []byte) []byte {
+		c := append([]byte(typ), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(c[4:], uint32(len(data)))
+		c = append(c, data...)
+		if len(data)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	webp := func(chunks ...[]byte) []byte {
+		content := []byte("RIFF\x00\x00\x00\x00WEBP")
+		for _, c := range chunks {
+			content = append(content, c...)
+		}
+		binary.LittleEndian.PutUint32(content[4:], uint32(len(content)-8))
+		return content
+	}
+	// the image data itself is opaque to the stripping, any bytes will do
+	bitstream := chunk("VP8L", []byte("\x2f\x00\x00\x00\x00pixels"))
+
+	withExif := webp(chunk("VP8X", []byte{webpFlagEXIF | webpFlagXMP | 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0}), bitstream, chunk("EXIF", exifSample[6:]), chunk("XMP ", []byte("<x:xmpmeta/>")))
+	stripped, err := Strip(withExif, "image/webp")
+	assert.NoError(t, err)
+	// only the alpha flag is left
+	assert.Equal(t, webp(chunk("VP8X", []byte{0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0}), bitstream), stripped)
+
+	_, err = Strip(withExif[:len(withExif)-3], "image/webp")
+	assert.Equal(t, ErrInvalidImage, err)
+
+	// the EXIF flag stays set when the orientation is kept
+	withOrientation := webp(chunk("VP8X", []byte{webpFlagEXIF | webpFlagXMP, 0, 0, 0, 0, 0, 0, 0, 0, 0}), bitstream, chunk("EXIF", exifWithOrientation(binary.LittleEndian, 3)), chunk("XMP ", []byte("<x:xmpmeta/>")))
+	stripped, err = Strip(withOrientation, "image/webp")
+	assert.NoError(t, err)
+	assert.Equal(t, webp(chunk("VP8X", []byte{webpFlagEXIF, 0, 0, 0, 0, 0, 0, 0, 0, 0}), bitstream, chunk("EXIF", orientationOnlyEXIF(exifWithOrientation(binary.LittleEndian, 3)))), stripped)
+}
+
+func TestStripOtherTypes(t *testing.T) {
+	content := []byte("GIF89a")
+	stripped, err := Strip(content, "image/gif")
+	assert.NoError(t, err)
+	assert.Equal(t, content, stripped)
+	assert.False(t, IsStrippable("image/gif"))
+	assert.True(t, IsStrippable("image/webp"))
+}
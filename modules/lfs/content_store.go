@@ -61,6 +61,29 @@ func (s *ContentStore) Get(pointer Pointer) (storage.Object, error) {
 	return f, err
 }
 
+// GetRange retrieves length bytes of the content starting at offset. Only that part is transferred
+// from object storages which support ranged requests.
+func (s *ContentStore) GetRange(pointer Pointer, offset, length int64) (io.ReadCloser, error) {
+	rc, err := storage.OpenRange(s.ObjectStorage, pointer.RelativePath(), offset, length)
+	if err != nil {
+		log.Error("Whilst trying to read LFS OID[%s]: Unable to open range %d+%d Error: %v", pointer.Oid, offset, length, err)
+		return nil, err
+	}
+	return rc, nil
+}
+
+// RangeObject is the content of an LFS object whose parts can be read on their own
+type RangeObject struct {
+	storage.Object
+	store   *ContentStore
+	pointer Pointer
+}
+
+// ReadRange opens length bytes of the content starting at offset
+func (o *RangeObject) ReadRange(offset, length int64) (io.ReadCloser, error) {
+	return o.store.GetRange(o.pointer, offset, length)
+}
+
 // Put takes a Meta object and an io.Reader and writes the content to the store.
 func (s *ContentStore) Put(pointer Pointer, r io.Reader) error {
 	p := pointer.RelativePath()
@@ -114,11 +137,22 @@ func (s *ContentStore) Verify(pointer Pointer) (bool, error) {
 }
 
 // ReadMetaObject will read a models.LFSMetaObject and return a reader
-func ReadMetaObject(pointer Pointer) (io.ReadCloser, error) {
+func ReadMetaObject(pointer Pointer) (storage.Object, error) {
 	contentStore := NewContentStore()
 	return contentStore.Get(pointer)
 }
 
+// ReadMetaObjectRanges will read a models.LFSMetaObject like ReadMetaObject, but parts of the returned
+// object may also be read on their own without reading the object from its start
+func ReadMetaObjectRanges(pointer Pointer) (*RangeObject, error) {
+	contentStore := NewContentStore()
+	obj, err := contentStore.Get(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return &RangeObject{Object: obj, store: contentStore, pointer: pointer}, nil
+}
+
 type hashingReader struct {
 	internal     io.Reader
 	currentSize  int64
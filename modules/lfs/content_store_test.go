@@ -0,0 +1,65 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package lfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/modules/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rangeStorage is an object storage holding a single object which honours ranged reads
+type rangeStorage struct {
+	storage.ObjectStorage
+	content []byte
+	opened  [][2]int64
+}
+
+func (s *rangeStorage) OpenRange(path string, offset, length int64) (io.ReadCloser, error) {
+	s.opened = append(s.opened, [2]int64{offset, length})
+	end := offset + length
+	if end > int64(len(s.content)) {
+		end = int64(len(s.content))
+	}
+	return io.NopCloser(bytes.NewReader(s.content[offset:end])), nil
+}
+
+func TestContentStoreGetRange(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	pointer, err := GeneratePointer(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	t.Run("RangeOpener", func(t *testing.T) {
+		objects := &rangeStorage{content: []byte(content)}
+		rc, err := (&ContentStore{ObjectStorage: objects}).GetRange(pointer, 25, 10)
+		assert.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		assert.Equal(t, content[25:35], string(data))
+		// only the range has been asked for
+		assert.Equal(t, [][2]int64{{25, 10}}, objects.opened)
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		local, err := storage.NewLocalStorage(context.Background(), storage.LocalStorageConfig{Path: t.TempDir()})
+		assert.NoError(t, err)
+		store := &ContentStore{ObjectStorage: local}
+		assert.NoError(t, store.Put(pointer, strings.NewReader(content)))
+
+		rc, err := store.GetRange(pointer, 25, 10)
+		assert.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		assert.Equal(t, content[25:35], string(data))
+	})
+}
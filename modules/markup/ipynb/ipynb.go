@@ -0,0 +1,148 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ipynb
+
+import (
+	"bufio"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/markup"
+	"code.gitea.io/gitea/modules/markup/markdown"
+	"code.gitea.io/gitea/modules/setting"
+)
+
+// MarkupName describes markup's name
+const MarkupName = "ipynb"
+
+func init() {
+	markup.RegisterRenderer(Renderer{})
+}
+
+var (
+	ansiEscapeRegex  = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	base64ImageRegex = regexp.MustCompile(`^[A-Za-z0-9+/]+=*$`)
+)
+
+// Renderer implements markup.Renderer for Jupyter notebooks
+type Renderer struct{}
+
+// Name implements markup.Renderer
+func (Renderer) Name() string {
+	return MarkupName
+}
+
+// NeedPostProcess implements markup.Renderer
+func (Renderer) NeedPostProcess() bool { return false }
+
+// Extensions implements markup.Renderer
+func (Renderer) Extensions() []string {
+	return []string{".ipynb"}
+}
+
+// SanitizerRules implements markup.Renderer
+func (Renderer) SanitizerRules() []setting.MarkupSanitizerRule {
+	return []setting.MarkupSanitizerRule{
+		{Element: "div", AllowAttr: "class", Regexp: regexp.MustCompile(`^(notebook|cell (markdown|code|raw)|output)$`)},
+		{Element: "pre", AllowAttr: "class", Regexp: regexp.MustCompile(`^(input|output|output error)$`)},
+		{AllowDataURIImages: true},
+	}
+}
+
+// multilineString is a string of a notebook, which may be split into a list of lines
+type multilineString string
+
+// UnmarshalJSON implements json.Unmarshaler, values which are no strings are ignored
+func (s *multilineString) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*s = multilineString(strings.Join(lines, ""))
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = multilineString(str)
+	}
+	return nil
+}
+
+type notebook struct {
+	Cells []struct {
+		CellType string          `json:"cell_type"`
+		Source   multilineString `json:"source"`
+		Outputs  []output        `json:"outputs"`
+	} `json:"cells"`
+}
+
+type output struct {
+	OutputType string                     `json:"output_type"`
+	Text       multilineString            `json:"text"`
+	Data       map[string]multilineString `json:"data"`
+	EName      string                     `json:"ename"`
+	EValue     string                     `json:"evalue"`
+	Traceback  []string                   `json:"traceback"`
+}
+
+// Render renders a Jupyter notebook to HTML
+func (Renderer) Render(ctx *markup.RenderContext, input io.Reader, output io.Writer) error {
+	var nb notebook
+	if err := json.NewDecoder(input).Decode(&nb); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(output)
+	_, _ = w.WriteString(`<div class="notebook">`)
+	for _, cell := range nb.Cells {
+		switch cell.CellType {
+		case "markdown":
+			rendered, err := markdown.RenderRawString(&markup.RenderContext{Ctx: ctx.Ctx, URLPrefix: ctx.URLPrefix}, string(cell.Source))
+			if err != nil {
+				return err
+			}
+			_, _ = w.WriteString(`<div class="cell markdown">` + rendered + `</div>`)
+		case "code":
+			_, _ = w.WriteString(`<div class="cell code"><pre class="input"><code>` + html.EscapeString(string(cell.Source)) + `</code></pre>`)
+			for _, out := range cell.Outputs {
+				writeOutput(w, out)
+			}
+			_, _ = w.WriteString(`</div>`)
+		default:
+			_, _ = w.WriteString(`<div class="cell raw"><pre>` + html.EscapeString(string(cell.Source)) + `</pre></div>`)
+		}
+	}
+	_, _ = w.WriteString(`</div>`)
+	return w.Flush()
+}
+
+// writeOutput writes the output of a code cell, preferring images over HTML over plain text
+func writeOutput(w *bufio.Writer, out output) {
+	switch out.OutputType {
+	case "stream":
+		_, _ = w.WriteString(`<pre class="output">` + html.EscapeString(string(out.Text)) + `</pre>`)
+	case "error":
+		traceback := ansiEscapeRegex.ReplaceAllString(strings.Join(out.Traceback, "\n"), "")
+		if len(traceback) == 0 {
+			traceback = out.EName + ": " + out.EValue
+		}
+		_, _ = w.WriteString(`<pre class="output error">` + html.EscapeString(traceback) + `</pre>`)
+	case "execute_result", "display_data":
+		for _, mimeType := range []string{"image/png", "image/jpeg", "image/gif"} {
+			data := strings.Join(strings.Fields(string(out.Data[mimeType])), "")
+			if len(data) > 0 && base64ImageRegex.MatchString(data) {
+				_, _ = w.WriteString(`<div class="output"><img src="data:` + mimeType + `;base64,` + data + `"></div>`)
+				return
+			}
+		}
+		if htmlData, ok := out.Data["text/html"]; ok {
+			// the sanitizer removes anything which isn't safe to display
+			_, _ = w.WriteString(`<div class="output">` + string(htmlData) + `</div>`)
+		} else if text, ok := out.Data["text/plain"]; ok {
+			_, _ = w.WriteString(`<pre class="output">` + html.EscapeString(string(text)) + `</pre>`)
+		}
+	}
+}
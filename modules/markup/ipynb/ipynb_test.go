@@ -0,0 +1,57 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ipynb
+
+import (
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/modules/markup"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testNotebook = `{
+ "cells": [
+  {"cell_type": "markdown", "metadata": {}, "source": ["# Title\n", "Some *text*"]},
+  {"cell_type": "code", "execution_count": 1, "metadata": {}, "source": "print(1 < 2)",
+   "outputs": [{"output_type": "stream", "name": "stdout", "text": ["True\n"]}]},
+  {"cell_type": "code", "execution_count": 2, "metadata": {}, "source": ["df"],
+   "outputs": [{"output_type": "execute_result", "execution_count": 2, "metadata": {},
+    "data": {"text/plain": ["   a\n", "0  1"], "text/html": ["<table><tr><td onclick=\"alert(1)\">1</td></tr></table><script>alert(1)</script>"]}}]},
+  {"cell_type": "code", "execution_count": 3, "metadata": {}, "source": ["plot()"],
+   "outputs": [{"output_type": "display_data", "metadata": {},
+    "data": {"image/png": "iVBORw0KGgo=\n", "text/plain": ["<Figure>"]}}]},
+  {"cell_type": "code", "execution_count": 4, "metadata": {}, "source": ["1/0"],
+   "outputs": [{"output_type": "error", "ename": "ZeroDivisionError", "evalue": "division by zero",
+    "traceback": ["\u001b[0;31mZeroDivisionError\u001b[0m: division by zero"]}]},
+  {"cell_type": "raw", "metadata": {}, "source": ["<b>raw</b>"]}
+ ],
+ "metadata": {},
+ "nbformat": 4,
+ "nbformat_minor": 5
+}`
+
+func TestRenderNotebook(t *testing.T) {
+	var buf strings.Builder
+	err := markup.Render(&markup.RenderContext{Type: MarkupName}, strings.NewReader(testNotebook), &buf)
+	assert.NoError(t, err)
+	rendered := buf.String()
+
+	assert.Contains(t, rendered, `<div class="notebook">`)
+	assert.Contains(t, rendered, `<div class="cell markdown"><h1 id="user-content-title">Title</h1>`)
+	assert.Contains(t, rendered, `<em>text</em>`)
+	assert.Contains(t, rendered, `<pre class="input"><code>print(1 &lt; 2)</code></pre><pre class="output">True
+</pre>`)
+	assert.Contains(t, rendered, `<div class="output"><table><tr><td>1</td></tr></table></div>`)
+	assert.Contains(t, rendered, `<div class="output"><img src="data:image/png;base64,iVBORw0KGgo="></div>`)
+	assert.Contains(t, rendered, `<pre class="output error">ZeroDivisionError: division by zero</pre>`)
+	assert.Contains(t, rendered, `<div class="cell raw"><pre>&lt;b&gt;raw&lt;/b&gt;</pre></div>`)
+	assert.NotContains(t, rendered, "alert")
+	assert.NotContains(t, rendered, "&lt;Figure&gt;")
+
+	err = markup.Render(&markup.RenderContext{Type: MarkupName}, strings.NewReader(`{"cells": `), &buf)
+	assert.Error(t, err)
+}
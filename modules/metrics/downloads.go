@@ -0,0 +1,31 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Responses to downloads counted by Downloads
+const (
+	DownloadFull        = "full"
+	DownloadRange       = "range"
+	DownloadNotModified = "not_modified"
+)
+
+var (
+	// Downloads counts the responses to downloads of raw files and attachments by whether they serve
+	// the full content, ranges of it or nothing since it has not been modified, and by content category
+	Downloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: namespace + "downloads_total",
+		Help: "Number of downloads by response and content category",
+	}, []string{"response", "category"})
+
+	// DownloadBytes counts the bytes written in responses to downloads by content category
+	DownloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: namespace + "download_bytes_total",
+		Help: "Number of bytes served by downloads by content category",
+	}, []string{"category"})
+)
@@ -0,0 +1,29 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package setting
+
+import "strings"
+
+// CacheControl defines custom Cache-Control directives for downloadable files
+var CacheControl = struct {
+	Enabled bool
+	Map     map[string]string
+}{
+	Enabled: false,
+	Map:     map[string]string{},
+}
+
+func newCacheControl() {
+	sec := Cfg.Section("repository.cache_control")
+	keys := sec.Keys()
+	m := make(map[string]string, len(keys))
+	for _, key := range keys {
+		m[strings.ToLower(key.Name())] = key.Value()
+	}
+	CacheControl.Map = m
+	if len(keys) > 0 {
+		CacheControl.Enabled = true
+	}
+}
@@ -34,6 +34,7 @@ var Git = struct {
 		Clone   int
 		Pull    int
 		GC      int `ini:"GC"`
+		Serve   int
 	} `ini:"git.timeout"`
 }{
 	DisableDiffHighlight:      false,
@@ -56,6 +57,7 @@ var Git = struct {
 		Clone   int
 		Pull    int
 		GC      int `ini:"GC"`
+		Serve   int
 	}{
 		Default: 360,
 		Migrate: 600,
@@ -63,6 +65,7 @@ var Git = struct {
 		Clone:   300,
 		Pull:    300,
 		GC:      60,
+		Serve:   30,
 	},
 }
 
@@ -270,6 +270,20 @@ func newAccessLogService() {
 	}
 }
 
+func newDownloadAuditLogService() {
+	EnableDownloadAuditLog = Cfg.Section("log").Key("ENABLE_DOWNLOAD_AUDIT_LOG").MustBool(false)
+	if EnableDownloadAuditLog {
+		// the records are written to the modes listed in DOWNLOAD_AUDIT, which generateNamedLogger reads by the name
+		// of the logger, to the file mode unless they are configured
+		Cfg.Section("log").Key("DOWNLOAD_AUDIT").MustString("file")
+		options := newDefaultLogOptions()
+		options.filename = filepath.Join(LogRootPath, "download_audit.log")
+		options.flags = "date,time" // the records are JSON, only the time they were written is prefixed
+		options.bufferLength = Cfg.Section("log").Key("BUFFER_LEN").MustInt64(10000)
+		generateNamedLogger("download_audit", options)
+	}
+}
+
 func newRouterLogService() {
 	Cfg.Section("log").Key("ROUTER").MustString("console")
 	// Allow [log]  DISABLE_ROUTER_LOG to override [server] DISABLE_ROUTER_LOG
@@ -354,6 +368,7 @@ func NewLogServices(disableConsole bool) {
 	newLogService()
 	newRouterLogService()
 	newAccessLogService()
+	newDownloadAuditLogService()
 	NewXORMLogService(disableConsole)
 }
 
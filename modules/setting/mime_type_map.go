@@ -6,19 +6,42 @@ package setting
 
 import "strings"
 
+// defaultMimeTypeMap contains the types of extensions which neither the mime package nor the
+// content can tell apart from plain JSON. The [repository.mimetype_mapping] section overrides them.
+var defaultMimeTypeMap = map[string]string{
+	".geojson": "application/geo+json",
+	// TopoJSON has no registered type of its own
+	".topojson": "application/json",
+}
+
 // MimeTypeMap defines custom mime type mapping settings
 var MimeTypeMap = struct {
 	Enabled bool
 	Map     map[string]string
 }{
 	Enabled: false,
-	Map:     map[string]string{},
+	Map:     newDefaultMimeTypeMap(),
+}
+
+func newDefaultMimeTypeMap() map[string]string {
+	m := make(map[string]string, len(defaultMimeTypeMap))
+	for ext, mimeType := range defaultMimeTypeMap {
+		m[ext] = mimeType
+	}
+	return m
+}
+
+// DefaultMimeType returns the type the extension is mapped to by default, which applies whether or not
+// the [repository.mimetype_mapping] section enables MimeTypeMap
+func DefaultMimeType(ext string) (string, bool) {
+	mimeType, ok := defaultMimeTypeMap[ext]
+	return mimeType, ok
 }
 
 func newMimeTypeMap() {
 	sec := Cfg.Section("repository.mimetype_mapping")
 	keys := sec.Keys()
-	m := make(map[string]string, len(keys))
+	m := newDefaultMimeTypeMap()
 	for _, key := range keys {
 		m[strings.ToLower(key.Name())] = key.Value()
 	}
@@ -0,0 +1,44 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package setting
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ini "gopkg.in/ini.v1"
+)
+
+func TestNewMimeTypeMap(t *testing.T) {
+	defer func(enabled bool, m map[string]string) {
+		MimeTypeMap.Enabled = enabled
+		MimeTypeMap.Map = m
+	}(MimeTypeMap.Enabled, MimeTypeMap.Map)
+
+	// the defaults don't enable the mapping
+	MimeTypeMap.Enabled = false
+	Cfg = ini.Empty()
+	newMimeTypeMap()
+	assert.False(t, MimeTypeMap.Enabled)
+	assert.Equal(t, "application/geo+json", MimeTypeMap.Map[".geojson"])
+
+	iniStr := `
+[repository.mimetype_mapping]
+.APK = application/vnd.android.package-archive
+.geojson =
+`
+	Cfg, _ = ini.Load([]byte(iniStr))
+	newMimeTypeMap()
+	assert.True(t, MimeTypeMap.Enabled)
+	assert.Equal(t, "application/vnd.android.package-archive", MimeTypeMap.Map[".apk"])
+	assert.Equal(t, "", MimeTypeMap.Map[".geojson"])
+	assert.Equal(t, "application/json", MimeTypeMap.Map[".topojson"])
+
+	mimeType, ok := DefaultMimeType(".geojson")
+	assert.True(t, ok)
+	assert.Equal(t, "application/geo+json", mimeType)
+	_, ok = DefaultMimeType(".apk")
+	assert.False(t, ok)
+}
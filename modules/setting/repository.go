@@ -8,8 +8,11 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"code.gitea.io/gitea/modules/log"
+
+	"golang.org/x/net/html/charset"
 )
 
 // enumerates all the policy repository creating
@@ -25,6 +28,9 @@ var (
 		DetectedCharsetsOrder                   []string
 		DetectedCharsetScore                    map[string]int `ini:"-"`
 		AnsiCharset                             string
+		DefaultCharset                          string
+		MinCharsetConfidence                    int
+		CharsetCacheSize                        int
 		ForcePrivate                            bool
 		DefaultPrivate                          string
 		DefaultPushCreatePrivate                bool
@@ -32,6 +38,12 @@ var (
 		PreferredLicenses                       []string
 		DisableHTTPGit                          bool
 		AccessControlAllowOrigin                string
+		ServeContentDigest                      bool
+		MaxETagFileSize                         int64 `ini:"MAX_ETAG_FILE_SIZE"`
+		MaxContentDigestFileSize                int64
+		DownloadFilenameTemplate                string
+		DownloadSessionExpiry                   time.Duration
+		ServeSymlinks                           string
 		UseCompatSSHURI                         bool
 		DefaultCloseIssuesViaCommitsInAnyBranch bool
 		EnablePushCreateUser                    bool
@@ -100,6 +112,7 @@ var (
 			DefaultTrustModel string
 		} `ini:"repository.signing"`
 	}{
+		DefaultCharset: "utf-8",
 		DetectedCharsetsOrder: []string{
 			"UTF-8",
 			"UTF-16BE",
@@ -137,6 +150,7 @@ var (
 		},
 		DetectedCharsetScore:                    map[string]int{},
 		AnsiCharset:                             "",
+		CharsetCacheSize:                        512,
 		ForcePrivate:                            false,
 		DefaultPrivate:                          RepoCreatingLastUserVisibility,
 		DefaultPushCreatePrivate:                true,
@@ -144,6 +158,9 @@ var (
 		PreferredLicenses:                       []string{"Apache License 2.0", "MIT License"},
 		DisableHTTPGit:                          false,
 		AccessControlAllowOrigin:                "",
+		MaxContentDigestFileSize:                100 << 20,
+		DownloadSessionExpiry:                   24 * time.Hour,
+		ServeSymlinks:                           "text",
 		UseCompatSSHURI:                         false,
 		DefaultCloseIssuesViaCommitsInAnyBranch: false,
 		EnablePushCreateUser:                    false,
@@ -296,6 +313,22 @@ func newRepository() {
 		Repository.Signing.DefaultTrustModel = "collaborator"
 	}
 
+	// Handle the fallback charset for served files
+	Repository.DefaultCharset = strings.ToLower(strings.TrimSpace(Repository.DefaultCharset))
+	if enc, _ := charset.Lookup(Repository.DefaultCharset); enc == nil {
+		log.Fatal("Unsupported [repository] DEFAULT_CHARSET: %q", Repository.DefaultCharset)
+	}
+	if Repository.MinCharsetConfidence < 0 || Repository.MinCharsetConfidence > 100 {
+		log.Fatal("[repository] MIN_CHARSET_CONFIDENCE must be between 0 and 100, not %d", Repository.MinCharsetConfidence)
+	}
+	if Repository.DownloadSessionExpiry <= 0 {
+		log.Fatal("[repository] DOWNLOAD_SESSION_EXPIRY must be positive, not %v", Repository.DownloadSessionExpiry)
+	}
+	Repository.ServeSymlinks = strings.ToLower(strings.TrimSpace(Repository.ServeSymlinks))
+	if Repository.ServeSymlinks != "text" && Repository.ServeSymlinks != "redirect" {
+		log.Fatal("[repository] SERVE_SYMLINKS must be either text or redirect, not %q", Repository.ServeSymlinks)
+	}
+
 	// Handle preferred charset orders
 	preferred := make([]string, 0, len(Repository.DetectedCharsetsOrder))
 	for _, charset := range Repository.DetectedCharsetsOrder {
@@ -62,6 +62,20 @@ var Service = struct {
 	DefaultOrgMemberVisible                 bool
 	UserDeleteWithCommentsMaxTime           time.Duration
 	ValidSiteURLSchemes                     []string
+	MaxDownloadBandwidthPerRequest          int64
+	MaxDownloadFileSize                     int64
+	MaxDownloadFileSizeAllowRanges          bool
+	RawFileCORSOrigins                      []string
+	RawFileCORSMaxAge                       time.Duration
+	RawFileFrameAncestors                   string
+
+	// DownloadRateLimit limits the downloads of every user, or every IP address of anonymous users,
+	// to Requests and Bytes per Window. A limit of 0 is no limit.
+	DownloadRateLimit struct {
+		Requests int
+		Bytes    int64
+		Window   time.Duration
+	}
 
 	// OpenID settings
 	EnableOpenIDSignIn bool
@@ -76,6 +90,8 @@ var Service = struct {
 	} `ini:"service.explore"`
 }{
 	AllowedUserVisibilityModesSlice: []bool{true, true, true},
+	RawFileFrameAncestors:           "'self'",
+	RawFileCORSMaxAge:               10 * time.Minute,
 }
 
 // AllowedVisibility store in a 3 item bool array what is allowed
@@ -162,6 +178,21 @@ func newService() {
 	Service.UserDeleteWithCommentsMaxTime = sec.Key("USER_DELETE_WITH_COMMENTS_MAX_TIME").MustDuration(0)
 	sec.Key("VALID_SITE_URL_SCHEMES").MustString("http,https")
 	Service.ValidSiteURLSchemes = sec.Key("VALID_SITE_URL_SCHEMES").Strings(",")
+	Service.MaxDownloadBandwidthPerRequest = sec.Key("MAX_DOWNLOAD_BANDWIDTH_PER_REQUEST").MustInt64(0)
+	Service.MaxDownloadFileSize = sec.Key("MAX_DOWNLOAD_FILE_SIZE").MustInt64(0)
+	Service.MaxDownloadFileSizeAllowRanges = sec.Key("MAX_DOWNLOAD_FILE_SIZE_ALLOW_RANGES").MustBool(false)
+	Service.RawFileCORSOrigins = sec.Key("RAW_FILE_CORS_ORIGINS").Strings(",")
+	Service.RawFileCORSMaxAge = sec.Key("RAW_FILE_CORS_MAX_AGE").MustDuration(10 * time.Minute)
+	Service.RawFileFrameAncestors = sec.Key("RAW_FILE_FRAME_ANCESTORS").MustString("'self'")
+	Service.DownloadRateLimit.Requests = sec.Key("DOWNLOAD_RATE_LIMIT_REQUESTS").MustInt(0)
+	Service.DownloadRateLimit.Bytes = sec.Key("DOWNLOAD_RATE_LIMIT_BYTES").MustInt64(0)
+	Service.DownloadRateLimit.Window = sec.Key("DOWNLOAD_RATE_LIMIT_WINDOW").MustDuration(time.Minute)
+	if Service.DownloadRateLimit.Window <= 0 {
+		log.Fatal("Invalid [service] DOWNLOAD_RATE_LIMIT_WINDOW %v: it must be positive", Service.DownloadRateLimit.Window)
+	}
+	if err := validateContentSecurityPolicy("frame-ancestors " + Service.RawFileFrameAncestors); err != nil {
+		log.Fatal("Invalid [service] RAW_FILE_FRAME_ANCESTORS %q: %v", Service.RawFileFrameAncestors, err)
+	}
 	schemes := make([]string, len(Service.ValidSiteURLSchemes))
 	for _, scheme := range Service.ValidSiteURLSchemes {
 		scheme = strings.ToLower(strings.TrimSpace(scheme))
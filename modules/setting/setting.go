@@ -7,6 +7,7 @@ package setting
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -63,6 +64,38 @@ const (
 	HCaptcha     = "hcaptcha"
 )
 
+// maxSniffSampleSize is the upper bound of [ui] SNIFF_SAMPLE_SIZE
+const maxSniffSampleSize = 1024 * 1024
+
+// validateContentSecurityPolicy checks that csp is a non-empty list of directives
+// following the grammar of https://www.w3.org/TR/CSP3/#framework-directives
+func validateContentSecurityPolicy(csp string) error {
+	directives := 0
+	for _, directive := range strings.Split(csp, ";") {
+		directive = strings.TrimSpace(directive)
+		if len(directive) == 0 {
+			continue
+		}
+		fields := strings.Fields(directive)
+		for _, c := range fields[0] {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("invalid directive name %q", fields[0])
+			}
+		}
+		for _, c := range directive {
+			// a value may contain neither control characters nor a comma, which would start another policy
+			if c < 0x20 && c != '\t' || c > 0x7e || c == ',' {
+				return fmt.Errorf("invalid character %q in directive %q", c, directive)
+			}
+		}
+		directives++
+	}
+	if directives == 0 {
+		return errors.New("no directives")
+	}
+	return nil
+}
+
 // settings
 var (
 	// AppVer is the version of the current build of Gitea. It is set in main.go from main.Version.
@@ -195,27 +228,41 @@ var (
 
 	// UI settings
 	UI = struct {
-		ExplorePagingNum      int
-		IssuePagingNum        int
-		RepoSearchPagingNum   int
-		MembersPagingNum      int
-		FeedMaxCommitNum      int
-		FeedPagingNum         int
-		GraphMaxCommitNum     int
-		CodeCommentLines      int
-		ReactionMaxUserNum    int
-		ThemeColorMetaTag     string
-		MaxDisplayFileSize    int64
-		ShowUserEmail         bool
-		DefaultShowFullName   bool
-		DefaultTheme          string
-		Themes                []string
-		Reactions             []string
-		ReactionsMap          map[string]bool `ini:"-"`
-		CustomEmojis          []string
-		CustomEmojisMap       map[string]string `ini:"-"`
-		SearchRepoDescription bool
-		UseServiceWorker      bool
+		ExplorePagingNum          int
+		IssuePagingNum            int
+		RepoSearchPagingNum       int
+		MembersPagingNum          int
+		FeedMaxCommitNum          int
+		FeedPagingNum             int
+		GraphMaxCommitNum         int
+		CodeCommentLines          int
+		ReactionMaxUserNum        int
+		ThemeColorMetaTag         string
+		MaxDisplayFileSize        int64
+		ShowUserEmail             bool
+		DefaultShowFullName       bool
+		DefaultTheme              string
+		Themes                    []string
+		Reactions                 []string
+		ReactionsMap              map[string]bool `ini:"-"`
+		CustomEmojis              []string
+		CustomEmojisMap           map[string]string `ini:"-"`
+		SearchRepoDescription     bool
+		UseServiceWorker          bool
+		SniffSampleSize           int
+		RenderSampleSize          int
+		MaxRenderFileSize         int64
+		CompressServedContent     bool
+		ServePrecompressedGzip    bool
+		ServePrecompressedBrotli  bool
+		StripImageMetadata        bool
+		AllowRawHTMLPreview       bool
+		InlineContentTypes        []string
+		InlineHEIC                bool `ini:"INLINE_HEIC"`
+		ForceAttachmentExtensions []string
+		SaveDataInlineMaxSize     int64
+		PreloadRenderedAssets     []string
+		TextContentDisposition    string
 
 		Notification struct {
 			MinTimeout            time.Duration
@@ -225,9 +272,21 @@ var (
 		} `ini:"ui.notification"`
 
 		SVG struct {
-			Enabled bool `ini:"ENABLE_RENDER"`
+			Enabled               bool `ini:"ENABLE_RENDER"`
+			ContentSecurityPolicy string
 		} `ini:"ui.svg"`
 
+		MaxInlineMediaSize struct {
+			Image int64
+			PDF   int64 `ini:"PDF"`
+		} `ini:"ui.max_inline_media_size"`
+
+		Thumbnail struct {
+			MaxWidth      int
+			MaxHeight     int
+			MaxSourceSize int64
+		} `ini:"ui.thumbnail"`
+
 		CSV struct {
 			MaxFileSize int64
 		} `ini:"ui.csv"`
@@ -247,22 +306,27 @@ var (
 			Keywords    string
 		} `ini:"ui.meta"`
 	}{
-		ExplorePagingNum:    20,
-		IssuePagingNum:      10,
-		RepoSearchPagingNum: 10,
-		MembersPagingNum:    20,
-		FeedMaxCommitNum:    5,
-		FeedPagingNum:       20,
-		GraphMaxCommitNum:   100,
-		CodeCommentLines:    4,
-		ReactionMaxUserNum:  10,
-		ThemeColorMetaTag:   `#6cc644`,
-		MaxDisplayFileSize:  8388608,
-		DefaultTheme:        `auto`,
-		Themes:              []string{`auto`, `gitea`, `arc-green`},
-		Reactions:           []string{`+1`, `-1`, `laugh`, `hooray`, `confused`, `heart`, `rocket`, `eyes`},
-		CustomEmojis:        []string{`git`, `gitea`, `codeberg`, `gitlab`, `github`, `gogs`},
-		CustomEmojisMap:     map[string]string{"git": ":git:", "gitea": ":gitea:", "codeberg": ":codeberg:", "gitlab": ":gitlab:", "github": ":github:", "gogs": ":gogs:"},
+		ExplorePagingNum:      20,
+		IssuePagingNum:        10,
+		RepoSearchPagingNum:   10,
+		MembersPagingNum:      20,
+		FeedMaxCommitNum:      5,
+		FeedPagingNum:         20,
+		GraphMaxCommitNum:     100,
+		CodeCommentLines:      4,
+		ReactionMaxUserNum:    10,
+		ThemeColorMetaTag:     `#6cc644`,
+		MaxDisplayFileSize:    8388608,
+		SniffSampleSize:       1024,
+		RenderSampleSize:      65536,
+		MaxRenderFileSize:     52428800,
+		InlineContentTypes:    []string{`image/*`, `application/pdf`, `audio/*`, `video/*`, `font/*`, `application/vnd.ms-fontobject`, `application/wasm`},
+		SaveDataInlineMaxSize: 1048576,
+		DefaultTheme:          `auto`,
+		Themes:                []string{`auto`, `gitea`, `arc-green`},
+		Reactions:             []string{`+1`, `-1`, `laugh`, `hooray`, `confused`, `heart`, `rocket`, `eyes`},
+		CustomEmojis:          []string{`git`, `gitea`, `codeberg`, `gitlab`, `github`, `gogs`},
+		CustomEmojisMap:       map[string]string{"git": ":git:", "gitea": ":gitea:", "codeberg": ":codeberg:", "gitlab": ":gitlab:", "github": ":github:", "gogs": ":gogs:"},
 		Notification: struct {
 			MinTimeout            time.Duration
 			TimeoutStep           time.Duration
@@ -275,9 +339,20 @@ var (
 			EventSourceUpdateTime: 10 * time.Second,
 		},
 		SVG: struct {
-			Enabled bool `ini:"ENABLE_RENDER"`
+			Enabled               bool `ini:"ENABLE_RENDER"`
+			ContentSecurityPolicy string
 		}{
-			Enabled: true,
+			Enabled:               true,
+			ContentSecurityPolicy: "default-src 'none'; style-src 'unsafe-inline'; sandbox",
+		},
+		Thumbnail: struct {
+			MaxWidth      int
+			MaxHeight     int
+			MaxSourceSize int64
+		}{
+			MaxWidth:      1024,
+			MaxHeight:     1024,
+			MaxSourceSize: 10485760,
 		},
 		CSV: struct {
 			MaxFileSize int64
@@ -341,6 +416,8 @@ var (
 	EnableAccessLog   bool
 	AccessLogTemplate string
 
+	EnableDownloadAuditLog bool
+
 	// Time settings
 	TimeFormat string
 	// UILocation is the location on the UI, so that we can display the time on UI.
@@ -359,6 +436,7 @@ var (
 		DefaultPagingNum       int
 		DefaultGitTreesPerPage int
 		DefaultMaxBlobSize     int64
+		MaxRawJSONSize         int64 `ini:"MAX_RAW_JSON_SIZE"`
 	}{
 		EnableSwagger:          true,
 		SwaggerURL:             "",
@@ -366,6 +444,7 @@ var (
 		DefaultPagingNum:       30,
 		DefaultGitTreesPerPage: 1000,
 		DefaultMaxBlobSize:     10485760,
+		MaxRawJSONSize:         1048576,
 	}
 
 	OAuth2 = struct {
@@ -1012,6 +1091,29 @@ func loadFromConf(allowEmpty bool, extraConfig string) {
 	UI.DefaultShowFullName = Cfg.Section("ui").Key("DEFAULT_SHOW_FULL_NAME").MustBool(false)
 	UI.SearchRepoDescription = Cfg.Section("ui").Key("SEARCH_REPO_DESCRIPTION").MustBool(true)
 	UI.UseServiceWorker = Cfg.Section("ui").Key("USE_SERVICE_WORKER").MustBool(true)
+	UI.CompressServedContent = Cfg.Section("ui").Key("COMPRESS_SERVED_CONTENT").MustBool(false)
+	UI.ServePrecompressedGzip = Cfg.Section("ui").Key("SERVE_PRECOMPRESSED_GZIP").MustBool(false)
+	UI.ServePrecompressedBrotli = Cfg.Section("ui").Key("SERVE_PRECOMPRESSED_BROTLI").MustBool(false)
+	UI.StripImageMetadata = Cfg.Section("ui").Key("STRIP_IMAGE_METADATA").MustBool(false)
+	if UI.SniffSampleSize <= 0 {
+		UI.SniffSampleSize = 1024
+	} else if UI.SniffSampleSize > maxSniffSampleSize {
+		log.Warn("[ui] SNIFF_SAMPLE_SIZE %d is too large, using %d instead", UI.SniffSampleSize, maxSniffSampleSize)
+		UI.SniffSampleSize = maxSniffSampleSize
+	}
+	UI.TextContentDisposition = strings.ToLower(strings.TrimSpace(UI.TextContentDisposition))
+	if UI.TextContentDisposition == "" {
+		UI.TextContentDisposition = "inline"
+	} else if UI.TextContentDisposition != "inline" && UI.TextContentDisposition != "attachment" {
+		log.Fatal("[ui] TEXT_CONTENT_DISPOSITION must be either inline or attachment, not %q", UI.TextContentDisposition)
+	}
+	if UI.RenderSampleSize > maxSniffSampleSize {
+		log.Warn("[ui] RENDER_SAMPLE_SIZE %d is too large, using %d instead", UI.RenderSampleSize, maxSniffSampleSize)
+		UI.RenderSampleSize = maxSniffSampleSize
+	}
+	if err = validateContentSecurityPolicy(UI.SVG.ContentSecurityPolicy); err != nil {
+		log.Fatal("Invalid [ui.svg] CONTENT_SECURITY_POLICY %q: %v", UI.SVG.ContentSecurityPolicy, err)
+	}
 
 	HasRobotsTxt, err = util.IsFile(path.Join(CustomPath, "robots.txt"))
 	if err != nil {
@@ -1242,6 +1344,7 @@ func NewServices() {
 	NewQueueService()
 	newProject()
 	newMimeTypeMap()
+	newCacheControl()
 	newFederationService()
 }
 
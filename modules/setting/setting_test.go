@@ -31,3 +31,22 @@ func TestMakeManifestData(t *testing.T) {
 	jsonBytes := MakeManifestData(`Example App '\"`, "https://example.com", "https://example.com/foo/bar")
 	assert.True(t, json.Valid(jsonBytes))
 }
+
+func TestValidateContentSecurityPolicy(t *testing.T) {
+	for _, csp := range []string{
+		"default-src 'none'; style-src 'unsafe-inline'; sandbox",
+		"default-src 'none'; img-src data: https://example.com; sandbox;",
+		"sandbox",
+	} {
+		assert.NoError(t, validateContentSecurityPolicy(csp), csp)
+	}
+	for _, csp := range []string{
+		"",
+		" ; ",
+		"default-src 'none', script-src *",
+		"default_src 'none'",
+		"sandbox\r\nSet-Cookie: a=b",
+	} {
+		assert.Error(t, validateContentSecurityPolicy(csp), csp)
+	}
+}
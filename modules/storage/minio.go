@@ -130,6 +130,20 @@ func (m *MinioStorage) Open(path string) (Object, error) {
 	return &minioObject{object}, nil
 }
 
+// OpenRange opens a part of a file with a ranged request, so that only the part is transferred
+func (m *MinioStorage) OpenRange(path string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+	// unlike the object of Open, which is read lazily, the core API returns the body of the ranged response
+	object, _, _, err := minio.Core{Client: m.client}.GetObject(m.ctx, m.bucket, m.buildMinioPath(path), opts)
+	if err != nil {
+		return nil, convertMinioErr(err)
+	}
+	return object, nil
+}
+
 // Save save a file to minio
 func (m *MinioStorage) Save(path string, r io.Reader, size int64) (int64, error) {
 	uploadInfo, err := m.client.PutObject(
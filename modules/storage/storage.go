@@ -69,6 +69,36 @@ type ObjectStorage interface {
 	IterateObjects(func(path string, obj Object) error) error
 }
 
+// RangeOpener is implemented by object storages which can read a part of an object without reading
+// all of it from its start, e.g. with a ranged request to an S3-compatible server
+type RangeOpener interface {
+	// OpenRange opens length bytes of the object at path starting at offset
+	OpenRange(path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// OpenRange opens length bytes of the object at path starting at offset. Objects of a storage which is not
+// a RangeOpener are opened as a whole and seeked to the offset.
+func OpenRange(s ObjectStorage, path string, offset, length int64) (io.ReadCloser, error) {
+	if ro, ok := s.(RangeOpener); ok {
+		return ro.OpenRange(path, offset, length)
+	}
+	obj, err := s.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = obj.Seek(offset, io.SeekStart); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return limitedReadCloser{io.LimitReader(obj, length), obj}, nil
+}
+
+// limitedReadCloser reads a part of an object and closes the object
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
 // Copy copies a file from source ObjectStorage to dest ObjectStorage
 func Copy(dstStorage ObjectStorage, dstPath string, srcStorage ObjectStorage, srcPath string) (int64, error) {
 	f, err := srcStorage.Open(srcPath)
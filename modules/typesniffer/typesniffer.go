@@ -5,11 +5,15 @@
 package typesniffer
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 
 	"code.gitea.io/gitea/modules/util"
 )
@@ -17,22 +21,92 @@ import (
 // Use at most this many bytes to determine Content Type.
 const sniffLen = 1024
 
+// ZipSniffLen is the number of bytes needed to tell ZIP based documents apart from plain ZIP archives,
+// which is only possible by the names of their entries.
+const ZipSniffLen = 8192
+
 // SvgMimeType MIME type of SVG images.
 const SvgMimeType = "image/svg+xml"
 
+// WasmMimeType MIME type of WebAssembly modules.
+const WasmMimeType = "application/wasm"
+
+// ParquetMimeType MIME type of Apache Parquet files.
+const ParquetMimeType = "application/vnd.apache.parquet"
+
+// OrcMimeType MIME type of Apache ORC files.
+const OrcMimeType = "application/vnd.apache.orc"
+
+// SqliteMimeType MIME type of SQLite database files.
+const SqliteMimeType = "application/vnd.sqlite3"
+
+// IconMimeType MIME type of Windows icons and cursors, which are used as favicons.
+const IconMimeType = "image/x-icon"
+
+// PostScriptMimeType MIME type of PostScript and Encapsulated PostScript documents.
+const PostScriptMimeType = "application/postscript"
+
+// MIME types of images stored in the ISO base media file format, which browsers support to a varying degree.
+const (
+	AvifMimeType = "image/avif"
+	HeicMimeType = "image/heic"
+	HeifMimeType = "image/heif"
+)
+
+// MIME types of Office Open XML documents.
+const (
+	DocxMimeType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	XlsxMimeType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	PptxMimeType = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+)
+
+// ZipMagic is the signature every local file header of a ZIP archive starts with
+var ZipMagic = []byte("PK\x03\x04")
+
 var (
 	svgTagRegex      = regexp.MustCompile(`(?si)\A\s*(?:(<!--.*?-->|<!DOCTYPE\s+svg([\s:]+.*?>|>))\s*)*<svg[\s>\/]`)
 	svgTagInXMLRegex = regexp.MustCompile(`(?si)\A<\?xml\b.*?\?>\s*(?:(<!--.*?-->|<!DOCTYPE\s+svg([\s:]+.*?>|>))\s*)*<svg[\s>\/]`)
 )
 
+// Detector returns the MIME type of data in a format it recognizes
+type Detector func(data []byte) (mimeType string, ok bool)
+
+var (
+	detectorsMutex sync.RWMutex
+	detectors      []Detector
+)
+
+// RegisterDetector adds a detector for content types which are not recognized by DetectContentType itself.
+// Detectors are consulted in the order of their registration.
+func RegisterDetector(detector Detector) {
+	detectorsMutex.Lock()
+	defer detectorsMutex.Unlock()
+	detectors = append(detectors, detector)
+}
+
 // SniffedType contains information about a blobs type.
 type SniffedType struct {
 	contentType string
 }
 
+// NewSniffedType returns the SniffedType of content known to be of the given content type
+func NewSniffedType(contentType string) SniffedType {
+	return SniffedType{contentType}
+}
+
+// GetMimeType returns the mime type without any parameters
+func (ct SniffedType) GetMimeType() string {
+	return strings.SplitN(ct.contentType, ";", 2)[0]
+}
+
 // IsText etects if content format is plain text.
 func (ct SniffedType) IsText() bool {
-	return strings.Contains(ct.contentType, "text/")
+	return strings.Contains(ct.contentType, "text/") || ct.IsPostScript()
+}
+
+// IsHTML detects if data is a HTML document
+func (ct SniffedType) IsHTML() bool {
+	return strings.Contains(ct.contentType, "text/html")
 }
 
 // IsImage detects if data is an image format
@@ -60,12 +134,54 @@ func (ct SniffedType) IsAudio() bool {
 	return strings.Contains(ct.contentType, "audio/")
 }
 
+// IsWasm detects if data is a WebAssembly module
+func (ct SniffedType) IsWasm() bool {
+	return strings.Contains(ct.contentType, WasmMimeType)
+}
+
+// IsFont detects if data is a font format
+func (ct SniffedType) IsFont() bool {
+	return strings.Contains(ct.contentType, "font/") || strings.Contains(ct.contentType, "application/vnd.ms-fontobject")
+}
+
+// IsHEIC detects if data is a HEIC or other HEIF image, which only few browsers can display
+func (ct SniffedType) IsHEIC() bool {
+	return strings.Contains(ct.contentType, HeicMimeType) || strings.Contains(ct.contentType, HeifMimeType)
+}
+
+// IsPostScript detects if data is a PostScript document, a program written in plain text
+func (ct SniffedType) IsPostScript() bool {
+	return strings.Contains(ct.contentType, PostScriptMimeType)
+}
+
+// IsDatabase detects if data is a database file
+func (ct SniffedType) IsDatabase() bool {
+	return strings.Contains(ct.contentType, SqliteMimeType)
+}
+
 // IsRepresentableAsText returns true if file content can be represented as
 // plain text or is empty.
 func (ct SniffedType) IsRepresentableAsText() bool {
 	return ct.IsText() || ct.IsSvgImage()
 }
 
+// IsScriptableMimeType returns whether browsers may run scripts in content of the given MIME type which is displayed
+// on its own, like HTML and XML documents, SVG images and scripts. A MIME type which cannot be parsed is considered
+// scriptable, as browsers may still make something of it.
+func IsScriptableMimeType(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return true
+	}
+	switch mediaType {
+	case "text/html", "text/xml", "text/xsl", "application/xml",
+		"text/javascript", "text/ecmascript", "application/javascript", "application/ecmascript", "application/x-javascript":
+		return true
+	}
+	// XHTML, SVG and other XML documents may all contain scripts
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
 // DetectContentType extends http.DetectContentType with more content types. Defaults to text/unknown if input is empty.
 func DetectContentType(data []byte) SniffedType {
 	if len(data) == 0 {
@@ -74,6 +190,7 @@ func DetectContentType(data []byte) SniffedType {
 
 	ct := http.DetectContentType(data)
 
+	all := data
 	if len(data) > sniffLen {
 		data = data[:sniffLen]
 	}
@@ -84,9 +201,167 @@ func DetectContentType(data []byte) SniffedType {
 		ct = SvgMimeType
 	}
 
+	if isIcon(data) {
+		ct = IconMimeType
+	} else if strings.Contains(ct, IconMimeType) {
+		// anything starting with the four bytes of an icon header is taken for an icon
+		ct = "application/octet-stream"
+	}
+
+	if strings.Contains(ct, "text/plain") && bytes.HasPrefix(data, []byte("%!PS")) {
+		ct = PostScriptMimeType
+	}
+
+	if strings.Contains(ct, "application/octet-stream") || strings.Contains(ct, "application/ogg") || strings.Contains(ct, "video/mp4") || strings.Contains(ct, "video/webm") {
+		if mediaType := detectMediaType(data); mediaType != "" {
+			ct = mediaType
+		}
+	}
+
+	if strings.Contains(ct, "application/zip") {
+		// the entries which tell the kind of a document may be found well beyond the first bytes
+		if officeType := detectOfficeDocument(all); officeType != "" {
+			ct = officeType
+		}
+	}
+
+	if strings.Contains(ct, "application/octet-stream") {
+		// registered detectors may need more of the data than the built-in detection
+		if mimeType := detectRegistered(all); mimeType != "" {
+			ct = mimeType
+		}
+	}
+
 	return SniffedType{ct}
 }
 
+// detectRegistered returns the MIME type reported by the first registered detector which recognizes the data
+func detectRegistered(data []byte) string {
+	detectorsMutex.RLock()
+	defer detectorsMutex.RUnlock()
+	for _, detector := range detectors {
+		if mimeType, ok := detector(data); ok && mimeType != "" {
+			return mimeType
+		}
+	}
+	return ""
+}
+
+// detectMediaType detects WebAssembly modules, fonts, audio and video containers, columnar data files and databases
+// which http.DetectContentType does not know or reports too generically
+func detectMediaType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x00asm")):
+		return WasmMimeType
+	case bytes.HasPrefix(data, []byte("true\x00")):
+		// TrueType fonts of classic Mac OS
+		return "font/ttf"
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(data, []byte("OggS")):
+		switch {
+		case bytes.Contains(data, []byte("\x01vorbis")), bytes.Contains(data, []byte("OpusHead")), bytes.Contains(data, []byte("\x7fFLAC")):
+			return "audio/ogg"
+		case bytes.Contains(data, []byte("\x80theora")):
+			return "video/ogg"
+		}
+	case len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")):
+		switch string(data[8:12]) {
+		case "M4A ", "M4B ", "M4P ":
+			return "audio/mp4"
+		case "qt  ":
+			return "video/quicktime"
+		case "avif", "avis":
+			return AvifMimeType
+		case "heic", "heix", "hevc", "hevx":
+			return HeicMimeType
+		case "mif1", "msf1":
+			// the generic HEIF brands are refined by the compatible brands
+			return detectHeifBrand(data)
+		}
+	case bytes.HasPrefix(data, []byte("\x1A\x45\xDF\xA3")):
+		if bytes.Contains(data, []byte("\x42\x82\x88matroska")) {
+			return "video/x-matroska"
+		}
+	case len(data) >= 3 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 && data[1]&0x06 != 0 && data[2]&0xF0 != 0xF0 && data[2]&0x0C != 0x0C:
+		// MPEG audio frame sync without an ID3 tag
+		return "audio/mpeg"
+	case bytes.HasPrefix(data, []byte("PAR1")):
+		return ParquetMimeType
+	case bytes.HasPrefix(data, []byte("ORC")):
+		// ORC files repeat the magic at their tail, but the head is all a sniff sample contains
+		return OrcMimeType
+	case bytes.HasPrefix(data, []byte("SQLite format 3\x00")):
+		return SqliteMimeType
+	}
+	return ""
+}
+
+// isIcon returns whether data starts with the header of a Windows icon or cursor, a reserved zero followed by
+// the type, 1 for icons and 2 for cursors, and the number of images, whose first one has to follow their directory
+func isIcon(data []byte) bool {
+	if len(data) < 6 || data[0] != 0 || data[1] != 0 || data[3] != 0 || (data[2] != 1 && data[2] != 2) {
+		return false
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if count == 0 {
+		return false
+	}
+	if len(data) < 22 {
+		// the directory has been cut off by the sample
+		return true
+	}
+	offset := binary.LittleEndian.Uint32(data[18:22])
+	return offset >= uint32(6+16*count)
+}
+
+// detectHeifBrand returns the MIME type of a HEIF image with a generic major brand
+// by the compatible brands listed in its ftyp box
+func detectHeifBrand(data []byte) string {
+	end := int(binary.BigEndian.Uint32(data[:4]))
+	if end > len(data) {
+		end = len(data)
+	}
+	mimeType := HeifMimeType
+	// the compatible brands follow the major brand and its minor version
+	for i := 16; i+4 <= end; i += 4 {
+		switch string(data[i : i+4]) {
+		case "avif", "avis":
+			return AvifMimeType
+		case "heic", "heix", "hevc", "hevx":
+			mimeType = HeicMimeType
+		}
+	}
+	return mimeType
+}
+
+// detectOfficeDocument detects Office Open XML documents by the names of the entries of the ZIP archive
+// they are stored in. The local file headers are searched for instead of being followed by their sizes,
+// as these are not known in advance if the archive is streamed and may only follow the data.
+func detectOfficeDocument(data []byte) string {
+	for {
+		i := bytes.Index(data, ZipMagic)
+		if i < 0 || len(data) < i+30 {
+			return ""
+		}
+		data = data[i:]
+		nameLen := int(binary.LittleEndian.Uint16(data[26:28]))
+		if len(data) < 30+nameLen {
+			return ""
+		}
+		name := string(data[30 : 30+nameLen])
+		switch {
+		case strings.HasPrefix(name, "word/"):
+			return DocxMimeType
+		case strings.HasPrefix(name, "xl/"):
+			return XlsxMimeType
+		case strings.HasPrefix(name, "ppt/"):
+			return PptxMimeType
+		}
+		data = data[30+nameLen:]
+	}
+}
+
 // DetectContentTypeFromReader guesses the content type contained in the reader.
 func DetectContentTypeFromReader(r io.Reader) (SniffedType, error) {
 	buf := make([]byte, sniffLen)
@@ -5,6 +5,7 @@
 package typesniffer
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/base64"
 	"strings"
@@ -25,6 +26,13 @@ func TestIsTextFile(t *testing.T) {
 	assert.True(t, DetectContentType([]byte("lorem ipsum")).IsText())
 }
 
+func TestIsHTML(t *testing.T) {
+	assert.True(t, DetectContentType([]byte("<!DOCTYPE html><html></html>")).IsHTML())
+	assert.True(t, DetectContentType([]byte("<html><body>text</body></html>")).IsHTML())
+	assert.False(t, DetectContentType([]byte("plain text")).IsHTML())
+	assert.False(t, DetectContentType([]byte("<svg></svg>")).IsHTML())
+}
+
 func TestIsSvgImage(t *testing.T) {
 	assert.True(t, DetectContentType([]byte("<svg></svg>")).IsSvgImage())
 	assert.True(t, DetectContentType([]byte("    <svg></svg>")).IsSvgImage())
@@ -89,9 +97,219 @@ func TestIsAudio(t *testing.T) {
 	assert.False(t, DetectContentType([]byte("plain text")).IsAudio())
 }
 
+func TestIsWasm(t *testing.T) {
+	assert.True(t, DetectContentType([]byte("\x00asm\x01\x00\x00\x00")).IsWasm())
+	assert.True(t, DetectContentType([]byte("\x00asm")).IsWasm())
+	assert.False(t, DetectContentType([]byte("\x00abc\x01\x00\x00\x00")).IsWasm())
+	assert.False(t, DetectContentType([]byte("plain text")).IsWasm())
+}
+
+func TestIsDatabase(t *testing.T) {
+	st := DetectContentType([]byte("SQLite format 3\x00\x10\x00\x01\x01\x00\x40\x20\x20"))
+	assert.True(t, st.IsDatabase())
+	assert.Equal(t, SqliteMimeType, st.GetMimeType())
+	assert.False(t, DetectContentType([]byte("SQLite format 3\x00")[:15]).IsDatabase())
+	assert.False(t, DetectContentType([]byte("SQLite format 3 is a file format")).IsDatabase())
+	assert.False(t, DetectContentType([]byte("plain text")).IsDatabase())
+}
+
+func TestIsPostScript(t *testing.T) {
+	st := DetectContentType([]byte("%!PS-Adobe-3.0\n%%Creator: test\n%%Pages: 1\nshowpage\n"))
+	assert.True(t, st.IsPostScript())
+	assert.Equal(t, PostScriptMimeType, st.GetMimeType())
+	// PostScript is written in plain text
+	assert.True(t, st.IsText())
+	assert.True(t, DetectContentType([]byte("%!PS-Adobe-3.0 EPSF-3.0\n%%BoundingBox: 0 0 10 10\n")).IsPostScript())
+	assert.False(t, DetectContentType([]byte("% not %!PS")).IsPostScript())
+	assert.False(t, DetectContentType([]byte("plain text")).IsPostScript())
+}
+
+func TestDetectIcon(t *testing.T) {
+	// a directory of one 16x16 image of 32 bits per pixel, whose data follows at offset 22
+	entry := "\x10\x10\x00\x00\x01\x00\x20\x00\x68\x04\x00\x00\x16\x00\x00\x00"
+	ico := []byte("\x00\x00\x01\x00\x01\x00" + entry + "\x89PNG\r\n\x1a\n")
+	cur := []byte("\x00\x00\x02\x00\x01\x00" + entry)
+
+	for _, data := range [][]byte{ico, cur, ico[:6]} {
+		st := DetectContentType(data)
+		assert.Equal(t, IconMimeType, st.GetMimeType())
+		assert.True(t, st.IsImage())
+	}
+
+	// no images at all, image data overlapping the directory and an unknown type
+	assert.Equal(t, "application/octet-stream", DetectContentType([]byte("\x00\x00\x01\x00\x00\x00")).GetMimeType())
+	assert.Equal(t, "application/octet-stream", DetectContentType([]byte("\x00\x00\x01\x00\x01\x00"+entry[:12]+"\x06\x00\x00\x00")).GetMimeType())
+	assert.NotEqual(t, IconMimeType, DetectContentType([]byte("\x00\x00\x03\x00\x01\x00"+entry)).GetMimeType())
+}
+
+// createOfficeDocument returns a minimal Office Open XML document, which is a ZIP archive starting with
+// [Content_Types].xml and _rels/.rels like those written by office suites
+func createOfficeDocument(t *testing.T, contentTypes string, parts ...string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range append([]string{"[Content_Types].xml", "_rels/.rels"}, parts...) {
+		// stored entries make the size of the archive predictable
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		assert.NoError(t, err)
+		content := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`
+		if name == "[Content_Types].xml" {
+			content += contentTypes
+		}
+		_, err = w.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestDetectOfficeDocument(t *testing.T) {
+	docx := createOfficeDocument(t, `<Types><Override PartName="/word/document.xml"/></Types>`, "word/document.xml", "word/styles.xml")
+	xlsx := createOfficeDocument(t, `<Types><Override PartName="/xl/workbook.xml"/></Types>`, "xl/workbook.xml", "xl/worksheets/sheet1.xml")
+	pptx := createOfficeDocument(t, `<Types><Override PartName="/ppt/presentation.xml"/></Types>`, "ppt/presentation.xml")
+	plain := createOfficeDocument(t, "", "README.md", "src/main.go")
+
+	assert.Equal(t, DocxMimeType, DetectContentType(docx).GetMimeType())
+	assert.Equal(t, XlsxMimeType, DetectContentType(xlsx).GetMimeType())
+	assert.Equal(t, PptxMimeType, DetectContentType(pptx).GetMimeType())
+	assert.Equal(t, "application/zip", DetectContentType(plain).GetMimeType())
+
+	// the parts of a document may only follow a large [Content_Types].xml
+	overrides := strings.Repeat(`<Override PartName="/word/media/image.png" ContentType="image/png"/>`, 50)
+	large := createOfficeDocument(t, "<Types>"+overrides+"</Types>", "word/document.xml")
+	assert.Greater(t, bytes.Index(large, []byte("word/document.xml")), sniffLen)
+	assert.Less(t, len(large), ZipSniffLen)
+	assert.Equal(t, DocxMimeType, DetectContentType(large).GetMimeType())
+	assert.Equal(t, "application/zip", DetectContentType(large[:sniffLen]).GetMimeType())
+}
+
+func TestIsFont(t *testing.T) {
+	kases := map[string]string{
+		"wOFF\x00\x01\x00\x00":             "font/woff",
+		"wOF2\x00\x01\x00\x00":             "font/woff2",
+		"OTTO\x00\x0a\x00\x80":             "font/otf",
+		"\x00\x01\x00\x00\x00\x0a\x00\x80": "font/ttf",
+		"true\x00\x0a\x00\x80":             "font/ttf",
+		"ttcf\x00\x01\x00\x00":             "font/collection",
+	}
+	for data, expected := range kases {
+		st := DetectContentType([]byte(data))
+		assert.True(t, st.IsFont(), expected)
+		assert.Equal(t, expected, st.GetMimeType())
+	}
+	assert.False(t, DetectContentType([]byte("plain text")).IsFont())
+	assert.False(t, DetectContentType([]byte("true or false")).IsFont())
+}
+
+func TestDetectMediaType(t *testing.T) {
+	kases := map[string]string{
+		"\xFF\xFB\x90\x64\x00\x00\x00\x00":                         "audio/mpeg",
+		"fLaC\x00\x00\x00\x22":                                     "audio/flac",
+		"OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01vorbis":   "audio/ogg",
+		"OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00OpusHead":     "audio/ogg",
+		"OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x80theora":   "video/ogg",
+		"\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00M4A mp42isom":     "audio/mp4",
+		"\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00qt  ":             "video/quicktime",
+		"\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x88matroska": "video/x-matroska",
+		"\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x84webm":     "video/webm",
+		"PAR1\x15\x04\x15\x10\x15\x14\x4c\x15\x02":                 ParquetMimeType,
+		"ORC\x0a\x06\x08\x01\x10\x00\x18\x00":                      OrcMimeType,
+		"\xFF\xD8\xFF\xE0":                                         "image/jpeg",
+	}
+	for data, expected := range kases {
+		assert.Equal(t, expected, DetectContentType([]byte(data)).GetMimeType())
+	}
+
+	mp4, _ := base64.StdEncoding.DecodeString("AAAAGGZ0eXBtcDQyAAAAAGlzb21tcDQyAAEI721vb3YAAABsbXZoZAAAAADaBlwX2gZcFwAAA+gA")
+	assert.Equal(t, "video/mp4", DetectContentType(mp4).GetMimeType())
+}
+
+func TestDetectHeifImage(t *testing.T) {
+	kases := map[string]string{
+		"\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf": AvifMimeType,
+		"\x00\x00\x00\x1cftypavis\x00\x00\x00\x00avismsf1miaf": AvifMimeType,
+		"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic":     HeicMimeType,
+		"\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00mif1heic":     HeicMimeType,
+		"\x00\x00\x00\x1cftypmif1\x00\x00\x00\x00mif1avifmiaf": AvifMimeType,
+		"\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00mif1miaf":     HeifMimeType,
+		// the brands of the next box are not compatible brands
+		"\x00\x00\x00\x14ftypmif1\x00\x00\x00\x00mif1\x00\x00\x00\x08avif": HeifMimeType,
+	}
+	for data, expected := range kases {
+		st := DetectContentType([]byte(data))
+		assert.Equal(t, expected, st.GetMimeType())
+		assert.True(t, st.IsImage())
+		assert.Equal(t, expected != AvifMimeType, st.IsHEIC())
+	}
+	assert.False(t, DetectContentType([]byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00qt  ")).IsHEIC())
+}
+
 func TestDetectContentTypeFromReader(t *testing.T) {
 	mp3, _ := base64.StdEncoding.DecodeString("SUQzBAAAAAABAFRYWFgAAAASAAADbWFqb3JfYnJhbmQAbXA0MgBUWFhYAAAAEQAAA21pbm9yX3Zl")
 	st, err := DetectContentTypeFromReader(bytes.NewReader(mp3))
 	assert.NoError(t, err)
 	assert.True(t, st.IsAudio())
 }
+
+func TestGetMimeType(t *testing.T) {
+	assert.Equal(t, "text/plain", DetectContentType([]byte("plain text")).GetMimeType())
+	assert.Equal(t, "image/svg+xml", DetectContentType([]byte("<svg></svg>")).GetMimeType())
+}
+
+func TestNewSniffedType(t *testing.T) {
+	st := NewSniffedType("image/png")
+	assert.True(t, st.IsImage())
+	assert.False(t, st.IsText())
+	assert.Equal(t, "image/png", st.GetMimeType())
+	assert.True(t, NewSniffedType("text/markdown; charset=utf-8").IsText())
+}
+
+func TestIsScriptableMimeType(t *testing.T) {
+	kases := map[string]bool{
+		"text/html":                true,
+		"Text/HTML; charset=utf-8": true,
+		"application/xhtml+xml":    true,
+		"image/svg+xml":            true,
+		"text/javascript":          true,
+		"application/javascript":   true,
+		"text/xml":                 true,
+		"application/xml":          true,
+		"not a type":               true,
+		"text/plain":               false,
+		"text/markdown":            false,
+		"application/json":         false,
+		"application/geo+json":     false,
+		"image/png":                false,
+		"video/mp2t":               false,
+		"application/octet-stream": false,
+	}
+	for mimeType, scriptable := range kases {
+		assert.Equal(t, scriptable, IsScriptableMimeType(mimeType), mimeType)
+	}
+}
+
+func TestRegisterDetector(t *testing.T) {
+	defer func(registered []Detector) { detectors = registered }(detectors)
+
+	var consulted [][]byte
+	RegisterDetector(func(data []byte) (string, bool) {
+		consulted = append(consulted, data)
+		if bytes.HasPrefix(data, []byte("CAD\x00")) {
+			return "application/x-fake-cad", true
+		}
+		return "", false
+	})
+
+	cad := append([]byte("CAD\x00"), bytes.Repeat([]byte{0}, 2*sniffLen)...)
+	assert.Equal(t, "application/x-fake-cad", DetectContentType(cad).GetMimeType())
+	// the detector is given all the data, not only what the built-in detection looks at
+	assert.Len(t, consulted[0], len(cad))
+
+	// built-in detection wins, detectors only fill its gaps
+	consulted = nil
+	assert.Equal(t, WasmMimeType, DetectContentType([]byte("\x00asm\x01\x00\x00\x00")).GetMimeType())
+	assert.Equal(t, "text/plain", DetectContentType([]byte("CAD plain text")).GetMimeType())
+	assert.Empty(t, consulted)
+
+	assert.Equal(t, "application/octet-stream", DetectContentType([]byte{0, 1, 2, 3}).GetMimeType())
+	assert.Len(t, consulted, 1)
+}
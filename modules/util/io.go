@@ -6,6 +6,7 @@ package util
 
 import (
 	"io"
+	"sync"
 )
 
 // ReadAtMost reads at most len(buf) bytes from r into buf.
@@ -18,3 +19,59 @@ func ReadAtMost(r io.Reader, buf []byte) (n int, err error) {
 	}
 	return
 }
+
+// ReadSeekerAt is an io.ReadSeeker which can also be read at any offset
+type ReadSeekerAt interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// NewReadSeekerAt returns rs if it can already be read at any offset,
+// otherwise it wraps rs so that ReadAt seeks to the offset and back.
+// The reads of the wrapper are serialized so it may be used from several goroutines.
+func NewReadSeekerAt(rs io.ReadSeeker) ReadSeekerAt {
+	if rsa, ok := rs.(ReadSeekerAt); ok {
+		return rsa
+	}
+	return &readSeekerAt{rs: rs}
+}
+
+type readSeekerAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (r *readSeekerAt) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rs.Read(p)
+}
+
+func (r *readSeekerAt) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rs.Seek(offset, whence)
+}
+
+// ReadAt reads len(p) bytes at off without moving the offset used by Read
+func (r *readSeekerAt) ReadAt(p []byte, off int64) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err = io.ReadFull(r.rs, p)
+	if err == io.ErrUnexpectedEOF {
+		// unlike Read, ReadAt must report why fewer than len(p) bytes were read
+		err = io.EOF
+	}
+	if _, seekErr := r.rs.Seek(current, io.SeekStart); seekErr != nil && err == nil {
+		err = seekErr
+	}
+	return n, err
+}
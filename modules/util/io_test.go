@@ -0,0 +1,60 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// onlyReadSeeker hides the ReadAt of its reader
+type onlyReadSeeker struct {
+	io.ReadSeeker
+}
+
+func TestNewReadSeekerAt(t *testing.T) {
+	reader := bytes.NewReader([]byte("0123456789"))
+	assert.Equal(t, ReadSeekerAt(reader), NewReadSeekerAt(reader))
+
+	rsa := NewReadSeekerAt(onlyReadSeeker{strings.NewReader("0123456789")})
+
+	buf := make([]byte, 2)
+	n, err := rsa.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "01", string(buf[:n]))
+
+	buf = make([]byte, 3)
+	n, err = rsa.ReadAt(buf, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "567", string(buf[:n]))
+
+	// reading at an offset leaves the offset of Read alone
+	n, err = rsa.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "234", string(buf[:n]))
+
+	n, err = rsa.ReadAt(buf, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "89", string(buf[:n]))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			buf := make([]byte, 1)
+			n, err := rsa.ReadAt(buf, off)
+			assert.NoError(t, err)
+			assert.Equal(t, byte('0'+off), buf[0])
+			assert.Equal(t, 1, n)
+		}(int64(i))
+	}
+	wg.Wait()
+}
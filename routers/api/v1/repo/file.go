@@ -53,9 +53,11 @@ func GetRawFile(ctx *context.APIContext) {
 	//   required: false
 	// responses:
 	//   200:
-	//     description: success
+	//     description: Returns raw file content, or the file base64 encoded in JSON if only `application/json` is accepted.
 	//   "404":
 	//     "$ref": "#/responses/notFound"
+	//   "413":
+	//     description: The file is too large to be returned as JSON.
 
 	if ctx.Repo.Repository.IsEmpty {
 		ctx.NotFound()
@@ -77,16 +79,36 @@ func GetRawFile(ctx *context.APIContext) {
 		}
 	}
 
-	blob, err := commit.GetBlobByPath(ctx.Repo.TreePath)
+	entry, err := commit.GetTreeEntryByPath(ctx.Repo.TreePath)
 	if err != nil {
 		if git.IsErrNotExist(err) {
 			ctx.NotFound()
 		} else {
-			ctx.Error(http.StatusInternalServerError, "GetBlobByPath", err)
+			ctx.Error(http.StatusInternalServerError, "GetTreeEntryByPath", err)
+		}
+		return
+	}
+	if entry.IsDir() || entry.IsSubModule() {
+		ctx.NotFound()
+		return
+	}
+	if entry.IsLink() {
+		if err = common.ServeSymlink(ctx.Context, commit, ctx.Repo.TreePath, entry, common.ServeOptions{}); err != nil {
+			ctx.Error(http.StatusInternalServerError, "ServeSymlink", err)
+		}
+		return
+	}
+	if common.IsJSONRequested(ctx.Context) {
+		if err = common.ServeBlobJSON(ctx.Context, entry.Blob()); err != nil {
+			if common.IsErrBlobTooLarge(err) {
+				ctx.Error(http.StatusRequestEntityTooLarge, "ServeBlobJSON", err)
+			} else {
+				ctx.Error(http.StatusInternalServerError, "ServeBlobJSON", err)
+			}
 		}
 		return
 	}
-	if err = common.ServeBlob(ctx.Context, blob); err != nil {
+	if err = common.ServeBlob(ctx.Context, entry.Blob()); err != nil {
 		ctx.Error(http.StatusInternalServerError, "ServeBlob", err)
 	}
 }
@@ -0,0 +1,36 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package repo
+
+import (
+	"net/http"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRawFileSymlinkRedirect(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(mode string) { setting.Repository.ServeSymlinks = mode }(setting.Repository.ServeSymlinks)
+	setting.Repository.ServeSymlinks = "redirect"
+
+	ctx := test.MockContext(t, "api/v1/repos/user2/repo20/raw/a/b/link_hi")
+	test.LoadRepo(t, ctx, 31)
+	// like the repoAssignment of the API, which has no links of its own to the repository
+	ctx.Repo.RepoLink = ""
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+	ctx.Repo.TreePath = "a/b/link_hi"
+
+	GetRawFile(&context.APIContext{Context: ctx})
+	assert.EqualValues(t, http.StatusFound, ctx.Resp.Status())
+	assert.Equal(t, "/user2/repo20/raw/commit/"+ctx.Repo.Commit.ID.String()+"/a/c/hi", ctx.Resp.Header().Get("Location"))
+}
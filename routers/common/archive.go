@@ -0,0 +1,217 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+)
+
+// subtreeArchiveWriter writes the entries of a subtree to an archive
+type subtreeArchiveWriter interface {
+	// WriteDir adds an empty directory
+	WriteDir(name string, modTime time.Time) error
+	// WriteFile adds a file of the given size with the content read from r
+	WriteFile(name string, mode os.FileMode, size int64, modTime time.Time, r io.Reader) error
+	// WriteSymlink adds a symbolic link pointing to target
+	WriteSymlink(name, target string, modTime time.Time) error
+	Close() error
+}
+
+type tarGzArchiveWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzArchiveWriter(w io.Writer) *tarGzArchiveWriter {
+	gw := gzip.NewWriter(w)
+	return &tarGzArchiveWriter{gw: gw, tw: tar.NewWriter(gw)}
+}
+
+func (a *tarGzArchiveWriter) WriteDir(name string, modTime time.Time) error {
+	return a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: modTime})
+}
+
+func (a *tarGzArchiveWriter) WriteFile(name string, mode os.FileMode, size int64, modTime time.Time, r io.Reader) error {
+	if err := a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: int64(mode), Size: size, ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tw, r)
+	return err
+}
+
+func (a *tarGzArchiveWriter) WriteSymlink(name, target string, modTime time.Time) error {
+	return a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0o777, ModTime: modTime})
+}
+
+func (a *tarGzArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gw.Close()
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) create(name string, mode os.FileMode, modTime time.Time) (io.Writer, error) {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+	if mode.IsDir() {
+		header.Method = zip.Store
+	}
+	header.SetMode(mode)
+	return a.zw.CreateHeader(header)
+}
+
+func (a *zipArchiveWriter) WriteDir(name string, modTime time.Time) error {
+	_, err := a.create(name+"/", os.ModeDir|0o755, modTime)
+	return err
+}
+
+func (a *zipArchiveWriter) WriteFile(name string, mode os.FileMode, _ int64, modTime time.Time, r io.Reader) error {
+	w, err := a.create(name, mode, modTime)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// WriteSymlink stores the target as the content of the link, like Info-ZIP and git archive do
+func (a *zipArchiveWriter) WriteSymlink(name, target string, modTime time.Time) error {
+	w, err := a.create(name, os.ModeSymlink|0o777, modTime)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+// subtreeArchiveName returns the name of the archive of the subtree at treePath, which is also
+// the directory all entries of the archive are put in
+func subtreeArchiveName(ctx *context.Context, treePath string) string {
+	if len(treePath) > 0 {
+		return BlobFilename(ctx, treePath)
+	}
+	if ctx.Repo != nil && ctx.Repo.Repository != nil {
+		return ctx.Repo.Repository.Name
+	}
+	return "archive"
+}
+
+// ServeSubtreeArchive streams a zip or tar.gz archive of all the files under the directory found at
+// treePath in the commit, or responds with a 404 if there is no such path or it isn't a directory.
+// The size of the archive isn't known in advance, so it is sent with chunked encoding.
+// Symbolic links are archived as links, submodules as empty directories.
+func ServeSubtreeArchive(ctx *context.Context, commit *git.Commit, treePath string, format git.ArchiveType) error {
+	var contentType string
+	switch format {
+	case git.ZIP:
+		contentType = "application/zip"
+	case git.TARGZ:
+		contentType = "application/gzip"
+	default:
+		return fmt.Errorf("unknown format: %v", format)
+	}
+
+	treePath = path.Clean("/" + treePath)[1:]
+	tree := commit.Tree.ID
+	if len(treePath) > 0 {
+		entry, err := commit.GetTreeEntryByPath(treePath)
+		if err != nil {
+			if git.IsErrNotExist(err) {
+				ctx.NotFound("GetTreeEntryByPath", nil)
+				return nil
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			ctx.NotFound("GetTreeEntryByPath", nil)
+			return nil
+		}
+		tree = entry.ID
+	}
+
+	subTree, err := commit.SubTree(treePath)
+	if err != nil {
+		return err
+	}
+	entries, err := subTree.ListEntriesRecursive()
+	if err != nil {
+		return err
+	}
+
+	name := subtreeArchiveName(ctx, treePath)
+	modTime := commit.Committer.When
+	ctx.Resp.Header().Set("Content-Type", contentType)
+	ctx.Resp.Header().Set("Content-Disposition", contentDisposition("attachment", name+"."+format.String()))
+	ctx.Resp.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
+	ctx.Resp.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	ctx.Resp.Header().Set("X-Content-Type-Options", "nosniff")
+	ctx.Status(http.StatusOK)
+	if ctx.Req.Method == http.MethodHead {
+		return nil
+	}
+
+	var archive subtreeArchiveWriter
+	if format == git.ZIP {
+		archive = &zipArchiveWriter{zw: zip.NewWriter(newResponseWriter(ctx))}
+	} else {
+		archive = newTarGzArchiveWriter(newResponseWriter(ctx))
+	}
+
+	if err := archive.WriteDir(name, modTime); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryName := name + "/" + entry.Name()
+		switch {
+		case entry.IsDir(), entry.IsSubModule():
+			err = archive.WriteDir(entryName, modTime)
+		case entry.IsLink():
+			var target string
+			if target, err = entry.Blob().GetBlobContent(); err == nil {
+				err = archive.WriteSymlink(entryName, target, modTime)
+			}
+		default:
+			err = writeSubtreeArchiveFile(archive, entryName, entry, modTime)
+		}
+		if err != nil {
+			log.Error("ServeSubtreeArchive: unable to archive %s of tree %s: %v", entry.Name(), tree, err)
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// writeSubtreeArchiveFile adds the blob of entry to the archive
+func writeSubtreeArchiveFile(archive subtreeArchiveWriter, name string, entry *git.TreeEntry, modTime time.Time) error {
+	mode := os.FileMode(0o644)
+	if entry.IsExecutable() {
+		mode = 0o755
+	}
+	dataRc, err := entry.Blob().DataAsync()
+	if err != nil {
+		return err
+	}
+	defer dataRc.Close()
+	return archive.WriteFile(name, mode, entry.Size(), modTime, dataRc)
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// archivedEntry describes an entry of an archive, Content holds the target of a symbolic link
+type archivedEntry struct {
+	Mode    os.FileMode
+	Content string
+}
+
+func readTarGzArchive(t *testing.T, content []byte) map[string]archivedEntry {
+	gr, err := gzip.NewReader(bytes.NewReader(content))
+	assert.NoError(t, err)
+	tr := tar.NewReader(gr)
+	entries := map[string]archivedEntry{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if !assert.NoError(t, err) {
+			return entries
+		}
+		data, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		if header.Typeflag == tar.TypeSymlink {
+			data = []byte(header.Linkname)
+		}
+		entries[header.Name] = archivedEntry{Mode: header.FileInfo().Mode(), Content: string(data)}
+	}
+}
+
+func readZipArchive(t *testing.T, content []byte) map[string]archivedEntry {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	assert.NoError(t, err)
+	entries := map[string]archivedEntry{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		rc.Close()
+		entries[f.Name] = archivedEntry{Mode: f.Mode(), Content: string(data)}
+	}
+	return entries
+}
+
+func TestServeSubtreeArchive(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+
+	serve := func(treePath string, format git.ArchiveType) {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeSubtreeArchive(ctx, ctx.Repo.Commit, treePath, format))
+	}
+
+	expected := map[string]archivedEntry{
+		"a/":           {Mode: os.ModeDir | 0o755},
+		"a/b/":         {Mode: os.ModeDir | 0o755},
+		"a/b/link_c":   {Mode: os.ModeSymlink | 0o777, Content: "../c"},
+		"a/b/link_hi":  {Mode: os.ModeSymlink | 0o777, Content: "../c/hi"},
+		"a/c/":         {Mode: os.ModeDir | 0o755},
+		"a/c/hi":       {Mode: 0o644, Content: "hello\n"},
+		"a/link_annex": {Mode: os.ModeSymlink | 0o777, Content: "../.git/annex/objects/aaa/bbb/ccc"},
+	}
+
+	t.Run("TarGz", func(t *testing.T) {
+		serve("a", git.TARGZ)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/gzip", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="a.tar.gz"`, recorder.Header().Get("Content-Disposition"))
+		// the size isn't known in advance
+		assert.Empty(t, recorder.Header().Get("Content-Length"))
+		assert.Equal(t, expected, readTarGzArchive(t, recorder.Body.Bytes()))
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		serve("a/", git.ZIP)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/zip", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="a.zip"`, recorder.Header().Get("Content-Disposition"))
+		assert.Equal(t, expected, readZipArchive(t, recorder.Body.Bytes()))
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		serve("a/c", git.ZIP)
+		assert.Equal(t, map[string]archivedEntry{
+			"c/":   {Mode: os.ModeDir | 0o755},
+			"c/hi": {Mode: 0o644, Content: "hello\n"},
+		}, readZipArchive(t, recorder.Body.Bytes()))
+	})
+
+	t.Run("Root", func(t *testing.T) {
+		serve("", git.TARGZ)
+		assert.Equal(t, `attachment; filename="repo20.tar.gz"`, recorder.Header().Get("Content-Disposition"))
+		entries := readTarGzArchive(t, recorder.Body.Bytes())
+		assert.Contains(t, entries, "repo20/a/c/hi")
+		assert.Contains(t, entries, "repo20/link_hi")
+	})
+
+	t.Run("NotDirectory", func(t *testing.T) {
+		serve("a/c/hi", git.ZIP)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		serve("a/nonexistent", git.ZIP)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		assert.Error(t, ServeSubtreeArchive(ctx, ctx.Repo.Commit, "a", git.BUNDLE))
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	gocontext "context"
+	"io"
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/util"
+)
+
+// AttachmentScanner checks attachments for malicious content, e.g. by passing them to an ICAP or ClamAV server
+type AttachmentScanner interface {
+	// Scan reports whether the content of the named attachment is malicious
+	Scan(ctx gocontext.Context, name string, content []byte) (malicious bool, err error)
+}
+
+var (
+	attachmentScanner  AttachmentScanner
+	attachmentScanSize int64
+)
+
+// SetAttachmentScanner makes ServeAttachment check the first scanSize bytes of attachments with the scanner before
+// serving them. A scanSize of 0 or less scans the whole attachment, a nil scanner disables scanning.
+func SetAttachmentScanner(scanner AttachmentScanner, scanSize int64) {
+	attachmentScanner = scanner
+	attachmentScanSize = scanSize
+}
+
+// ServeAttachment serves an attachment after checking it with the AttachmentScanner, if there is one,
+// and responds with 403 Forbidden if the scanner reports it to be malicious.
+// Attachments never change once they are uploaded, so they are cached as immutable.
+func ServeAttachment(ctx *context.Context, name string, size int64, reader io.Reader) error {
+	return ServeAttachmentWithOptions(ctx, name, size, reader, ServeOptions{Immutable: true})
+}
+
+// ServeAttachmentWithOptions serves an attachment like ServeAttachment using the given options
+func ServeAttachmentWithOptions(ctx *context.Context, name string, size int64, reader io.Reader, opts ServeOptions) error {
+	if attachmentScanner == nil {
+		return ServeDataWithOptions(ctx, name, size, reader, opts)
+	}
+
+	var content []byte
+	var err error
+	if attachmentScanSize > 0 {
+		scanSize := attachmentScanSize
+		if size >= 0 && size < scanSize {
+			scanSize = size
+		}
+		content = make([]byte, scanSize)
+		var n int
+		n, err = util.ReadAtMost(reader, content)
+		content = content[:n]
+	} else {
+		content, err = io.ReadAll(reader)
+	}
+	if err != nil {
+		return err
+	}
+
+	malicious, err := attachmentScanner.Scan(ctx.Req.Context(), name, content)
+	if err != nil {
+		return err
+	}
+	if malicious {
+		log.Warn("ServeAttachment: refusing to serve %s which the scanner reported as malicious", name)
+		ctx.Error(http.StatusForbidden)
+		return nil
+	}
+
+	// the scanned content has already been read, only the rest of it has to come from the reader
+	scanned := scannedReader{io.MultiReader(bytes.NewReader(content), reader)}
+	if ra, ok := reader.(io.ReaderAt); ok {
+		// keep ranges servable
+		return ServeDataWithOptions(ctx, name, size, scannedReaderAt{scanned, ra}, opts)
+	}
+	return ServeDataWithOptions(ctx, name, size, scanned, opts)
+}
+
+// scannedReader reads the content which has been scanned from memory and the rest of it from the original reader
+type scannedReader struct {
+	io.Reader
+}
+
+// scannedReaderAt is a scannedReader whose original reader can be read at any offset
+type scannedReaderAt struct {
+	scannedReader
+	io.ReaderAt
+}
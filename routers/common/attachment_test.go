@@ -0,0 +1,96 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	gocontext "context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubScanner struct {
+	malicious bool
+	err       error
+	scanned   []byte
+}
+
+func (s *stubScanner) Scan(ctx gocontext.Context, name string, content []byte) (bool, error) {
+	s.scanned = append([]byte{}, content...)
+	return s.malicious, s.err
+}
+
+// readerOnly hides all methods of the reader but Read
+type readerOnly struct {
+	io.Reader
+}
+
+func TestServeAttachment(t *testing.T) {
+	defer SetAttachmentScanner(nil, 0)
+
+	content := []byte(strings.Repeat("attachment content\n", 10))
+	serve := func(reader io.Reader, rng string) (*stubScanner, int, []byte) {
+		ctx, recorder := mockServeDataContext(t, rng)
+		scanner := &stubScanner{}
+		SetAttachmentScanner(scanner, 16)
+		assert.NoError(t, ServeAttachment(ctx, "file.txt", int64(len(content)), reader))
+		return scanner, recorder.Code, recorder.Body.Bytes()
+	}
+
+	t.Run("Accept", func(t *testing.T) {
+		scanner, code, body := serve(readerOnly{bytes.NewReader(content)}, "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, content[:16], scanner.scanned)
+		// the scanned bytes are served without reading them again
+		assert.Equal(t, content, body)
+	})
+
+	t.Run("AcceptRange", func(t *testing.T) {
+		scanner, code, body := serve(bytes.NewReader(content), "bytes=20-29")
+		assert.Equal(t, http.StatusPartialContent, code)
+		assert.Equal(t, content[:16], scanner.scanned)
+		assert.Equal(t, content[20:30], body)
+	})
+
+	t.Run("WholeFile", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "")
+		scanner := &stubScanner{}
+		SetAttachmentScanner(scanner, 0)
+		assert.NoError(t, ServeAttachment(ctx, "file.txt", int64(len(content)), readerOnly{bytes.NewReader(content)}))
+		assert.Equal(t, content, scanner.scanned)
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+
+	t.Run("CacheControl", func(t *testing.T) {
+		SetAttachmentScanner(nil, 0)
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeAttachment(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+		assert.Equal(t, "public,max-age=31536000,immutable", recorder.Header().Get("Cache-Control"))
+
+		// the same content served as a file of a branch may change with the next commit
+		ctx, recorder = mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+		assert.Equal(t, "public,max-age=86400", recorder.Header().Get("Cache-Control"))
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "")
+		SetAttachmentScanner(&stubScanner{malicious: true}, 16)
+		assert.NoError(t, ServeAttachment(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		assert.NotContains(t, recorder.Body.String(), "attachment content")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		ctx, _ := mockServeDataContext(t, "")
+		SetAttachmentScanner(&stubScanner{err: errors.New("scanner unavailable")}, 16)
+		assert.Error(t, ServeAttachment(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+	})
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/setting"
+)
+
+// downloadAuditRecord is logged for every raw file downloaded while ENABLE_DOWNLOAD_AUDIT_LOG is on
+type downloadAuditRecord struct {
+	UserID   int64  `json:"user_id"`
+	Repo     string `json:"repo"`
+	TreePath string `json:"tree_path"`
+	BlobSHA  string `json:"blob_sha"`
+	Size     int64  `json:"size"`
+	Range    bool   `json:"range"`
+	Status   int    `json:"status"`
+}
+
+// sendDownloadAudit writes the record to the download audit log, tests replace it to inspect the records
+var sendDownloadAudit = func(record *downloadAuditRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Error("Unable to marshal download audit record: %v", err)
+		return
+	}
+	if err := log.GetLogger("download_audit").SendLog(log.INFO, "", "", 0, string(data), ""); err != nil {
+		log.Error("Unable to send download audit record: %v", err)
+	}
+}
+
+// AuditDownload records the download of the blob with the given id and size at treePath once it has been served.
+// Nothing is recorded for HEAD requests and responses which didn't deliver any content, like 304 Not Modified.
+func AuditDownload(ctx *context.Context, blobSHA, treePath string, size int64) {
+	if !setting.EnableDownloadAuditLog || ctx.Req.Method == http.MethodHead {
+		return
+	}
+	status := ctx.Resp.Status()
+	if status == 0 {
+		// nothing but an empty body has been written
+		status = http.StatusOK
+	}
+	if status != http.StatusOK && status != http.StatusPartialContent {
+		return
+	}
+
+	record := &downloadAuditRecord{
+		TreePath: treePath,
+		BlobSHA:  blobSHA,
+		Size:     size,
+		Range:    status == http.StatusPartialContent,
+		Status:   status,
+	}
+	if ctx.User != nil {
+		record.UserID = ctx.User.ID
+	}
+	if ctx.Repo != nil && ctx.Repo.Repository != nil {
+		record.Repo = ctx.Repo.Repository.FullName()
+	}
+	sendDownloadAudit(record)
+}
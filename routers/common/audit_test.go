@@ -0,0 +1,78 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"net/http"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditDownload(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(enabled bool) { setting.EnableDownloadAuditLog = enabled }(setting.EnableDownloadAuditLog)
+	defer func(send func(*downloadAuditRecord)) { sendDownloadAudit = send }(sendDownloadAudit)
+
+	var records []*downloadAuditRecord
+	sendDownloadAudit = func(record *downloadAuditRecord) {
+		records = append(records, record)
+	}
+
+	download := func(method string, serve func(*testing.T, *context.Context)) {
+		ctx, _ := mockServeDataContext(t, "")
+		ctx.Req.Method = method
+		ctx.User = unittest.AssertExistsAndLoadBean(t, &user_model.User{ID: 2}).(*user_model.User)
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+		test.LoadRepoCommit(t, ctx)
+		ctx.Repo.TreePath = "a/c/hi"
+		serve(t, ctx)
+	}
+	full := func(t *testing.T, ctx *context.Context) {
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+	}
+	partial := func(t *testing.T, ctx *context.Context) {
+		blob, err := ctx.Repo.Commit.GetBlobByPath("a/c/hi")
+		assert.NoError(t, err)
+		assert.NoError(t, ServeBlobRange(ctx, blob, 1, 3))
+	}
+
+	setting.EnableDownloadAuditLog = false
+	download(http.MethodGet, full)
+	assert.Empty(t, records)
+
+	setting.EnableDownloadAuditLog = true
+	download(http.MethodGet, full)
+	download(http.MethodGet, partial)
+	download(http.MethodHead, full)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, &downloadAuditRecord{
+			UserID:   2,
+			Repo:     "user2/repo20",
+			TreePath: "a/c/hi",
+			BlobSHA:  "ce013625030ba8dba906f756967f9e9ca394464a",
+			Size:     6,
+			Range:    false,
+			Status:   http.StatusOK,
+		}, records[0])
+		assert.Equal(t, &downloadAuditRecord{
+			UserID:   2,
+			Repo:     "user2/repo20",
+			TreePath: "a/c/hi",
+			BlobSHA:  "ce013625030ba8dba906f756967f9e9ca394464a",
+			Size:     6,
+			Range:    true,
+			Status:   http.StatusPartialContent,
+		}, records[1])
+	}
+}
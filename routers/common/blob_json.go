@@ -0,0 +1,81 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/httpcache"
+	"code.gitea.io/gitea/modules/setting"
+	api "code.gitea.io/gitea/modules/structs"
+)
+
+// ErrBlobTooLarge represents a blob which is too large to be served base64 encoded in JSON
+type ErrBlobTooLarge struct {
+	Size    int64
+	MaxSize int64
+}
+
+// IsErrBlobTooLarge checks if an error is a ErrBlobTooLarge.
+func IsErrBlobTooLarge(err error) bool {
+	_, ok := err.(ErrBlobTooLarge)
+	return ok
+}
+
+func (err ErrBlobTooLarge) Error() string {
+	return fmt.Sprintf("the file is %d bytes large, files larger than %d bytes cannot be returned as JSON", err.Size, err.MaxSize)
+}
+
+// IsJSONRequested returns whether the Accept header asks for the content as JSON rather than as it is.
+// Clients which accept anything get the content as it is.
+func IsJSONRequested(ctx *context.Context) bool {
+	json, wildcard := 0.0, false
+	for _, part := range strings.Split(ctx.Req.Header.Get("Accept"), ",") {
+		mediaType, q := parseQualityValue(part)
+		switch mediaType {
+		case "application/json":
+			json = q
+		case "*/*", "application/*":
+			wildcard = wildcard || q > 0
+		}
+	}
+	return !wildcard && json > 0
+}
+
+// ServeBlobJSON responds with the content of the blob at ctx.Repo.TreePath encoded as base64 in JSON, for clients
+// which cannot handle binary content. Blobs larger than MAX_RAW_JSON_SIZE of [api] are refused with an
+// ErrBlobTooLarge before anything has been written.
+func ServeBlobJSON(ctx *context.Context, blob *git.Blob) error {
+	if blob.Size() > setting.API.MaxRawJSONSize {
+		return ErrBlobTooLarge{Size: blob.Size(), MaxSize: setting.API.MaxRawJSONSize}
+	}
+
+	ctx.Resp.Header().Add("Vary", "Accept")
+	if httpcache.HandleGenericETagCache(ctx.Req, ctx.Resp, `"`+blob.ID.String()+`-json"`) {
+		return nil
+	}
+
+	content, err := blob.GetBlobContentBase64()
+	if err != nil {
+		return err
+	}
+	response := &api.GitBlobResponse{
+		SHA:      blob.ID.String(),
+		Size:     blob.Size(),
+		Encoding: "base64",
+		Content:  content,
+	}
+	if ctx.Repo.Repository != nil {
+		response.URL = ctx.Repo.Repository.APIURL() + "/git/blobs/" + url.PathEscape(blob.ID.String())
+	}
+	ctx.JSON(http.StatusOK, response)
+	AuditDownload(ctx, blob.ID.String(), ctx.Repo.TreePath, blob.Size())
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/setting"
+	api "code.gitea.io/gitea/modules/structs"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsJSONRequested(t *testing.T) {
+	kases := map[string]bool{
+		"":                                    false,
+		"*/*":                                 false,
+		"application/json":                    true,
+		"application/json, */*":               false,
+		"application/json, application/*;q=0": true,
+		"application/json;q=0":                false,
+		"text/plain, application/json;q=0.5":  true,
+		"application/octet-stream":            false,
+	}
+	for accept, requested := range kases {
+		ctx, _ := mockServeDataContext(t, "")
+		ctx.Req.Header.Set("Accept", accept)
+		assert.Equal(t, requested, IsJSONRequested(ctx), accept)
+	}
+}
+
+func TestServeBlobJSON(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	binary := "\x00\x01\x02\x03\xff"
+	commit := commitTestRepo(t, ctx, map[string]string{"data.bin": binary})
+	entry, err := commit.GetTreeEntryByPath("data.bin")
+	assert.NoError(t, err)
+	ctx.Repo.TreePath = "data.bin"
+
+	serve := func(ifNoneMatch string) error {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		ctx.Req.Header.Set("If-None-Match", ifNoneMatch)
+		return ServeBlobJSON(ctx, entry.Blob())
+	}
+
+	t.Run("Small", func(t *testing.T) {
+		assert.NoError(t, serve(""))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json;charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", recorder.Header().Get("Vary"))
+
+		var response api.GitBlobResponse
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Equal(t, "base64", response.Encoding)
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(binary)), response.Content)
+		assert.EqualValues(t, len(binary), response.Size)
+		assert.Equal(t, entry.ID.String(), response.SHA)
+		assert.Equal(t, ctx.Repo.Repository.APIURL()+"/git/blobs/"+entry.ID.String(), response.URL)
+
+		// the JSON has an ETag of its own
+		etag := recorder.Header().Get("Etag")
+		assert.Equal(t, `"`+entry.ID.String()+`-json"`, etag)
+		assert.NoError(t, serve(etag))
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+	})
+
+	t.Run("Oversized", func(t *testing.T) {
+		defer func(size int64) { setting.API.MaxRawJSONSize = size }(setting.API.MaxRawJSONSize)
+		setting.API.MaxRawJSONSize = int64(len(binary)) - 1
+
+		err := serve("")
+		assert.True(t, IsErrBlobTooLarge(err))
+		assert.Equal(t, ErrBlobTooLarge{Size: int64(len(binary)), MaxSize: int64(len(binary)) - 1}, err)
+		assert.Zero(t, recorder.Body.Len())
+		assert.Empty(t, recorder.Header())
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"sync"
+
+	"code.gitea.io/gitea/modules/charset"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/setting"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+var (
+	charsetCacheOnce sync.Once
+	charsetCache     *lru.Cache
+
+	// detectEncoding detects the charset of content, it is replaced by tests
+	detectEncoding = charset.DetectEncoding
+)
+
+// charsetCacheKey identifies a sample of the content of a blob, the charset detected from
+// the start of a blob depends on how much of it is sampled
+type charsetCacheKey struct {
+	blobID     string
+	sampleSize int
+}
+
+// detectedCharset is the charset detected from a sample and how confident the detection is
+type detectedCharset struct {
+	charset    string
+	confidence int
+}
+
+// getCharsetCache returns the cache of charsets detected from blobs, or nil if CHARSET_CACHE_SIZE disables it
+func getCharsetCache() *lru.Cache {
+	charsetCacheOnce.Do(func() {
+		if setting.Repository.CharsetCacheSize <= 0 {
+			return
+		}
+		c, err := lru.New(setting.Repository.CharsetCacheSize)
+		if err != nil {
+			log.Error("Unable to allocate the charset cache: %v", err)
+			return
+		}
+		charsetCache = c
+	})
+	return charsetCache
+}
+
+// detectContentEncoding detects the charset of buf, a sample of the start of the content, and how confident
+// the detection is. The content of a blob never changes, so what is detected from the blob blobID is cached.
+func detectContentEncoding(blobID string, buf []byte) (string, int, error) {
+	cache := getCharsetCache()
+	if len(blobID) == 0 || cache == nil {
+		return detectEncoding(buf)
+	}
+
+	key := charsetCacheKey{blobID: blobID, sampleSize: len(buf)}
+	if cached, ok := cache.Get(key); ok {
+		detected := cached.(detectedCharset)
+		return detected.charset, detected.confidence, nil
+	}
+	cs, confidence, err := detectEncoding(buf)
+	if err != nil {
+		return cs, confidence, err
+	}
+	cache.Add(key, detectedCharset{charset: cs, confidence: confidence})
+	return cs, confidence, nil
+}
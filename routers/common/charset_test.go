@@ -0,0 +1,84 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/charset"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/test"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCharsetCache(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	// start from an empty cache
+	getCharsetCache()
+	defer func(c *lru.Cache) { charsetCache = c }(charsetCache)
+	var err error
+	charsetCache, err = lru.New(16)
+	assert.NoError(t, err)
+
+	detections := 0
+	defer func(detect func([]byte) (string, int, error)) { detectEncoding = detect }(detectEncoding)
+	detectEncoding = func(content []byte) (string, int, error) {
+		detections++
+		return charset.DetectEncoding(content)
+	}
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+
+	serve := func(treePath string) {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, treePath))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	}
+
+	t.Run("Blob", func(t *testing.T) {
+		serve("a/c/hi")
+		assert.Equal(t, 1, detections)
+		serve("a/c/hi")
+		assert.Equal(t, 1, detections)
+		assert.Equal(t, "hello\n", recorder.Body.String())
+
+		// the same blob at another path
+		serve("a/b/../c/hi")
+		assert.Equal(t, 1, detections)
+	})
+
+	t.Run("NotBlob", func(t *testing.T) {
+		detections = 0
+		// content which isn't read from a blob has nothing to be cached by
+		for i := 0; i < 2; i++ {
+			recorder = httptest.NewRecorder()
+			ctx.Resp = context.NewResponse(recorder)
+			assert.NoError(t, ServeData(ctx, "file.txt", 6, bytes.NewReader([]byte("hello\n"))))
+		}
+		assert.Equal(t, 2, detections)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		defer func(c *lru.Cache) { charsetCache = c }(charsetCache)
+		charsetCache = nil
+		detections = 0
+		serve("a/c/hi")
+		serve("a/c/hi")
+		assert.Equal(t, 2, detections)
+	})
+}
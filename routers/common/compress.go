@@ -0,0 +1,258 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/httpcache"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/typesniffer"
+	"code.gitea.io/gitea/modules/util"
+
+	"github.com/andybalholm/brotli"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// brotliSampleSize is the number of bytes decoded to tell whether content is brotli compressed
+const brotliSampleSize = 512
+
+// errDecompressedTooLarge is returned when precompressed content decompresses to more than MAX_DOWNLOAD_FILE_SIZE
+var errDecompressedTooLarge = errors.New("decompressed content exceeds MAX_DOWNLOAD_FILE_SIZE")
+
+// negotiateContentEncoding returns the supported content coding the Accept-Encoding header prefers,
+// or an empty string if none of them is acceptable. Brotli wins over gzip if both are equally preferred.
+func negotiateContentEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, q := parseQualityValue(part)
+		if coding != "br" && coding != "gzip" {
+			continue
+		}
+		if q > bestQ || (q == bestQ && q > 0 && coding == "br") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// parseQualityValue splits an element of an Accept or Accept-Encoding header into its lowercased value and its weight
+func parseQualityValue(part string) (string, float64) {
+	params := strings.Split(part, ";")
+	q := 1.0
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			var err error
+			if q, err = strconv.ParseFloat(param[2:], 64); err != nil {
+				q = 0
+			}
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(params[0])), q
+}
+
+// newCompressWriter returns a writer compressing everything written to it into w using the given content coding
+func newCompressWriter(w io.Writer, coding string) io.WriteCloser {
+	if coding == "br" {
+		return brotli.NewWriter(w)
+	}
+	return gzip.NewWriter(w)
+}
+
+// acceptsContentEncoding returns whether the Accept-Encoding header allows the given content coding
+func acceptsContentEncoding(acceptEncoding, coding string) bool {
+	accepted, wildcard := -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		value, q := parseQualityValue(part)
+		switch value {
+		case coding:
+			accepted = q
+		case "*":
+			wildcard = q
+		}
+	}
+	if accepted < 0 {
+		// a coding which isn't listed is only acceptable if the wildcard allows it
+		accepted = wildcard
+	}
+	return accepted > 0
+}
+
+// precompressedCoding returns the content coding of a precompressed file like "bundle.js.gz" or "bundle.js.br"
+// which is enabled to be served as the text it contains, or an empty string if there is none
+func precompressedCoding(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gz":
+		if setting.UI.ServePrecompressedGzip {
+			return "gzip"
+		}
+	case ".br":
+		if setting.UI.ServePrecompressedBrotli {
+			return "br"
+		}
+	}
+	return ""
+}
+
+// precompressedMimeType returns the MIME type of the text a precompressed file like "bundle.js.gz" contains,
+// or an empty string if the type of the name without its extension isn't textual
+func precompressedMimeType(ctx *context.Context, name string) string {
+	inner := path.Base(name[:len(name)-len(path.Ext(name))])
+	mimeType := lookupMimeType(ctx, inner)
+	if len(mimeType) == 0 {
+		mimeType = mime.TypeByExtension(path.Ext(inner))
+	}
+	mimeType = strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+
+	switch {
+	case strings.HasPrefix(mimeType, "text/"),
+		mimeType == "application/javascript", mimeType == "application/json", mimeType == "application/xml",
+		strings.HasSuffix(mimeType, "+json"), strings.HasSuffix(mimeType, "+xml"):
+		return mimeType
+	}
+	return ""
+}
+
+// isPrecompressed checks whether the content of the reader is compressed with the given coding. It returns a reader
+// which still yields the whole content, since the start of it may have to be read from the original one.
+func isPrecompressed(reader io.Reader, coding string) (bool, io.Reader, error) {
+	sample := make([]byte, len(gzipMagic))
+	if coding == "br" {
+		sample = make([]byte, brotliSampleSize)
+	}
+	var n int
+	var err error
+	if ra, ok := reader.(io.ReaderAt); ok {
+		if n, err = ra.ReadAt(sample, 0); err != nil && err != io.EOF {
+			return false, reader, err
+		}
+	} else {
+		n, err = util.ReadAtMost(reader, sample)
+		if err != nil {
+			return false, reader, err
+		}
+		reader = io.MultiReader(bytes.NewReader(sample[:n]), reader)
+	}
+
+	if coding != "br" {
+		return bytes.Equal(sample[:n], gzipMagic), reader, nil
+	}
+	// brotli has no magic number, its start has to decode cleanly instead, up to where the sample is cut off
+	_, err = io.CopyN(io.Discard, brotli.NewReader(bytes.NewReader(sample[:n])), brotliSampleSize)
+	brotliCompressed := n > 0 && (err == nil || err == io.EOF || (n == len(sample) && err == io.ErrUnexpectedEOF))
+	return brotliCompressed, reader, nil
+}
+
+// servePrecompressed serves content compressed with the given coding as the text of the given MIME type it contains.
+// Clients which accept the coding get the content as it is with it as Content-Encoding, for all others it is decompressed.
+func servePrecompressed(ctx *context.Context, name string, size int64, reader io.Reader, mimeType, coding string, opts ServeOptions) error {
+	name = path.Base(name[:len(name)-len(path.Ext(name))])
+	if isScriptableDocument(mimeType) && !(mimeType == "text/html" && setting.UI.AllowRawHTMLPreview) {
+		// like their uncompressed counterparts, HTML, XHTML, SVG and other XML documents are only displayed as text
+		mimeType = "text/plain"
+	}
+
+	header := ctx.Resp.Header()
+	header.Add("Vary", "Accept-Encoding")
+	header.Set("Content-Type", mimeType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	// documents among the content must not be able to run scripts, but stylesheets and scripts still apply to the pages including them
+	header.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	if setting.UI.TextContentDisposition == "attachment" {
+		header.Set("Access-Control-Expose-Headers", "Content-Disposition")
+		header.Set("Content-Disposition", contentDisposition("attachment", name))
+	} else {
+		header.Set("Content-Disposition", contentDisposition("inline", name))
+	}
+	setFrameAncestors(header)
+	header.Set("Cache-Control", opts.cacheControl(name, mimeType))
+	// ranges of the compressed content are useless to clients which want the text
+	header.Set("Accept-Ranges", "none")
+
+	passthrough := acceptsContentEncoding(ctx.Req.Header.Get("Accept-Encoding"), coding)
+	if passthrough {
+		header.Set("Content-Encoding", coding)
+		if size >= 0 {
+			header.Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		if digest := opts.fullDigest(); len(digest) > 0 {
+			// the digest is of the compressed content, which is exactly what is sent
+			setDigestHeaders(header, opts.DigestAlgorithm, digest, true)
+		}
+	} else if etag := header.Get("Etag"); len(etag) > 0 {
+		// the ETag of the content is that of the compressed bytes, the text they decompress to is only equivalent
+		header.Set("Etag", httpcache.WeakETag(etag))
+	}
+
+	if ctx.Req.Method == http.MethodHead {
+		ctx.Status(http.StatusOK)
+		return nil
+	}
+
+	w := newResponseWriter(ctx)
+	if passthrough {
+		_, err := io.Copy(w, reader)
+		return err
+	}
+
+	if coding == "br" {
+		return copyDecompressed(w, brotli.NewReader(reader))
+	}
+	gr, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := gr.Close(); err != nil {
+			log.Error("servePrecompressed: Close: %v", err)
+		}
+	}()
+	return copyDecompressed(w, gr)
+}
+
+// isScriptableDocument returns whether content of the MIME type may run scripts when it is opened as a document.
+// Scripts themselves only run when a page includes them, which the Content-Security-Policy of the page decides on.
+func isScriptableDocument(mimeType string) bool {
+	switch mimeType {
+	case "text/javascript", "text/ecmascript", "application/javascript", "application/ecmascript", "application/x-javascript":
+		return false
+	}
+	return typesniffer.IsScriptableMimeType(mimeType)
+}
+
+// copyDecompressed copies the decompressed content to w, failing once it exceeds MAX_DOWNLOAD_FILE_SIZE.
+// A few bytes of compressed content may decompress to any amount of text.
+func copyDecompressed(w io.Writer, decompressed io.Reader) error {
+	if setting.Service.MaxDownloadFileSize <= 0 {
+		_, err := io.Copy(w, decompressed)
+		return err
+	}
+	if _, err := io.CopyN(w, decompressed, setting.Service.MaxDownloadFileSize); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	// the content may end exactly at the limit
+	if _, err := io.ReadFull(decompressed, make([]byte, 1)); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return errDecompressedTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"code.gitea.io/gitea/modules/log"
+
+	"golang.org/x/text/language"
+)
+
+// languageAttribute is the gitattribute declaring the language of a file as a BCP 47 tag, e.g. gitea-content-language=de
+const languageAttribute = "gitea-content-language"
+
+// languageSuffixPattern matches the language suffixes of translated files, a two letter language optionally followed by
+// a script or a region, e.g. de, pt_BR, zh-Hans or es-419. Three letter languages would be mistaken for too many extensions.
+var languageSuffixPattern = regexp.MustCompile(`^[a-zA-Z]{2}([-_]([a-zA-Z]{2}|[a-zA-Z]{4}|[0-9]{3}))?$`)
+
+// filenameLanguage returns the BCP 47 tag of the language a file name like README.zh-CN.md is suffixed with before
+// its extension, or an empty string if it has no such suffix
+func filenameLanguage(name string) string {
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	suffix := strings.TrimPrefix(path.Ext(base), ".")
+	if len(suffix) == 0 || !languageSuffixPattern.MatchString(suffix) {
+		return ""
+	}
+	tag, err := language.Parse(suffix)
+	if err != nil {
+		return ""
+	}
+	return tag.String()
+}
+
+// contentLanguageAttribute returns the language a file has been declared in by its gitattributes, or an empty string
+func contentLanguageAttribute(attributes map[string]string) string {
+	value := attributes[languageAttribute]
+	switch value {
+	case "", "set", "unset", "unspecified":
+		return ""
+	}
+	tag, err := language.Parse(value)
+	if err != nil {
+		log.Warn("Ignoring invalid %s attribute %q: %v", languageAttribute, value, err)
+		return ""
+	}
+	return tag.String()
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilenameLanguage(t *testing.T) {
+	kases := map[string]string{
+		"README.zh-CN.md":     "zh-CN",
+		"docs/guide.pt_BR.md": "pt-BR",
+		"index.zh-Hans.html":  "zh-Hans",
+		"index.es-419.html":   "es-419",
+		"README.de.md":        "de",
+		"README.md":           "",
+		"de.md":               "",
+		"README.de":           "",
+		"jquery.min.js":       "",
+		"archive.tar.gz":      "",
+		"README.xx.md":        "",
+	}
+	for name, tag := range kases {
+		assert.Equal(t, tag, filenameLanguage(name), name)
+	}
+}
+
+func TestServeDataContentLanguage(t *testing.T) {
+	serve := func(name string, content []byte, opts ServeOptions) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeDataWithOptions(ctx, name, int64(len(content)), bytes.NewReader(content), opts))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		return recorder
+	}
+	text := []byte("# Lisez-moi\n")
+
+	recorder := serve("README.fr.md", text, ServeOptions{})
+	assert.Equal(t, "fr", recorder.Header().Get("Content-Language"))
+
+	recorder = serve("README.md", text, ServeOptions{})
+	assert.Empty(t, recorder.Header().Get("Content-Language"))
+
+	// a declared language wins over the suffix
+	recorder = serve("README.fr.md", text, ServeOptions{ContentLanguage: "fr-CA"})
+	assert.Equal(t, "fr-CA", recorder.Header().Get("Content-Language"))
+
+	// binary content has no language
+	recorder = serve("archive.fr.zip", []byte{0x50, 0x4b, 0x03, 0x04, 0x00, 0x00}, ServeOptions{})
+	assert.Empty(t, recorder.Header().Get("Content-Language"))
+}
+
+func TestServeBlobContentLanguageAttribute(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, _ := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	commit := commitTestRepo(t, ctx, map[string]string{
+		".gitattributes":   "docs/de/* gitea-content-language=de-AT\n*.bad gitea-content-language=not-a-language!\n",
+		"docs/de/LIESMICH": "Hallo\n",
+		"README.ja.md":     "こんにちは\n",
+		"README.bad":       "hello\n",
+	})
+
+	kases := map[string]string{
+		"docs/de/LIESMICH": "de-AT",
+		"README.ja.md":     "ja",
+		"README.bad":       "",
+	}
+	for treePath, tag := range kases {
+		recorder := httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, commit, treePath))
+		assert.Equal(t, http.StatusOK, recorder.Code, treePath)
+		assert.Equal(t, tag, recorder.Header().Get("Content-Language"), treePath)
+	}
+}
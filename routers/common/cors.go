@@ -0,0 +1,101 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/setting"
+)
+
+// setRawFileCORSHeaders allows the origin of the request to read raw files if it is listed in RawFileCORSOrigins
+func setRawFileCORSHeaders(ctx *context.Context) bool {
+	if len(setting.Service.RawFileCORSOrigins) == 0 {
+		return false
+	}
+	ctx.Resp.Header().Add("Vary", "Origin")
+
+	origin := ctx.Req.Header.Get("Origin")
+	if len(origin) == 0 {
+		return false
+	}
+	for _, allowed := range setting.Service.RawFileCORSOrigins {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" {
+			ctx.Resp.Header().Set("Access-Control-Allow-Origin", "*")
+		} else if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			ctx.Resp.Header().Set("Access-Control-Allow-Origin", origin)
+		} else {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// setFrameAncestors restricts the pages which may embed content displayed inline to those RawFileFrameAncestors
+// allows, so that other sites cannot trick users into interacting with it in a frame
+func setFrameAncestors(header http.Header) {
+	sources := strings.TrimSpace(setting.Service.RawFileFrameAncestors)
+	switch sources {
+	case "*":
+		// any site may embed the content
+		header.Del("X-Frame-Options")
+		return
+	case "'self'":
+		header.Set("X-Frame-Options", "SAMEORIGIN")
+	case "'none'":
+		header.Set("X-Frame-Options", "DENY")
+	default:
+		// X-Frame-Options cannot name other origins, browsers which understand frame-ancestors ignore it anyway
+		header.Del("X-Frame-Options")
+	}
+	policy := "frame-ancestors " + sources
+	if csp := header.Get("Content-Security-Policy"); len(csp) > 0 {
+		policy = csp + "; " + policy
+	}
+	header.Set("Content-Security-Policy", policy)
+}
+
+// rawFileMethods are the methods raw files can be requested with
+const rawFileMethods = "GET, HEAD, OPTIONS"
+
+// RawFileMethods rejects requests for raw files with any other method than GET, HEAD and OPTIONS
+func RawFileMethods(ctx *context.Context) {
+	switch ctx.Req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	ctx.Resp.Header().Set("Allow", rawFileMethods)
+	ctx.Status(http.StatusMethodNotAllowed)
+}
+
+// RawFilePreflight answers OPTIONS requests for raw files with the methods they can be requested with,
+// including the CORS preflight requests of the origins allowed to read them. Other requests are passed on.
+func RawFilePreflight(ctx *context.Context) {
+	if ctx.Req.Method != http.MethodOptions {
+		return
+	}
+	ctx.Resp.Header().Set("Allow", rawFileMethods)
+	if setRawFileCORSHeaders(ctx) {
+		ctx.Resp.Header().Set("Access-Control-Allow-Methods", rawFileMethods)
+		ctx.Resp.Header().Set("Access-Control-Allow-Headers", "Range, If-Range, If-None-Match, If-Modified-Since, Cache-Control, Pragma")
+		if maxAge := int64(setting.Service.RawFileCORSMaxAge.Seconds()); maxAge >= 0 {
+			// browsers cap how long they cache a preflight, Chromium at 2 hours and Firefox at 24
+			ctx.Resp.Header().Set("Access-Control-Max-Age", strconv.FormatInt(maxAge, 10))
+		}
+		ctx.Status(http.StatusNoContent)
+		return
+	}
+	if len(ctx.Req.Header.Get("Origin")) > 0 {
+		// the preflight of an origin which may not read raw files
+		ctx.Status(http.StatusForbidden)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
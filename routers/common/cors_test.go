@@ -0,0 +1,173 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeDataCORS(t *testing.T) {
+	defer func(origins []string) { setting.Service.RawFileCORSOrigins = origins }(setting.Service.RawFileCORSOrigins)
+	content := []byte(`{"key": "value"}`)
+
+	serve := func(origin string) http.Header {
+		ctx, recorder := mockServeDataContext(t, "")
+		if origin != "" {
+			ctx.Req.Header.Set("Origin", origin)
+		}
+		assert.NoError(t, ServeData(ctx, "data.json", int64(len(content)), bytes.NewReader(content)))
+		return recorder.Header()
+	}
+
+	setting.Service.RawFileCORSOrigins = nil
+	header := serve("https://example.com")
+	assert.Empty(t, header.Get("Access-Control-Allow-Origin"))
+	assert.NotContains(t, header.Values("Vary"), "Origin")
+
+	setting.Service.RawFileCORSOrigins = []string{"https://example.com", " https://other.example.com/"}
+	header = serve("https://example.com")
+	assert.Equal(t, "https://example.com", header.Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, header.Values("Vary"), "Origin")
+
+	header = serve("https://other.example.com")
+	assert.Equal(t, "https://other.example.com", header.Get("Access-Control-Allow-Origin"))
+
+	header = serve("https://evil.example.com")
+	assert.Empty(t, header.Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, header.Values("Vary"), "Origin")
+
+	header = serve("")
+	assert.Empty(t, header.Get("Access-Control-Allow-Origin"))
+
+	setting.Service.RawFileCORSOrigins = []string{"*"}
+	header = serve("https://anywhere.example.com")
+	assert.Equal(t, "*", header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestRawFilePreflight(t *testing.T) {
+	defer func(origins []string) { setting.Service.RawFileCORSOrigins = origins }(setting.Service.RawFileCORSOrigins)
+	setting.Service.RawFileCORSOrigins = []string{"https://example.com"}
+
+	ctx, recorder := mockServeDataContext(t, "")
+	ctx.Req.Method = http.MethodOptions
+	ctx.Req.Header.Set("Origin", "https://example.com")
+	RawFilePreflight(ctx)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, "https://example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, HEAD, OPTIONS", recorder.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "600", recorder.Header().Get("Access-Control-Max-Age"))
+
+	ctx, recorder = mockServeDataContext(t, "")
+	ctx.Req.Method = http.MethodOptions
+	ctx.Req.Header.Set("Origin", "https://evil.example.com")
+	RawFilePreflight(ctx)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+
+	// a request which isn't cross-origin is told the allowed methods
+	ctx, recorder = mockServeDataContext(t, "")
+	ctx.Req.Method = http.MethodOptions
+	RawFilePreflight(ctx)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, "GET, HEAD, OPTIONS", recorder.Header().Get("Allow"))
+	assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+
+	ctx, _ = mockServeDataContext(t, "")
+	ctx.Req.Method = http.MethodGet
+	RawFilePreflight(ctx)
+	assert.False(t, ctx.Written())
+}
+
+func TestRawFilePreflightMaxAge(t *testing.T) {
+	defer func(origins []string, maxAge time.Duration) {
+		setting.Service.RawFileCORSOrigins = origins
+		setting.Service.RawFileCORSMaxAge = maxAge
+	}(setting.Service.RawFileCORSOrigins, setting.Service.RawFileCORSMaxAge)
+	setting.Service.RawFileCORSOrigins = []string{"https://example.com"}
+
+	kases := map[time.Duration]string{
+		2 * time.Hour:    "7200",
+		0:                "0",
+		-1 * time.Second: "",
+	}
+	for maxAge, header := range kases {
+		setting.Service.RawFileCORSMaxAge = maxAge
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Method = http.MethodOptions
+		ctx.Req.Header.Set("Origin", "https://example.com")
+		RawFilePreflight(ctx)
+		assert.Equal(t, http.StatusNoContent, recorder.Code, maxAge)
+		assert.Equal(t, header, recorder.Header().Get("Access-Control-Max-Age"), maxAge)
+	}
+}
+
+func TestRawFileMethods(t *testing.T) {
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch} {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Method = method
+		RawFileMethods(ctx)
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code, method)
+		assert.Equal(t, "GET, HEAD, OPTIONS", recorder.Header().Get("Allow"), method)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		ctx, _ := mockServeDataContext(t, "")
+		ctx.Req.Method = method
+		RawFileMethods(ctx)
+		assert.False(t, ctx.Written(), method)
+	}
+}
+
+func TestServeDataFrameAncestors(t *testing.T) {
+	defer func(sources string) { setting.Service.RawFileFrameAncestors = sources }(setting.Service.RawFileFrameAncestors)
+	image := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+
+	serve := func(name string, content []byte, form map[string]string) http.Header {
+		ctx, recorder := mockServeDataContext(t, "")
+		// the context middleware sends X-Frame-Options with every response
+		ctx.Resp.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		for k, v := range form {
+			ctx.Req.Form.Set(k, v)
+		}
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder.Header()
+	}
+
+	setting.Service.RawFileFrameAncestors = "'self'"
+	header := serve("image.gif", image, nil)
+	assert.Contains(t, header.Get("Content-Disposition"), "inline")
+	assert.Equal(t, "SAMEORIGIN", header.Get("X-Frame-Options"))
+	assert.Equal(t, "frame-ancestors 'self'", header.Get("Content-Security-Policy"))
+
+	header = serve("image.svg", []byte("<svg></svg>"), nil)
+	assert.Equal(t, "SAMEORIGIN", header.Get("X-Frame-Options"))
+	assert.Equal(t, setting.UI.SVG.ContentSecurityPolicy+"; frame-ancestors 'self'", header.Get("Content-Security-Policy"))
+
+	// content which is saved instead of displayed cannot be framed
+	header = serve("image.gif", image, map[string]string{"download": "1"})
+	assert.Empty(t, header.Get("Content-Security-Policy"))
+
+	setting.Service.RawFileFrameAncestors = "'none'"
+	header = serve("image.gif", image, nil)
+	assert.Equal(t, "DENY", header.Get("X-Frame-Options"))
+	assert.Equal(t, "frame-ancestors 'none'", header.Get("Content-Security-Policy"))
+
+	setting.Service.RawFileFrameAncestors = "'self' https://example.com"
+	header = serve("image.gif", image, nil)
+	assert.Empty(t, header.Get("X-Frame-Options"))
+	assert.Equal(t, "frame-ancestors 'self' https://example.com", header.Get("Content-Security-Policy"))
+
+	setting.Service.RawFileFrameAncestors = "*"
+	header = serve("image.gif", image, nil)
+	assert.Empty(t, header.Get("X-Frame-Options"))
+	assert.Empty(t, header.Get("Content-Security-Policy"))
+}
@@ -0,0 +1,41 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/httpcache"
+	"code.gitea.io/gitea/modules/setting"
+)
+
+// ServeBlobDiff streams the unified diff between two blobs of ctx.Repo.GitRepo as text/x-diff, a single
+// "Binary files ... differ" line if either of them is binary. Blobs larger than MAX_DISPLAY_FILE_SIZE
+// are not compared, the request is refused with 413 Payload Too Large instead.
+func ServeBlobDiff(ctx *context.Context, oldBlob, newBlob *git.Blob) error {
+	if oldBlob.Size() > setting.UI.MaxDisplayFileSize || newBlob.Size() > setting.UI.MaxDisplayFileSize {
+		ctx.Error(http.StatusRequestEntityTooLarge, fmt.Sprintf("Files larger than %d bytes cannot be compared", setting.UI.MaxDisplayFileSize))
+		return nil
+	}
+
+	// the diff between two blobs never changes
+	if httpcache.HandleGenericETagCache(ctx.Req, ctx.Resp, `"`+oldBlob.ID.String()+".."+newBlob.ID.String()+`-diff"`) {
+		return nil
+	}
+
+	setRawFileCORSHeaders(ctx)
+	ctx.Resp.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	ctx.Resp.Header().Set("X-Content-Type-Options", "nosniff")
+	ctx.Resp.Header().Set("Cache-Control", cacheControlDirective("", "text/x-diff", true))
+	ctx.Status(http.StatusOK)
+	if ctx.Req.Method == http.MethodHead {
+		return nil
+	}
+	// the diff is streamed as git produces it, so its length is unknown in advance
+	return git.GetRawDiffForBlobs(ctx.Repo.GitRepo, oldBlob.ID.String(), newBlob.ID.String(), newResponseWriter(ctx))
+}
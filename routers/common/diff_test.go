@@ -0,0 +1,67 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeBlobDiff(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	commit := commitTestRepo(t, ctx, map[string]string{
+		"old.txt": "one\ntwo\nthree\n",
+		"new.txt": "one\n2\nthree\n",
+		"old.bin": "\x00\x01\x02\x03",
+		"new.bin": "\x00\x01\x02\x04",
+	})
+	blob := func(treePath string) *git.Blob {
+		entry, err := commit.GetTreeEntryByPath(treePath)
+		assert.NoError(t, err)
+		return entry.Blob()
+	}
+	serve := func(oldPath, newPath string) {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobDiff(ctx, blob(oldPath), blob(newPath)))
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		serve("old.txt", "new.txt")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "text/x-diff; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `"`+blob("old.txt").ID.String()+".."+blob("new.txt").ID.String()+`-diff"`, recorder.Header().Get("Etag"))
+		body := recorder.Body.String()
+		assert.Contains(t, body, "@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n")
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		serve("old.bin", "new.bin")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "Binary files ")
+		assert.Contains(t, recorder.Body.String(), " differ\n")
+		assert.NotContains(t, recorder.Body.String(), "@@")
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		defer func(size int64) { setting.UI.MaxDisplayFileSize = size }(setting.UI.MaxDisplayFileSize)
+		setting.UI.MaxDisplayFileSize = 10
+		serve("old.txt", "new.txt")
+		assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+		assert.NotContains(t, recorder.Body.String(), "@@")
+	})
+}
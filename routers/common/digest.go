@@ -0,0 +1,101 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"code.gitea.io/gitea/modules/cache"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/setting"
+)
+
+// defaultDigestAlgorithm is the digest algorithm used unless the client asks for another one with Want-Digest
+const defaultDigestAlgorithm = "sha-256"
+
+// digestAlgorithms are the digest algorithms content can be served with, by their names in Want-Digest
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// wantedDigestAlgorithm returns the supported digest algorithm the Want-Digest header (RFC 3230) prefers,
+// e.g. "sha-512" for "sha-256;q=0.5, sha-512", or defaultDigestAlgorithm if it doesn't name any of them
+func wantedDigestAlgorithm(ctx *context.Context) string {
+	algorithm, best := defaultDigestAlgorithm, 0.0
+	for _, part := range strings.Split(ctx.Req.Header.Get("Want-Digest"), ",") {
+		name, q := parseQualityValue(part)
+		if _, ok := digestAlgorithms[name]; ok && q > best {
+			algorithm, best = name, q
+		}
+	}
+	return algorithm
+}
+
+// errDigestNotComputed is returned by the computation of a digest which is only returned if it is cached
+var errDigestNotComputed = errors.New("the digest of the blob is not computed")
+
+// blobDigest returns the digest of the content of the blob computed with the given algorithm.
+// The content of a blob can never change, so the digest is cached by the object id. The digest of a blob
+// larger than MAX_CONTENT_DIGEST_FILE_SIZE, or any digest if cachedOnly is set, is only returned if it is
+// cached, otherwise it is nil.
+func blobDigest(ctx *context.Context, blob *git.Blob, algorithm string, cachedOnly bool) ([]byte, error) {
+	newHash, ok := digestAlgorithms[algorithm]
+	if !ok {
+		algorithm, newHash = defaultDigestAlgorithm, digestAlgorithms[defaultDigestAlgorithm]
+	}
+	digest, err := cache.GetString("BlobDigest:"+algorithm+":"+blob.ID.String(), func() (string, error) {
+		if maxSize := setting.Repository.MaxContentDigestFileSize; cachedOnly || (maxSize > 0 && blob.Size() > maxSize) {
+			return "", errDigestNotComputed
+		}
+		dataRc, err := openBlob(ctx, blob)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if err := dataRc.Close(); err != nil {
+				log.Error("blobDigest: Close: %v", err)
+			}
+		}()
+
+		h := newHash()
+		if _, err := io.Copy(h, dataRc); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	})
+	if err == errDigestNotComputed {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(digest)
+}
+
+// setDigestHeaders sends the digest of the full content computed with the given algorithm (RFC 9530),
+// which is SHA-256 if it is empty. Content-Digest covers the message body, so it is left out of partial responses.
+func setDigestHeaders(header http.Header, algorithm string, digest []byte, full bool) {
+	if len(algorithm) == 0 {
+		algorithm = defaultDigestAlgorithm
+	}
+	encoded := base64.StdEncoding.EncodeToString(digest)
+	header.Set("Repr-Digest", algorithm+"=:"+encoded+":")
+	if full {
+		header.Set("Content-Digest", algorithm+"=:"+encoded+":")
+	}
+	// the obsolete Digest header of RFC 3230 is still the only one understood by many clients
+	header.Set("Digest", strings.ToUpper(algorithm)+"="+encoded)
+	header.Add("Vary", "Want-Digest")
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/setting"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// downloadLimitClients is the number of clients whose downloads are tracked, the least recent ones are forgotten
+const downloadLimitClients = 10000
+
+var (
+	downloadLimitMutex      sync.Mutex
+	downloadLimitBuckets, _ = lru.New(downloadLimitClients)
+
+	// downloadLimitNow returns the current time, it is replaced by tests
+	downloadLimitNow = time.Now
+)
+
+// downloadBucket holds the requests and bytes a client may still download. Both are refilled continuously up to
+// their limits within the window of DownloadRateLimit, and the bytes go into debt after a large download.
+type downloadBucket struct {
+	requests float64
+	bytes    float64
+	updated  time.Time
+}
+
+// refill adds the requests and bytes the client has been granted since the bucket was last updated
+func (b *downloadBucket) refill(t time.Time) {
+	limit := setting.Service.DownloadRateLimit
+	elapsed := t.Sub(b.updated).Seconds() / limit.Window.Seconds()
+	b.requests = math.Min(float64(limit.Requests), b.requests+elapsed*float64(limit.Requests))
+	b.bytes = math.Min(float64(limit.Bytes), b.bytes+elapsed*float64(limit.Bytes))
+	b.updated = t
+}
+
+// isDownloadRateLimited returns whether DownloadRateLimit limits downloads at all
+func isDownloadRateLimited() bool {
+	limit := setting.Service.DownloadRateLimit
+	return (limit.Requests > 0 || limit.Bytes > 0) && limit.Window > 0
+}
+
+// downloadLimitKey identifies the client of the request, signed in users by their id and others by their IP address
+func downloadLimitKey(ctx *context.Context) string {
+	if ctx.IsSigned && ctx.User != nil {
+		return "user:" + strconv.FormatInt(ctx.User.ID, 10)
+	}
+	host, _, err := net.SplitHostPort(ctx.RemoteAddr())
+	if err != nil {
+		host = ctx.RemoteAddr()
+	}
+	return "ip:" + host
+}
+
+// downloadBucketOf returns the bucket of the client of the request, a full one if the client hasn't been seen yet.
+// The caller has to hold downloadLimitMutex.
+func downloadBucketOf(ctx *context.Context, t time.Time) *downloadBucket {
+	key := downloadLimitKey(ctx)
+	if cached, ok := downloadLimitBuckets.Get(key); ok {
+		bucket := cached.(*downloadBucket)
+		bucket.refill(t)
+		return bucket
+	}
+	limit := setting.Service.DownloadRateLimit
+	bucket := &downloadBucket{requests: float64(limit.Requests), bytes: float64(limit.Bytes), updated: t}
+	downloadLimitBuckets.Add(key, bucket)
+	return bucket
+}
+
+// reserveDownload takes a request from the bucket of the client of the request. If the client has run out of
+// requests or bytes, nothing is taken and it returns how long the client has to wait for them to be refilled.
+func reserveDownload(ctx *context.Context) time.Duration {
+	if !isDownloadRateLimited() {
+		return 0
+	}
+	downloadLimitMutex.Lock()
+	defer downloadLimitMutex.Unlock()
+
+	limit := setting.Service.DownloadRateLimit
+	t := downloadLimitNow()
+	bucket := downloadBucketOf(ctx, t)
+	var wait float64
+	if limit.Requests > 0 && bucket.requests < 1 {
+		wait = (1 - bucket.requests) / float64(limit.Requests)
+	}
+	if limit.Bytes > 0 && bucket.bytes <= 0 {
+		// a download may start as long as there is a byte left, however large it is
+		wait = math.Max(wait, (1-bucket.bytes)/float64(limit.Bytes))
+	}
+	if wait > 0 {
+		return time.Duration(wait * float64(limit.Window))
+	}
+	bucket.requests--
+	return 0
+}
+
+// chargeDownload takes the bytes which have been sent to the client of the request from its bucket
+func chargeDownload(ctx *context.Context, written int) {
+	if !isDownloadRateLimited() || setting.Service.DownloadRateLimit.Bytes <= 0 || written <= 0 {
+		return
+	}
+	downloadLimitMutex.Lock()
+	defer downloadLimitMutex.Unlock()
+	downloadBucketOf(ctx, downloadLimitNow()).bytes -= float64(written)
+}
+
+// respondRateLimited responds 429 Too Many Requests, asking the client to retry once its limit has been refilled
+func respondRateLimited(ctx *context.Context, wait time.Duration) {
+	log.Debug("ServeData: %s has exceeded the download rate limit, it has to wait %v", downloadLimitKey(ctx), wait)
+	ctx.Resp.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+	ctx.Error(http.StatusTooManyRequests)
+}
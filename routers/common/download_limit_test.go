@@ -0,0 +1,96 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadRateLimit(t *testing.T) {
+	defer func(requests int, bytes int64, window time.Duration) {
+		setting.Service.DownloadRateLimit.Requests = requests
+		setting.Service.DownloadRateLimit.Bytes = bytes
+		setting.Service.DownloadRateLimit.Window = window
+	}(setting.Service.DownloadRateLimit.Requests, setting.Service.DownloadRateLimit.Bytes, setting.Service.DownloadRateLimit.Window)
+	defer func(now func() time.Time) { downloadLimitNow = now }(downloadLimitNow)
+
+	clock := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	downloadLimitNow = func() time.Time { return clock }
+	content := []byte(strings.Repeat("0123456789", 10))
+
+	serve := func(remoteAddr string, user *user_model.User) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.RemoteAddr = remoteAddr
+		ctx.User = user
+		ctx.IsSigned = user != nil
+		assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	t.Run("Requests", func(t *testing.T) {
+		downloadLimitBuckets.Purge()
+		setting.Service.DownloadRateLimit.Requests = 3
+		setting.Service.DownloadRateLimit.Bytes = 0
+		setting.Service.DownloadRateLimit.Window = time.Minute
+
+		// a burst up to the limit is served
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", nil).Code)
+		}
+		recorder := serve("192.0.2.1:5678", nil)
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "20", recorder.Header().Get("Retry-After"))
+
+		// other clients have limits of their own
+		assert.Equal(t, http.StatusOK, serve("192.0.2.2:1234", nil).Code)
+		user := &user_model.User{ID: 2}
+		assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", user).Code)
+
+		// a request is refilled every 20 seconds
+		clock = clock.Add(10 * time.Second)
+		recorder = serve("192.0.2.1:1234", nil)
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		assert.Equal(t, "10", recorder.Header().Get("Retry-After"))
+		clock = clock.Add(10 * time.Second)
+		assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", nil).Code)
+		assert.Equal(t, http.StatusTooManyRequests, serve("192.0.2.1:1234", nil).Code)
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		downloadLimitBuckets.Purge()
+		setting.Service.DownloadRateLimit.Requests = 0
+		setting.Service.DownloadRateLimit.Bytes = 150
+		setting.Service.DownloadRateLimit.Window = time.Minute
+
+		// the download which exceeds the limit is still served in full, the next one has to wait
+		assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", nil).Code)
+		recorder := serve("192.0.2.1:1234", nil)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, content, recorder.Body.Bytes())
+		recorder = serve("192.0.2.1:1234", nil)
+		assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		// 51 bytes are missing, 150 are refilled per minute
+		assert.Equal(t, "21", recorder.Header().Get("Retry-After"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		downloadLimitBuckets.Purge()
+		setting.Service.DownloadRateLimit.Requests = 0
+		setting.Service.DownloadRateLimit.Bytes = 0
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", nil).Code)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/metrics"
+)
+
+// downloadCategory returns the category of content of the given MIME type the download metrics are labelled with
+func downloadCategory(mimeType string) string {
+	switch category := strings.SplitN(mimeType, "/", 2)[0]; category {
+	case "text", "image", "audio", "video", "font", "application":
+		return category
+	}
+	return "other"
+}
+
+// countDownload updates the download metrics with the response to a download of the content called name,
+// of which written bytes have been written. Responses which don't serve the content aren't counted as downloads.
+func countDownload(ctx *context.Context, name string, written int) {
+	mimeType := strings.SplitN(ctx.Resp.Header().Get("Content-Type"), ";", 2)[0]
+	if len(mimeType) == 0 || strings.HasPrefix(mimeType, "multipart/") {
+		// a response with nothing or several parts of the content tells nothing about it
+		mimeType = mime.TypeByExtension(path.Ext(name))
+	}
+	category := downloadCategory(mimeType)
+
+	switch ctx.Resp.Status() {
+	case http.StatusOK:
+		metrics.Downloads.WithLabelValues(metrics.DownloadFull, category).Inc()
+	case http.StatusPartialContent:
+		metrics.Downloads.WithLabelValues(metrics.DownloadRange, category).Inc()
+	case http.StatusNotModified:
+		metrics.Downloads.WithLabelValues(metrics.DownloadNotModified, category).Inc()
+	}
+	if written > 0 {
+		metrics.DownloadBytes.WithLabelValues(category).Add(float64(written))
+	}
+}
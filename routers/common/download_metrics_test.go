@@ -0,0 +1,93 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/modules/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadCategory(t *testing.T) {
+	kases := map[string]string{
+		"text/plain":               "text",
+		"image/png":                "image",
+		"video/mp4":                "video",
+		"application/octet-stream": "application",
+		"multipart/byteranges":     "other",
+		"":                         "other",
+	}
+	for mimeType, category := range kases {
+		assert.Equal(t, category, downloadCategory(mimeType), mimeType)
+	}
+}
+
+func TestServeDataMetrics(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+	size := int64(len(content))
+
+	// the counters are global, so only their increments are checked
+	counted := func(response, category string) func() float64 {
+		downloads := testutil.ToFloat64(metrics.Downloads.WithLabelValues(response, category))
+		bytes := testutil.ToFloat64(metrics.DownloadBytes.WithLabelValues(category))
+		return func() float64 {
+			assert.Equal(t, downloads+1, testutil.ToFloat64(metrics.Downloads.WithLabelValues(response, category)))
+			return testutil.ToFloat64(metrics.DownloadBytes.WithLabelValues(category)) - bytes
+		}
+	}
+
+	t.Run("Full", func(t *testing.T) {
+		check := counted(metrics.DownloadFull, "text")
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, "file.txt", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.EqualValues(t, size, check())
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		check := counted(metrics.DownloadRange, "text")
+		ctx, recorder := mockServeDataContext(t, "bytes=10-19")
+		assert.NoError(t, ServeData(ctx, "file.txt", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.EqualValues(t, 10, check())
+	})
+
+	t.Run("MultipleRanges", func(t *testing.T) {
+		// the parts are categorized by the extension of the file
+		check := counted(metrics.DownloadRange, "image")
+		ctx, recorder := mockServeDataContext(t, "bytes=0-1,10-19")
+		assert.NoError(t, ServeData(ctx, "file.png", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.EqualValues(t, recorder.Body.Len(), check())
+	})
+
+	t.Run("NotModified", func(t *testing.T) {
+		// nothing is sniffed, so the response is categorized by the extension of the file
+		check := counted(metrics.DownloadNotModified, "image")
+		lastModified := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+		assert.NoError(t, ServeDataWithOptions(ctx, "file.png", size, bytes.NewReader(content), ServeOptions{LastModified: lastModified}))
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		assert.EqualValues(t, 0, check())
+	})
+
+	t.Run("ETag", func(t *testing.T) {
+		check := counted(metrics.DownloadNotModified, "image")
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.URL.Path = "/user2/repo1/raw/branch/master/file.png"
+		ctx.Req.Header.Set("If-None-Match", BlobETag(ctx, testBlobID))
+		assert.True(t, HandleBlobETagCache(ctx, testBlobID, size))
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		assert.EqualValues(t, 0, check())
+	})
+}
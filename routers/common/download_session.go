@@ -0,0 +1,170 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// downloadSessionSubject tells the tokens of download sessions apart from other tokens signed with the secret key
+const downloadSessionSubject = "download"
+
+// ErrInvalidDownloadToken is returned for a token which is malformed, expired, not signed by this
+// instance or issued for another repository
+var ErrInvalidDownloadToken = errors.New("invalid download token")
+
+// DownloadSessionClaims pins a download to the blob found at TreePath in the commit CommitID, so that
+// resuming it always gets the same content even if the branch or tag it was started from has moved on
+type DownloadSessionClaims struct {
+	RepoID   int64
+	CommitID string
+	TreePath string
+	BlobID   string
+	jwt.RegisteredClaims
+}
+
+// downloadSession is the response to a request for a download session
+type downloadSession struct {
+	URL      string    `json:"url"`
+	CommitID string    `json:"commit_id"`
+	SHA      string    `json:"sha"`
+	Size     int64     `json:"size"`
+	Expires  time.Time `json:"expires"`
+}
+
+// CreateDownloadToken returns a signed token for a download session of the blob blobID at treePath in the commit,
+// which expires after DOWNLOAD_SESSION_EXPIRY. It is stateless, so it stays valid across restarts.
+func CreateDownloadToken(repoID int64, commitID, treePath, blobID string) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(setting.Repository.DownloadSessionExpiry)
+	claims := &DownloadSessionClaims{
+		RepoID:   repoID,
+		CommitID: commitID,
+		TreePath: treePath,
+		BlobID:   blobID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   downloadSessionSubject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(setting.SecretKey))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires.Truncate(time.Second), nil
+}
+
+// ParseDownloadToken returns the claims of a token created by CreateDownloadToken for the repository.
+// It returns ErrInvalidDownloadToken if the token is not valid.
+func ParseDownloadToken(repoID int64, token string) (*DownloadSessionClaims, error) {
+	parsed, err := jwt.ParseWithClaims(token, &DownloadSessionClaims{}, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return []byte(setting.SecretKey), nil
+	})
+	if err != nil {
+		return nil, ErrInvalidDownloadToken
+	}
+	claims, ok := parsed.Claims.(*DownloadSessionClaims)
+	if !ok || !parsed.Valid || claims.Subject != downloadSessionSubject || claims.RepoID != repoID {
+		return nil, ErrInvalidDownloadToken
+	}
+	return claims, nil
+}
+
+// RespondDownloadSession responds with a JSON description of a download session of the blob found at
+// treePath in the commit. Its URL is link followed by the token and serves the pinned blob until the token expires.
+func RespondDownloadSession(ctx *context.Context, commit *git.Commit, treePath, link string) error {
+	blob, err := commit.GetBlobByPath(treePath)
+	if err != nil {
+		if git.IsErrNotExist(err) {
+			ctx.NotFound("GetBlobByPath", nil)
+			return nil
+		}
+		return err
+	}
+	token, expires, err := CreateDownloadToken(ctx.Repo.Repository.ID, commit.ID.String(), treePath, blob.ID.String())
+	if err != nil {
+		return err
+	}
+	// the session is new with every request
+	ctx.Resp.Header().Set("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, downloadSession{
+		URL:      link + token,
+		CommitID: commit.ID.String(),
+		SHA:      blob.ID.String(),
+		Size:     blob.Size(),
+		Expires:  expires,
+	})
+	return nil
+}
+
+// LoadDownloadSession points ctx.Repo to the commit and the path pinned by the token and returns the blob
+// found there. It responds with a 404 and returns nil if the token is invalid or the blob is gone.
+func LoadDownloadSession(ctx *context.Context, token string) (*git.Blob, error) {
+	claims, err := ParseDownloadToken(ctx.Repo.Repository.ID, token)
+	if err != nil {
+		ctx.NotFound("ParseDownloadToken", nil)
+		return nil, nil
+	}
+	commit, err := ctx.Repo.GitRepo.GetCommit(claims.CommitID)
+	if err != nil {
+		if git.IsErrNotExist(err) {
+			ctx.NotFound("GetCommit", nil)
+			return nil, nil
+		}
+		return nil, err
+	}
+	blob, err := commit.GetBlobByPath(claims.TreePath)
+	if err != nil {
+		if git.IsErrNotExist(err) {
+			ctx.NotFound("GetBlobByPath", nil)
+			return nil, nil
+		}
+		return nil, err
+	}
+	if blob.ID.String() != claims.BlobID {
+		ctx.NotFound("GetBlobByPath", nil)
+		return nil, nil
+	}
+
+	ctx.Repo.Commit = commit
+	ctx.Repo.CommitID = claims.CommitID
+	ctx.Repo.TreePath = claims.TreePath
+	return blob, nil
+}
+
+// ServeDownloadSession serves the blob of a download session loaded by LoadDownloadSession. The content of a
+// session never changes, so ranges asked for by a resuming client are streamed from the blob even
+// though the content of a blob cannot be seeked, and the full content is sent with Accept-Ranges: bytes.
+func ServeDownloadSession(ctx *context.Context, blob *git.Blob, opts ServeOptions) error {
+	rng := ctx.Req.Header.Get("Range")
+	if ifRange := ctx.Req.Header.Get("If-Range"); len(rng) > 0 && ctx.Req.Method != http.MethodHead &&
+		(len(ifRange) == 0 || ifRange == BlobETag(ctx, blob.ID.String())) {
+		if ranges, err := parseRangeHeader(rng, blob.Size()); err == nil && len(ranges) == 1 {
+			return ServeBlobRange(ctx, blob, ranges[0].start, ranges[0].length())
+		}
+	}
+	opts.blobRanges = true
+	return ServeBlobWithOptions(ctx, blob, opts)
+}
+
+// sequentialReader reads content which can only be read from its start, like a blob. Its ranges are served
+// in ascending order in a single pass over it, instead of reading the content anew for each of them.
+type sequentialReader struct {
+	io.Reader
+}
@@ -0,0 +1,188 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testCommitID = "65f1bf27bc3bf70f64657658635e66094edbcb4d"
+	testBlobID   = "ce013625030ba8dba906f756967f9e9ca394464a"
+)
+
+func TestDownloadToken(t *testing.T) {
+	token, expires, err := CreateDownloadToken(31, testCommitID, "a/c/hi", testBlobID)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(setting.Repository.DownloadSessionExpiry), expires, time.Minute)
+
+	claims, err := ParseDownloadToken(31, token)
+	assert.NoError(t, err)
+	assert.Equal(t, testCommitID, claims.CommitID)
+	assert.Equal(t, "a/c/hi", claims.TreePath)
+	assert.Equal(t, testBlobID, claims.BlobID)
+
+	t.Run("OtherRepository", func(t *testing.T) {
+		_, err := ParseDownloadToken(1, token)
+		assert.Equal(t, ErrInvalidDownloadToken, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		parts := strings.Split(token, ".")
+		other, _, err := CreateDownloadToken(31, testCommitID, "a/b/link_hi", testBlobID)
+		assert.NoError(t, err)
+		// the claims of another token with the signature of this one
+		parts[1] = strings.Split(other, ".")[1]
+		_, err = ParseDownloadToken(31, strings.Join(parts, "."))
+		assert.Equal(t, ErrInvalidDownloadToken, err)
+		_, err = ParseDownloadToken(31, "not a token")
+		assert.Equal(t, ErrInvalidDownloadToken, err)
+	})
+
+	t.Run("OtherSecret", func(t *testing.T) {
+		defer func(key string) { setting.SecretKey = key }(setting.SecretKey)
+		setting.SecretKey = "another secret"
+		_, err := ParseDownloadToken(31, token)
+		assert.Equal(t, ErrInvalidDownloadToken, err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		defer func(expiry time.Duration) { setting.Repository.DownloadSessionExpiry = expiry }(setting.Repository.DownloadSessionExpiry)
+		setting.Repository.DownloadSessionExpiry = -time.Minute
+		expired, _, err := CreateDownloadToken(31, testCommitID, "a/c/hi", testBlobID)
+		assert.NoError(t, err)
+		_, err = ParseDownloadToken(31, expired)
+		assert.Equal(t, ErrInvalidDownloadToken, err)
+	})
+}
+
+func TestDownloadSession(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+	commit := ctx.Repo.Commit
+
+	assert.NoError(t, RespondDownloadSession(ctx, commit, "a/c/hi", "http://localhost/raw/session/"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	var session downloadSession
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &session))
+	assert.Equal(t, commit.ID.String(), session.CommitID)
+	assert.Equal(t, testBlobID, session.SHA)
+	assert.EqualValues(t, 6, session.Size)
+	assert.True(t, strings.HasPrefix(session.URL, "http://localhost/raw/session/"))
+	token := strings.TrimPrefix(session.URL, "http://localhost/raw/session/")
+
+	t.Run("Pinned", func(t *testing.T) {
+		// whatever the request was resolved to, the session serves what it was started with
+		ctx.Repo.Commit = nil
+		ctx.Repo.CommitID = "master"
+		ctx.Repo.TreePath = "a/b/link_hi"
+		blob, err := LoadDownloadSession(ctx, token)
+		assert.NoError(t, err)
+		if assert.NotNil(t, blob) {
+			assert.Equal(t, testBlobID, blob.ID.String())
+		}
+		assert.Equal(t, commit.ID, ctx.Repo.Commit.ID)
+		assert.Equal(t, commit.ID.String(), ctx.Repo.CommitID)
+		assert.Equal(t, "a/c/hi", ctx.Repo.TreePath)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		blob, err := LoadDownloadSession(ctx, token+"x")
+		assert.NoError(t, err)
+		assert.Nil(t, blob)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("BlobMismatch", func(t *testing.T) {
+		other, _, err := CreateDownloadToken(ctx.Repo.Repository.ID, commit.ID.String(), "a/c/hi", "2cec0f7069ed09d934e904c49f414d8bdf818ce4")
+		assert.NoError(t, err)
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		blob, err := LoadDownloadSession(ctx, other)
+		assert.NoError(t, err)
+		assert.Nil(t, blob)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("Serve", func(t *testing.T) {
+		defer func(dataAsync func(*git.Blob) (io.ReadCloser, error)) { blobDataAsync = dataAsync }(blobDataAsync)
+		opened := 0
+		blobDataAsync = func(blob *git.Blob) (io.ReadCloser, error) {
+			opened++
+			return blob.DataAsync()
+		}
+		serve := func(rng string) *httptest.ResponseRecorder {
+			opened = 0
+			ctx, recorder := mockServeDataContext(t, rng)
+			test.LoadRepo(t, ctx, 31)
+			test.LoadGitRepo(t, ctx)
+			defer ctx.Repo.GitRepo.Close()
+			blob, err := LoadDownloadSession(ctx, token)
+			assert.NoError(t, err)
+			assert.NoError(t, ServeDownloadSession(ctx, blob, ServeOptions{}))
+			return recorder
+		}
+
+		// clients may resume a download which has been sent in full
+		recorder := serve("")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "bytes", recorder.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "hello\n", recorder.Body.String())
+
+		recorder = serve("bytes=2-")
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "bytes 2-5/6", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, "llo\n", recorder.Body.String())
+		openedForRange := opened
+
+		// the blob is read once whatever the number of ranges, which are sent in its order
+		recorder = serve("bytes=4-5,0-0,2-2")
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, openedForRange, opened)
+		mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+		assert.NoError(t, err)
+		assert.Equal(t, "multipart/byteranges", mediaType)
+		mr := multipart.NewReader(recorder.Body, params["boundary"])
+		for _, expected := range []string{"h", "l", "o\n"} {
+			part, err := mr.NextPart()
+			if assert.NoError(t, err) {
+				data, err := io.ReadAll(part)
+				assert.NoError(t, err)
+				assert.Equal(t, expected, string(data))
+			}
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, RespondDownloadSession(ctx, commit, "a/nonexistent", "http://localhost/raw/session/"))
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
@@ -0,0 +1,83 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/httpcache"
+)
+
+// directoryEntry is an entry of the JSON listing of a directory served by ServeDirectoryListing
+type directoryEntry struct {
+	Name string `json:"name"`
+	// Type is one of "file", "dir", "symlink" and "submodule"
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	SHA  string `json:"sha"`
+}
+
+// isListingRequested returns whether the entries of a directory are requested with ?list=1
+func isListingRequested(ctx *context.Context) bool {
+	return ctx.FormBool("list")
+}
+
+// ServeDirectoryListing responds with a JSON listing of the entries of the directory found at treePath
+// in the commit, or with a 404 if there is no such path or it isn't a directory
+func ServeDirectoryListing(ctx *context.Context, commit *git.Commit, treePath string) error {
+	entry, err := commit.GetTreeEntryByPath(treePath)
+	if err != nil {
+		if git.IsErrNotExist(err) {
+			ctx.NotFound("GetTreeEntryByPath", nil)
+			return nil
+		}
+		return err
+	}
+	if !entry.IsDir() {
+		ctx.NotFound("GetTreeEntryByPath", nil)
+		return nil
+	}
+
+	// the listing only depends on the tree, so its id identifies the listing like a blob id does its content
+	if httpcache.HandleGenericETagCache(ctx.Req, ctx.Resp, `"`+entry.ID.String()+`-list"`) {
+		return nil
+	}
+
+	tree, err := commit.SubTree(treePath)
+	if err != nil {
+		return err
+	}
+	entries, err := tree.ListEntries()
+	if err != nil {
+		return err
+	}
+
+	listing := make([]directoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		de := directoryEntry{
+			Name: entry.Name(),
+			Type: "file",
+			SHA:  entry.ID.String(),
+		}
+		switch {
+		case entry.IsDir():
+			de.Type = "dir"
+		case entry.IsSubModule():
+			de.Type = "submodule"
+		case entry.IsLink():
+			de.Type = "symlink"
+			de.Size = entry.Size()
+		default:
+			de.Size = entry.Size()
+		}
+		listing = append(listing, de)
+	}
+
+	ctx.Resp.Header().Set("Last-Modified", commit.Committer.When.UTC().Format(http.TimeFormat))
+	ctx.JSON(http.StatusOK, listing)
+	return nil
+}
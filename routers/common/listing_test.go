@@ -0,0 +1,87 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeDirectoryListing(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+
+	list := func(treePath string) []directoryEntry {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeDirectoryListing(ctx, ctx.Repo.Commit, treePath))
+		if recorder.Code != http.StatusOK {
+			return nil
+		}
+		var entries []directoryEntry
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+		return entries
+	}
+
+	t.Run("Subdirectories", func(t *testing.T) {
+		assert.Equal(t, []directoryEntry{
+			{Name: "b", Type: "dir", SHA: "c50ac6b9e25abb8200bb377755367d7265c581cf"},
+			{Name: "c", Type: "dir", SHA: "0581d7edf45206787ff93956ea892e8a2ae77604"},
+			{Name: "link_annex", Type: "symlink", Size: 33, SHA: "2cec0f7069ed09d934e904c49f414d8bdf818ce4"},
+		}, list("a"))
+		assert.Equal(t, `"8370977f63979e140b6b58992b1fdb4098b24cd9-list"`, recorder.Header().Get("Etag"))
+		assert.NotEmpty(t, recorder.Header().Get("Cache-Control"))
+		assert.NotEmpty(t, recorder.Header().Get("Last-Modified"))
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		assert.Equal(t, []directoryEntry{
+			{Name: "hi", Type: "file", Size: 6, SHA: "ce013625030ba8dba906f756967f9e9ca394464a"},
+		}, list("a/c"))
+	})
+
+	t.Run("NotModified", func(t *testing.T) {
+		ctx.Req.Header.Set("If-None-Match", `"0581d7edf45206787ff93956ea892e8a2ae77604-list"`)
+		defer ctx.Req.Header.Del("If-None-Match")
+		assert.Nil(t, list("a/c"))
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+	})
+
+	t.Run("NotDirectory", func(t *testing.T) {
+		assert.Nil(t, list("a/c/hi"))
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Nil(t, list("a/nonexistent"))
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("ServeBlobByPath", func(t *testing.T) {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		ctx.Req.Form.Set("list", "1")
+		defer ctx.Req.Form.Del("list")
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c"))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Header().Get("Content-Type"), "application/json")
+
+		// files are still served as they are
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+		assert.Equal(t, "hello\n", recorder.Body.String())
+	})
+}
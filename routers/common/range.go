@@ -0,0 +1,333 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/context"
+)
+
+// byteRange is an inclusive range of bytes requested by a Range header
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+func (r byteRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// isIfRangeValid checks whether the If-Range validator of the request, if any, still matches
+// the ETag of the content. A stale validator means the full content has to be served instead,
+// and so does a weak one, as the strong comparison is required by RFC 7233.
+func isIfRangeValid(ctx *context.Context) bool {
+	ifRange := ctx.Req.Header.Get("If-Range")
+	if len(ifRange) == 0 {
+		return true
+	}
+	etag := ctx.Resp.Header().Get("Etag")
+	return len(etag) > 0 && !strings.HasPrefix(etag, "W/") && ifRange == etag
+}
+
+// RangeErrorKind tells why a Range header cannot be served
+type RangeErrorKind int
+
+const (
+	// RangeMalformed is a Range header which doesn't follow the syntax of RFC 7233
+	RangeMalformed RangeErrorKind = iota
+	// RangeUnsupportedUnit is a Range header of anything but bytes
+	RangeUnsupportedUnit
+	// RangeNotSatisfiable is a Range header none of whose ranges lies within the content
+	RangeNotSatisfiable
+	// RangeExcessive is a Range header with more than maxRanges ranges or with ranges which add up to more than
+	// the content, serving it would send the content over and over again
+	RangeExcessive
+)
+
+// maxRanges is the number of ranges a Range header may ask for
+const maxRanges = 100
+
+// RangeError represents a Range header which cannot be served
+type RangeError struct {
+	Kind  RangeErrorKind
+	Range string
+}
+
+// IsRangeError checks if an error is a RangeError.
+func IsRangeError(err error) bool {
+	_, ok := err.(RangeError)
+	return ok
+}
+
+func (err RangeError) Error() string {
+	switch err.Kind {
+	case RangeUnsupportedUnit:
+		return fmt.Sprintf("unsupported range unit: %s", err.Range)
+	case RangeNotSatisfiable:
+		return fmt.Sprintf("range not satisfiable: %s", err.Range)
+	case RangeExcessive:
+		return fmt.Sprintf("excessive range: %s", err.Range)
+	default:
+		return fmt.Sprintf("invalid range: %s", err.Range)
+	}
+}
+
+// StatusCode returns the status of a response refusing the Range header, 416 Range Not Satisfiable if none of its
+// ranges lies within the content and 400 Bad Request otherwise. ServeData only refuses unsatisfiable ranges,
+// RFC 7233 asks for other Range headers, including excessive ones, to be ignored and the full content to be served.
+func (err RangeError) StatusCode() int {
+	if err.Kind == RangeNotSatisfiable {
+		return http.StatusRequestedRangeNotSatisfiable
+	}
+	return http.StatusBadRequest
+}
+
+// parseRangeHeader parses a "bytes=start-end" Range header, which may contain several comma separated
+// ranges, against the given size and returns the satisfiable ranges with overlapping ones merged.
+func parseRangeHeader(rng string, size int64) ([]byteRange, error) {
+	// Range: bytes=131072-
+	if !strings.HasPrefix(rng, "bytes=") {
+		return nil, RangeError{Kind: RangeUnsupportedUnit, Range: rng}
+	}
+	specs := strings.Split(strings.TrimPrefix(rng, "bytes="), ",")
+	if len(specs) > maxRanges {
+		return nil, RangeError{Kind: RangeExcessive, Range: rng}
+	}
+	ranges := make([]byteRange, 0, len(specs))
+	valid := false
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 {
+			// lists may contain empty elements
+			continue
+		}
+		r, err := parseRangeSpec(spec, size)
+		if rangeErr, ok := err.(RangeError); ok && rangeErr.Kind == RangeNotSatisfiable {
+			// a range set is only unsatisfiable if all of its ranges are
+			valid = true
+			continue
+		} else if err != nil {
+			return nil, RangeError{Kind: RangeMalformed, Range: rng}
+		}
+		valid = true
+		ranges = append(ranges, r)
+	}
+	if !valid {
+		return nil, RangeError{Kind: RangeMalformed, Range: rng}
+	}
+	if len(ranges) == 0 {
+		return nil, RangeError{Kind: RangeNotSatisfiable, Range: rng}
+	}
+	// like net/http.ServeContent, ranges which ask for more than the whole content are ignored
+	var sum int64
+	for _, r := range ranges {
+		sum += r.length()
+	}
+	if sum > size {
+		return nil, RangeError{Kind: RangeExcessive, Range: rng}
+	}
+	return coalesceRanges(ranges), nil
+}
+
+// coalesceRanges merges overlapping and adjacent ranges, the others are left in the order they have been asked for
+func coalesceRanges(ranges []byteRange) []byteRange {
+	coalesced := make([]byteRange, 0, len(ranges))
+	for _, r := range ranges {
+		merged := false
+		for i, c := range coalesced {
+			if r.start <= c.end+1 && c.start <= r.end+1 {
+				if r.start < c.start {
+					coalesced[i].start = r.start
+				}
+				if r.end > c.end {
+					coalesced[i].end = r.end
+				}
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			coalesced = append(coalesced, r)
+		}
+	}
+	if len(coalesced) < len(ranges) {
+		// a merged range may now overlap others it didn't overlap before
+		return coalesceRanges(coalesced)
+	}
+	return coalesced
+}
+
+// parseRangeSpec parses a single "start-end" range against the given size
+func parseRangeSpec(spec string, size int64) (r byteRange, err error) {
+	arr := strings.Split(spec, "-")
+	if len(arr) != 2 {
+		return r, RangeError{Kind: RangeMalformed, Range: spec}
+	}
+	if len(arr[0]) == 0 {
+		// Range: bytes=-500 requests the final 500 bytes
+		suffix, err := parseRangeNumber(arr[1])
+		if err != nil {
+			return r, err
+		}
+		r.start = size - suffix
+		if r.start < 0 {
+			r.start = 0
+		}
+		r.end = size - 1
+	} else if r.start, err = parseRangeNumber(arr[0]); err != nil {
+		return r, err
+	} else if len(arr[1]) == 0 {
+		r.end = size - 1
+	} else {
+		r.end, err = parseRangeNumber(arr[1])
+		if err != nil {
+			return r, err
+		}
+		if r.end > size-1 {
+			r.end = size - 1
+		}
+	}
+
+	if r.start > size-1 || r.start > r.end {
+		return r, RangeError{Kind: RangeNotSatisfiable, Range: spec}
+	}
+	return r, nil
+}
+
+// parseRangeNumber parses a position of a range, which unlike strconv.ParseInt accepts digits only
+func parseRangeNumber(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, RangeError{Kind: RangeMalformed, Range: s}
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, RangeError{Kind: RangeMalformed, Range: s}
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, RangeError{Kind: RangeMalformed, Range: s}
+	}
+	return n, nil
+}
+
+// RangeReader is implemented by content which can read a part of itself without reading it from its start,
+// e.g. LFS objects in an object storage which transfers only the part asked for with a ranged request
+type RangeReader interface {
+	io.Reader
+	// ReadRange opens length bytes of the content starting at offset
+	ReadRange(offset, length int64) (io.ReadCloser, error)
+}
+
+// isRangeable returns whether ranges of the content of reader can be served without reading all of it,
+// a reader which is only an io.Seeker is seeked to the start of each range and a sequentialReader is read
+// up to the end of the last range once
+func isRangeable(reader io.Reader) bool {
+	switch reader.(type) {
+	case io.ReaderAt, RangeReader, io.Seeker, *sequentialReader:
+		return true
+	}
+	return false
+}
+
+// serveRange writes the bytes of reader covered by r to w.
+// consumed is the number of bytes which have already been read from reader.
+func serveRange(w io.Writer, reader io.Reader, consumed int64, r byteRange) error {
+	switch rd := reader.(type) {
+	case RangeReader:
+		rc, err := rd.ReadRange(r.start, r.length())
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		reader = rc
+	case io.ReaderAt:
+		reader = io.NewSectionReader(rd, r.start, r.length())
+	case io.Seeker:
+		if _, err := rd.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+	default:
+		if r.start < consumed {
+			return fmt.Errorf("unable to rewind reader to %d", r.start)
+		}
+		if _, err := io.CopyN(io.Discard, reader, r.start-consumed); err != nil {
+			return err
+		}
+	}
+	_, err := io.CopyN(w, reader, r.length())
+	return err
+}
+
+// serveMultipartRanges writes several ranges of reader to w as multipart/byteranges, setting the response headers on ctx.
+// Each part carries its own Content-Range and the Content-Type determined for the whole content.
+func serveMultipartRanges(ctx *context.Context, w io.Writer, reader io.Reader, consumed int64, ranges []byteRange, size int64) error {
+	contentType := ctx.Resp.Header().Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+
+	mw := multipart.NewWriter(w)
+
+	// Calculate the final length by writing the same parts without their content
+	var counter countingWriter
+	cw := multipart.NewWriter(&counter)
+	if err := cw.SetBoundary(mw.Boundary()); err != nil {
+		return err
+	}
+	var length int64
+	for _, r := range ranges {
+		if _, err := cw.CreatePart(r.mimeHeader(contentType, size)); err != nil {
+			return err
+		}
+		length += r.length()
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	length += int64(counter)
+
+	ctx.Resp.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	ctx.Resp.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	ctx.Status(http.StatusPartialContent)
+
+	for _, r := range ranges {
+		part, err := mw.CreatePart(r.mimeHeader(contentType, size))
+		if err != nil {
+			return err
+		}
+		if err := serveRange(part, reader, consumed, r); err != nil {
+			return err
+		}
+		// a reader which can only be read forwards is now at the end of the range
+		consumed = r.end + 1
+	}
+	return mw.Close()
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
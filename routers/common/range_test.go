@@ -0,0 +1,189 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRangeHeader(t *testing.T) {
+	// noRangeError marks ranges which are parsed without error
+	const noRangeError RangeErrorKind = -1
+	kases := []struct {
+		rng      string
+		expected []byteRange
+		kind     RangeErrorKind
+	}{
+		{"bytes=0-9", []byteRange{{0, 9}}, noRangeError},
+		{"bytes=50-59", []byteRange{{50, 59}}, noRangeError},
+		{"bytes=90-", []byteRange{{90, 99}}, noRangeError},
+		{"bytes=-10", []byteRange{{90, 99}}, noRangeError},
+		{"bytes=-500", []byteRange{{0, 99}}, noRangeError},
+		{"bytes=50-500", []byteRange{{50, 99}}, noRangeError},
+		{"bytes=0-0, 10-19 ,-5", []byteRange{{0, 0}, {10, 19}, {95, 99}}, noRangeError},
+		{"bytes=0-9,", []byteRange{{0, 9}}, noRangeError},
+		{"bytes=200-, 0-9", []byteRange{{0, 9}}, noRangeError},
+		{"bytes=0-9, 5-14", []byteRange{{0, 14}}, noRangeError},
+		{"bytes=0-9,10-19", []byteRange{{0, 19}}, noRangeError},
+		{"bytes=50-59, 0-9, 55-69", []byteRange{{50, 69}, {0, 9}}, noRangeError},
+		{"bytes=10-19, 30-39, 15-34", []byteRange{{10, 39}}, noRangeError},
+
+		{"bytes=100-", nil, RangeNotSatisfiable},
+		{"bytes=50-10", nil, RangeNotSatisfiable},
+
+		{"bytes=", nil, RangeMalformed},
+		{"bytes=,", nil, RangeMalformed},
+		{"bytes=-", nil, RangeMalformed},
+		{"bytes=5", nil, RangeMalformed},
+		{"bytes=a-9", nil, RangeMalformed},
+		{"bytes=0-9a", nil, RangeMalformed},
+		{"bytes=+0-9", nil, RangeMalformed},
+		{"bytes=0-+9", nil, RangeMalformed},
+		{"bytes=--9", nil, RangeMalformed},
+		{"bytes=0-1-2", nil, RangeMalformed},
+		{"bytes=0 -9", nil, RangeMalformed},
+		{"bytes=0-9, x", nil, RangeMalformed},
+		{"bytes=bytes=0-9", nil, RangeMalformed},
+		{"bytes=99999999999999999999-", nil, RangeMalformed},
+
+		{"bytes=0-,0-", nil, RangeExcessive},
+		{"bytes=-60,-60", nil, RangeExcessive},
+		{"bytes=" + strings.Repeat("0-0,", maxRanges), nil, RangeExcessive},
+
+		{"items=0-9", nil, RangeUnsupportedUnit},
+		{"ytes=0-9", nil, RangeUnsupportedUnit},
+		{"bytes 0-9", nil, RangeUnsupportedUnit},
+		{"0-9", nil, RangeUnsupportedUnit},
+	}
+	for _, kase := range kases {
+		ranges, err := parseRangeHeader(kase.rng, 100)
+		if kase.kind == noRangeError {
+			assert.NoError(t, err, kase.rng)
+		} else {
+			assert.Equal(t, RangeError{Kind: kase.kind, Range: kase.rng}, err, kase.rng)
+		}
+		assert.Equal(t, kase.expected, ranges, kase.rng)
+	}
+}
+
+func TestParseRangeHeaderEmpty(t *testing.T) {
+	// no range of empty content is satisfiable, ServeData doesn't even try
+	for _, rng := range []string{"bytes=0-", "bytes=0-0", "bytes=-1"} {
+		ranges, err := parseRangeHeader(rng, 0)
+		assert.Equal(t, RangeError{Kind: RangeNotSatisfiable, Range: rng}, err, rng)
+		assert.Empty(t, ranges, rng)
+	}
+}
+
+func TestRangeErrorStatusCode(t *testing.T) {
+	kases := map[RangeErrorKind]int{
+		RangeMalformed:       http.StatusBadRequest,
+		RangeUnsupportedUnit: http.StatusBadRequest,
+		RangeNotSatisfiable:  http.StatusRequestedRangeNotSatisfiable,
+		RangeExcessive:       http.StatusBadRequest,
+	}
+	for kind, status := range kases {
+		err := RangeError{Kind: kind, Range: "bytes=0-9"}
+		assert.True(t, IsRangeError(err))
+		assert.Equal(t, status, err.StatusCode(), err.Error())
+	}
+	assert.False(t, IsRangeError(io.EOF))
+
+	_, err := parseRangeHeader("bytes=0-x", 100)
+	assert.EqualError(t, err, "invalid range: bytes=0-x")
+	_, err = parseRangeHeader("items=0-9", 100)
+	assert.EqualError(t, err, "unsupported range unit: items=0-9")
+	_, err = parseRangeHeader("bytes=100-", 100)
+	assert.EqualError(t, err, "range not satisfiable: bytes=100-")
+}
+
+// rangeReader reads parts of its content on their own like an object storage honouring ranged requests
+type rangeReader struct {
+	io.Reader
+	content []byte
+	read    [][2]int64
+}
+
+func (r *rangeReader) ReadRange(offset, length int64) (io.ReadCloser, error) {
+	r.read = append(r.read, [2]int64{offset, length})
+	return io.NopCloser(bytes.NewReader(r.content[offset : offset+length])), nil
+}
+
+func TestServeDataRangeReader(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	serve := func(rng string) (*rangeReader, *httptest.ResponseRecorder) {
+		ctx, recorder := mockServeDataContext(t, rng)
+		reader := &rangeReader{Reader: readerOnly{bytes.NewReader(content)}, content: content}
+		assert.NoError(t, ServeData(ctx, "file.bin", int64(len(content)), reader))
+		return reader, recorder
+	}
+
+	reader, recorder := serve("bytes=500-509")
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, content[500:510], recorder.Body.Bytes())
+	assert.Equal(t, [][2]int64{{500, 10}}, reader.read)
+
+	reader, recorder = serve("bytes=0-1,900-")
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, [][2]int64{{0, 2}, {900, 100}}, reader.read)
+	assert.Contains(t, recorder.Body.String(), string(content[900:]))
+
+	reader, recorder = serve("")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "bytes", recorder.Header().Get("Accept-Ranges"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+	assert.Empty(t, reader.read)
+}
+
+// seekReader is an io.ReadSeeker which isn't an io.ReaderAt, it records where it has been seeked to
+type seekReader struct {
+	reader *bytes.Reader
+	seeked []int64
+}
+
+func (r *seekReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func (r *seekReader) Seek(offset int64, whence int) (int64, error) {
+	r.seeked = append(r.seeked, offset)
+	return r.reader.Seek(offset, whence)
+}
+
+func TestServeDataSeeker(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	serve := func(rng string) (*seekReader, *httptest.ResponseRecorder) {
+		ctx, recorder := mockServeDataContext(t, rng)
+		reader := &seekReader{reader: bytes.NewReader(content)}
+		assert.NoError(t, ServeData(ctx, "file.bin", int64(len(content)), reader))
+		return reader, recorder
+	}
+
+	reader, recorder := serve("bytes=500-509")
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, "bytes 500-509/1000", recorder.Header().Get("Content-Range"))
+	assert.Equal(t, content[500:510], recorder.Body.Bytes())
+	assert.Equal(t, []int64{500}, reader.seeked)
+
+	// the start of the content has already been read to sniff its type
+	reader, recorder = serve("bytes=0-1,900-")
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, []int64{0, 900}, reader.seeked)
+	assert.Contains(t, recorder.Body.String(), "\r\n\r\n01\r\n")
+	assert.Contains(t, recorder.Body.String(), string(content[900:]))
+
+	reader, recorder = serve("")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "bytes", recorder.Header().Get("Accept-Ranges"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+	assert.Empty(t, reader.seeked)
+}
@@ -0,0 +1,193 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/charset"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/highlight"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/markup"
+	"code.gitea.io/gitea/modules/markup/ipynb"
+	"code.gitea.io/gitea/modules/markup/markdown"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/typesniffer"
+	"code.gitea.io/gitea/modules/util"
+)
+
+// isRenderRequested returns whether the rendered representation of the content is requested, either with ?render
+// or by preferring text/html to text/plain in the Accept header. The query parameter takes precedence.
+// Clients which accept anything, as browsers do, get the raw content unless they ask for ?render.
+func isRenderRequested(ctx *context.Context) bool {
+	if hasRenderParam(ctx) {
+		return ctx.FormBool("render") || isMarkdownRequested(ctx) || isHighlightRequested(ctx)
+	}
+
+	html, plain, wildcard := 0.0, 0.0, false
+	for _, part := range strings.Split(ctx.Req.Header.Get("Accept"), ",") {
+		mediaType, q := parseQualityValue(part)
+		switch mediaType {
+		case "text/html":
+			html = q
+		case "text/plain":
+			plain = q
+		case "*/*", "text/*":
+			wildcard = wildcard || q > 0
+		}
+	}
+	return !wildcard && html > plain
+}
+
+// hasRenderParam returns whether the request decides about rendering with ?render instead of the Accept header
+func hasRenderParam(ctx *context.Context) bool {
+	_ = ctx.Req.FormValue("render") // parses the form if that has not happened yet
+	_, ok := ctx.Req.Form["render"]
+	return ok
+}
+
+// isMarkdownRequested returns whether any text is requested to be rendered as Markdown with ?render=markdown
+func isMarkdownRequested(ctx *context.Context) bool {
+	return strings.EqualFold(ctx.FormString("render"), markdown.MarkupName)
+}
+
+// highlightRenderName is the value of ?render requesting source code to be highlighted
+const highlightRenderName = "highlight"
+
+// isHighlightRequested returns whether source code is requested to be highlighted as HTML with ?render=highlight
+func isHighlightRequested(ctx *context.Context) bool {
+	return strings.EqualFold(ctx.FormString("render"), highlightRenderName)
+}
+
+// isNotebook returns whether the named file is a Jupyter notebook
+func isNotebook(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".ipynb")
+}
+
+// renderedMarkupType returns the type of markup the named file is rendered as to HTML if rendering is requested,
+// or an empty string if its rendered representation is still text
+func renderedMarkupType(ctx *context.Context, name string) string {
+	switch {
+	case isMarkdownRequested(ctx):
+		return markdown.MarkupName
+	case isNotebook(name):
+		return ipynb.MarkupName
+	case markdown.IsMarkdownFile(name):
+		return markdown.MarkupName
+	}
+	return ""
+}
+
+// serveRenderedMarkup serves content rendered from the given type of markup to sanitized HTML.
+// It returns false without writing anything if the content cannot be rendered.
+func serveRenderedMarkup(ctx *context.Context, name, markupType string, content []byte, opts ServeOptions) (bool, error) {
+	var buf bytes.Buffer
+	if err := markup.Render(&markup.RenderContext{Ctx: ctx, Type: markupType}, bytes.NewReader(content), &buf); err != nil {
+		log.Debug("ServeData: unable to render %s as %s: %v", name, markupType, err)
+		return false, nil
+	}
+	return true, serveRenderedHTML(ctx, name, buf.Bytes(), opts)
+}
+
+// serveHighlightedCode serves source code highlighted as sanitized HTML, the language of which is told by the name of
+// the file. It returns false without writing anything if the language isn't recognized or the content isn't text.
+func serveHighlightedCode(ctx *context.Context, name string, content []byte, opts ServeOptions) (bool, error) {
+	if !highlight.IsRecognized(name) || !typesniffer.DetectContentType(content).IsText() {
+		return false, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<style>")
+	buf.WriteString(highlight.Stylesheet())
+	buf.WriteString(`</style><pre class="chroma"><code>`)
+	// the highlighter passes on content it fails to tokenize as it is
+	buf.WriteString(markup.Sanitize(highlight.Code(name, "", string(charset.ToUTF8WithFallback(content)))))
+	buf.WriteString("</code></pre>")
+	return true, serveRenderedHTML(ctx, name, buf.Bytes(), opts)
+}
+
+// serveRenderedHTML serves HTML rendered from the content with the given name
+func serveRenderedHTML(ctx *context.Context, name string, html []byte, opts ServeOptions) error {
+	ctx.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ctx.Resp.Header().Set("Content-Length", strconv.Itoa(len(html)))
+	ctx.Resp.Header().Set("X-Content-Type-Options", "nosniff")
+	ctx.Resp.Header().Set("Content-Disposition", contentDisposition("inline", opts.filename(name)))
+	// the rendered HTML is sanitized, but it still must not be able to run scripts or load anything but the preloaded assets
+	preloaded := setPreloadLinks(ctx.Resp.Header())
+	ctx.Resp.Header().Set("Content-Security-Policy", renderedContentSecurityPolicy(preloaded))
+	setFrameAncestors(ctx.Resp.Header())
+	ctx.Resp.Header().Set("Cache-Control", opts.cacheControl(name, "text/html"))
+	ctx.Status(http.StatusOK)
+	if ctx.Req.Method == http.MethodHead {
+		return nil
+	}
+	_, err := ctx.Resp.Write(html)
+	return err
+}
+
+// setPreloadLinks adds a Link header asking HTTP/2 clients to preload each of the PRELOAD_RENDERED_ASSETS.
+// They are raw files next to the rendered one, so their URLs are relative to the URL of the rendered file.
+// Scripts never run in the sandboxed document, so they aren't preloaded. It returns the destinations preloaded.
+func setPreloadLinks(header http.Header) map[string]bool {
+	preloaded := map[string]bool{}
+	for _, asset := range setting.UI.PreloadRenderedAssets {
+		asset = strings.TrimSpace(asset)
+		if len(asset) == 0 {
+			continue
+		}
+		destination := preloadDestination(asset)
+		if destination == "script" {
+			continue
+		}
+		preloaded[destination] = true
+		link := "<" + util.PathEscapeSegments(asset) + ">; rel=preload; as=" + destination
+		if destination == "font" || destination == "fetch" {
+			// fonts and fetched resources are always requested in CORS mode
+			link += "; crossorigin"
+		}
+		header.Add("Link", link)
+	}
+	return preloaded
+}
+
+// renderedContentSecurityPolicy returns the Content-Security-Policy of rendered HTML, which may load nothing
+// from the origin of Gitea but assets of the preloaded destinations
+func renderedContentSecurityPolicy(preloaded map[string]bool) string {
+	policy := "default-src 'none'; style-src 'unsafe-inline'"
+	if preloaded["style"] {
+		policy += " 'self'"
+	}
+	policy += "; img-src data:"
+	if preloaded["image"] {
+		policy += " 'self'"
+	}
+	if preloaded["font"] {
+		policy += "; font-src 'self'"
+	}
+	if preloaded["fetch"] {
+		policy += "; connect-src 'self'"
+	}
+	return policy + "; sandbox"
+}
+
+// preloadDestination returns the kind of resource the named asset is preloaded as
+func preloadDestination(asset string) string {
+	switch strings.ToLower(path.Ext(asset)) {
+	case ".css":
+		return "style"
+	case ".js", ".mjs":
+		return "script"
+	case ".woff", ".woff2", ".ttf", ".otf", ".eot":
+		return "font"
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".svg", ".ico", ".bmp":
+		return "image"
+	}
+	return "fetch"
+}
@@ -5,31 +5,134 @@
 package common
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"code.gitea.io/gitea/modules/base"
 	"code.gitea.io/gitea/modules/charset"
 	"code.gitea.io/gitea/modules/context"
 	"code.gitea.io/gitea/modules/git"
 	"code.gitea.io/gitea/modules/httpcache"
+	"code.gitea.io/gitea/modules/imagemeta"
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/setting"
 	"code.gitea.io/gitea/modules/typesniffer"
 	"code.gitea.io/gitea/modules/util"
+
+	lru "github.com/hashicorp/golang-lru"
+	stdcharset "golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
 )
 
+// ServeBlobByPath download the git.Blob found at treePath in the commit, responding with
+// a 404 if there is no such path or it isn't a file. With ?list=1 directories are listed as JSON.
+func ServeBlobByPath(ctx *context.Context, commit *git.Commit, treePath string) error {
+	return ServeBlobByPathWithOptions(ctx, commit, treePath, ServeOptions{})
+}
+
+// ServeBlobByPathWithOptions download the git.Blob found at treePath in the commit using the given options
+func ServeBlobByPathWithOptions(ctx *context.Context, commit *git.Commit, treePath string, opts ServeOptions) error {
+	entry, err := commit.GetTreeEntryByPath(treePath)
+	if err != nil {
+		if git.IsErrNotExist(err) {
+			ctx.NotFound("GetTreeEntryByPath", nil)
+			return nil
+		}
+		return err
+	}
+	if entry.IsDir() || entry.IsSubModule() {
+		if entry.IsDir() && isListingRequested(ctx) {
+			return ServeDirectoryListing(ctx, commit, treePath)
+		}
+		ctx.NotFound("GetTreeEntryByPath", nil)
+		return nil
+	}
+	if entry.IsLink() {
+		return ServeSymlink(ctx, commit, treePath, entry, opts)
+	}
+	if opts.LastModified.IsZero() {
+		opts.LastModified = commit.Committer.When
+	}
+	return serveBlob(ctx, entry.Blob(), commit.ID.String(), treePath, opts)
+}
+
 // ServeBlob download a git.Blob
 func ServeBlob(ctx *context.Context, blob *git.Blob) error {
-	if httpcache.HandleGenericETagCache(ctx.Req, ctx.Resp, `"`+blob.ID.String()+`"`) {
+	return serveBlob(ctx, blob, ctx.Repo.CommitID, ctx.Repo.TreePath, ServeOptions{})
+}
+
+// ServeBlobWithOptions download a git.Blob using the given options
+func ServeBlobWithOptions(ctx *context.Context, blob *git.Blob, opts ServeOptions) error {
+	return serveBlob(ctx, blob, ctx.Repo.CommitID, ctx.Repo.TreePath, opts)
+}
+
+func serveBlob(ctx *context.Context, blob *git.Blob, commitID, name string, opts ServeOptions) error {
+	if opts.LastModified.IsZero() && ctx.Repo != nil && ctx.Repo.Commit != nil {
+		opts.LastModified = ctx.Repo.Commit.Committer.When
+	}
+	if len(opts.Filename) == 0 {
+		opts.Filename = BlobFilename(ctx, name)
+	}
+	opts.BlobID = blob.ID.String()
+
+	if HandleBlobETagCache(ctx, blob.ID.String(), blob.Size()) {
 		return nil
 	}
 
-	dataRc, err := blob.DataAsync()
+	// looking the attributes up takes several git commands, clients revalidating their copy don't need them
+	if opts.Text.IsNone() || len(opts.ContentType) == 0 || len(opts.ContentLanguage) == 0 {
+		attributes := blobAttributes(ctx, commitID, name)
+		if opts.Text.IsNone() {
+			opts.Text = textAttribute(attributes)
+		}
+		if len(opts.ContentType) == 0 {
+			// the declared type is sent as is, neither sniffing nor MIME_TYPE_MAPPING second-guess it
+			opts.ContentType = contentTypeAttribute(attributes)
+		}
+		if len(opts.ContentLanguage) == 0 {
+			opts.ContentLanguage = contentLanguageAttribute(attributes)
+		}
+	}
+
+	if setting.Repository.ServeContentDigest && len(opts.Digest) == 0 {
+		opts.DigestAlgorithm = wantedDigestAlgorithm(ctx)
+		algorithm := opts.DigestAlgorithm
+		opts.digest = func() []byte {
+			// HEAD requests don't read the content, not even for its digest
+			digest, err := blobDigest(ctx, blob, algorithm, ctx.Req.Method == http.MethodHead)
+			if err != nil {
+				// the content is still served, reading it will report the error if it persists
+				log.Warn("ServeBlob: unable to compute the digest of blob %s of %s: %v", blob.ID, name, err)
+			}
+			return digest
+		}
+	}
+
+	dataRc, err := openBlob(ctx, blob)
 	if err != nil {
+		if err == errBackendBusy {
+			log.Warn("ServeBlob: git did not deliver blob %s of %s within %ds", blob.ID, name, setting.Git.Timeout.Serve)
+			respondBackendBusy(ctx)
+			return nil
+		}
+		if git.IsErrNotExist(err) {
+			// the object is referenced but missing from a broken repository, there is nothing to serve
+			log.Warn("ServeBlob: blob %s of %s is missing: %v", blob.ID, name, err)
+			ctx.NotFound("DataAsync", nil)
+			return nil
+		}
+		log.Error("ServeBlob: unable to read blob %s of %s: %v", blob.ID, name, err)
 		return err
 	}
 	defer func() {
@@ -38,67 +141,553 @@ func ServeBlob(ctx *context.Context, blob *git.Blob) error {
 		}
 	}()
 
-	return ServeData(ctx, ctx.Repo.TreePath, blob.Size(), dataRc)
+	var reader io.Reader = dataRc
+	if opts.blobRanges {
+		reader = &sequentialReader{Reader: dataRc}
+	}
+	if err := ServeDataWithOptions(ctx, name, blob.Size(), reader, opts); err != nil {
+		return err
+	}
+	AuditDownload(ctx, blob.ID.String(), name, blob.Size())
+	return nil
+}
+
+// ServeBlobRange serves length bytes of the blob starting at start as partial content. The blob is
+// streamed up to the end of the slice, so that large blobs never have to be loaded into memory.
+func ServeBlobRange(ctx *context.Context, blob *git.Blob, start, length int64) error {
+	size := blob.Size()
+	if start < 0 || length <= 0 || start >= size {
+		ctx.Resp.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		ctx.Status(http.StatusRequestedRangeNotSatisfiable)
+		return nil
+	}
+	r := byteRange{start: start, end: start + length - 1}
+	if r.end > size-1 || r.end < start {
+		r.end = size - 1
+	}
+	if isDownloadTooLarge(size) && !setting.Service.MaxDownloadFileSizeAllowRanges {
+		respondDownloadTooLarge(ctx, size)
+		return nil
+	}
+
+	if HandleBlobETagCache(ctx, blob.ID.String(), blob.Size()) {
+		return nil
+	}
+	if wait := reserveDownload(ctx); wait > 0 {
+		respondRateLimited(ctx, wait)
+		return nil
+	}
+
+	dataRc, err := openBlob(ctx, blob)
+	if err != nil {
+		if err == errBackendBusy {
+			log.Warn("ServeBlobRange: git did not deliver blob %s within %ds", blob.ID, setting.Git.Timeout.Serve)
+			respondBackendBusy(ctx)
+			return nil
+		}
+		if git.IsErrNotExist(err) {
+			log.Warn("ServeBlobRange: blob %s is missing: %v", blob.ID, err)
+			ctx.NotFound("DataAsync", nil)
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		if err = dataRc.Close(); err != nil {
+			log.Error("ServeBlobRange: Close: %v", err)
+		}
+	}()
+
+	// the type is detected from the start of the blob, not from the slice
+	buf := make([]byte, setting.UI.SniffSampleSize)
+	n, err := util.ReadAtMost(dataRc, buf)
+	if err != nil {
+		return err
+	}
+	buf = buf[:n]
+
+	name := path.Base(ctx.Repo.TreePath)
+	mimeType := lookupMimeType(ctx, name)
+	if mimeType == "" {
+		mimeType = typesniffer.DetectContentType(buf).GetMimeType()
+	}
+	// a slice of a file cannot be displayed on its own
+	ctx.Resp.Header().Set("Content-Type", mimeType)
+	ctx.Resp.Header().Set("X-Content-Type-Options", "nosniff")
+	ctx.Resp.Header().Set("Content-Disposition", contentDisposition("attachment", BlobFilename(ctx, ctx.Repo.TreePath)))
+	ctx.Resp.Header().Set("Cache-Control", cacheControlDirective(name, mimeType, false))
+	ctx.Resp.Header().Set("Content-Length", strconv.FormatInt(r.length(), 10))
+	ctx.Resp.Header().Set("Content-Range", r.contentRange(size))
+	ctx.Status(http.StatusPartialContent)
+	if ctx.Req.Method == http.MethodHead {
+		countDownload(ctx, name, 0)
+		return nil
+	}
+
+	written := ctx.Resp.Size()
+	w := newResponseWriter(ctx)
+	// skip forward over the sample and the rest of the blob before the slice
+	err = serveRange(w, io.MultiReader(bytes.NewReader(buf), dataRc), 0, r)
+	written = ctx.Resp.Size() - written
+	countDownload(ctx, name, written)
+	chargeDownload(ctx, written)
+	if err != nil {
+		return err
+	}
+	AuditDownload(ctx, blob.ID.String(), ctx.Repo.TreePath, size)
+	return nil
+}
+
+// errBackendBusy is returned by openBlob if git does not start to deliver a blob in time
+var errBackendBusy = errors.New("git backend is busy")
+
+// blobDataAsync opens the content of a blob, tests replace it to simulate a busy git backend
+var blobDataAsync = (*git.Blob).DataAsync
+
+// openBlob opens the content of the blob like DataAsync, but gives up with errBackendBusy if git hasn't
+// started to deliver it within the SERVE timeout of [git.timeout], e.g. because all its processes are busy
+func openBlob(ctx *context.Context, blob *git.Blob) (io.ReadCloser, error) {
+	timeout := time.Duration(setting.Git.Timeout.Serve) * time.Second
+	if timeout <= 0 {
+		return blobDataAsync(blob)
+	}
+
+	type result struct {
+		rc  io.ReadCloser
+		err error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		rc, err := blobDataAsync(blob)
+		opened <- result{rc, err}
+	}()
+
+	var err error
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-opened:
+		return res.rc, res.err
+	case <-timer.C:
+		err = errBackendBusy
+	case <-ctx.Req.Context().Done():
+		err = ctx.Req.Context().Err()
+	}
+	// the blob may still be opened later on, the reader has to be closed to release the git process then
+	go func() {
+		if res := <-opened; res.err == nil {
+			if err := res.rc.Close(); err != nil {
+				log.Error("openBlob: Close: %v", err)
+			}
+		}
+	}()
+	return nil, err
+}
+
+// respondBackendBusy responds 503 Service Unavailable, asking the client to retry once the git backend may have recovered
+func respondBackendBusy(ctx *context.Context) {
+	ctx.Resp.Header().Set("Retry-After", strconv.Itoa(setting.Git.Timeout.Serve))
+	ctx.Error(http.StatusServiceUnavailable)
+}
+
+// isDownloadTooLarge returns whether content of the given size exceeds [service] MAX_DOWNLOAD_FILE_SIZE
+func isDownloadTooLarge(size int64) bool {
+	return setting.Service.MaxDownloadFileSize > 0 && size > setting.Service.MaxDownloadFileSize
+}
+
+// respondDownloadTooLarge responds 413 Payload Too Large to the download of content of the given size
+func respondDownloadTooLarge(ctx *context.Context, size int64) {
+	log.Debug("ServeData: refusing to serve %s of %d bytes, which exceeds MAX_DOWNLOAD_FILE_SIZE", ctx.Req.URL.Path, size)
+	ctx.Error(http.StatusRequestEntityTooLarge, fmt.Sprintf("The file is %d bytes large, files larger than %d bytes cannot be downloaded", size, setting.Service.MaxDownloadFileSize))
+}
+
+// mimeTypeAttribute is the gitattribute declaring the MIME type a file is served with, e.g. gitea-mime-type=application/json
+const mimeTypeAttribute = "gitea-mime-type"
+
+// blobAttributesCacheSize is the number of files whose gitattributes are cached
+const blobAttributesCacheSize = 1000
+
+// blobAttributesCache holds the gitattributes of files by blobAttributesKey
+var blobAttributesCache, _ = lru.New(blobAttributesCacheSize)
+
+// blobAttributesKey identifies a file in a commit, the gitattributes of a commit can never change
+type blobAttributesKey struct {
+	repoID   int64
+	commitID string
+	treePath string
+}
+
+// blobAttributes returns the gitattributes of the repository which affect how the file at treePath in the commit is served
+func blobAttributes(ctx *context.Context, commitID, treePath string) map[string]string {
+	if ctx.Repo == nil || ctx.Repo.GitRepo == nil || len(commitID) == 0 || len(treePath) == 0 {
+		return nil
+	}
+	var repoID int64
+	if ctx.Repo.Repository != nil {
+		repoID = ctx.Repo.Repository.ID
+	}
+	key := blobAttributesKey{repoID: repoID, commitID: commitID, treePath: treePath}
+	if cached, ok := blobAttributesCache.Get(key); ok {
+		return cached.(map[string]string)
+	}
+	attributes := checkBlobAttributes(ctx, commitID, treePath)
+	if attributes != nil {
+		blobAttributesCache.Add(key, attributes)
+	}
+	return attributes
+}
+
+// checkBlobAttributes runs git check-attr for the file at treePath in the commit, which requires its tree
+// to be read into a temporary index
+func checkBlobAttributes(ctx *context.Context, commitID, treePath string) map[string]string {
+
+	indexFilename, worktree, deleteTemporaryFile, err := ctx.Repo.GitRepo.ReadTreeToTemporaryIndex(commitID)
+	if err != nil {
+		log.Error("Unable to read tree %s of %-v to a temporary index. Error: %v", commitID, ctx.Repo.Repository, err)
+		return nil
+	}
+	defer deleteTemporaryFile()
+
+	filename2attribute2info, err := ctx.Repo.GitRepo.CheckAttribute(git.CheckAttributeOpts{
+		CachedOnly: true,
+		Attributes: []string{"text", "binary", mimeTypeAttribute, languageAttribute},
+		Filenames:  []string{treePath},
+		IndexFile:  indexFilename,
+		WorkTree:   worktree,
+	})
+	if err != nil {
+		log.Error("Unable to load attributes for %-v:%s. Error: %v", ctx.Repo.Repository, treePath, err)
+		return nil
+	}
+	return filename2attribute2info[treePath]
+}
+
+// textAttribute returns whether a file has been explicitly marked as text or binary by its gitattributes
+func textAttribute(attributes map[string]string) util.OptionalBool {
+	if attributes["binary"] == "set" || attributes["text"] == "unset" {
+		return util.OptionalBoolFalse
+	} else if attributes["text"] == "set" {
+		return util.OptionalBoolTrue
+	}
+	return util.OptionalBoolNone
+}
+
+// contentTypeAttribute returns the MIME type a file has been declared as by its gitattributes, or an empty string
+func contentTypeAttribute(attributes map[string]string) string {
+	contentType := attributes[mimeTypeAttribute]
+	switch contentType {
+	case "", "set", "unset", "unspecified":
+		return ""
+	}
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		log.Warn("Ignoring invalid %s attribute %q: %v", mimeTypeAttribute, contentType, err)
+		return ""
+	}
+	if typesniffer.IsScriptableMimeType(contentType) {
+		// anyone who can push must not be able to run scripts on the origin of Gitea
+		log.Warn("Ignoring %s attribute %q, content of that type may run scripts", mimeTypeAttribute, contentType)
+		return ""
+	}
+	return contentType
+}
+
+// BlobETag returns the ETag of content identified by the given object id.
+// ?render may turn binary content into text, ?charset=utf-8 may transcode text
+// and ?thumb may scale images down, so these representations get ETags of their own.
+// Rendered, transcoded and scaled representations aren't produced byte for byte the same, so their ETags are weak.
+func BlobETag(ctx *context.Context, id string) string {
+	etag := id
+	if isHighlightRequested(ctx) {
+		etag += "-highlight"
+	} else if isRenderRequested(ctx) {
+		etag += "-render"
+	}
+	if isTranscodeRequested(ctx) {
+		etag += "-utf-8"
+	}
+	width, height, thumbnail := thumbnailSize(ctx)
+	if thumbnail {
+		etag += fmt.Sprintf("-thumb-%dx%d", width, height)
+	}
+	if isRenderRequested(ctx) || isTranscodeRequested(ctx) || thumbnail {
+		return httpcache.WeakETag(`"` + etag + `"`)
+	}
+	return `"` + etag + `"`
+}
+
+// HandleBlobETagCache sends the ETag of content of the given size identified by id and responds with 304 Not Modified
+// if the client has it already, see httpcache.HandleGenericETagCache. Content larger than MAX_ETAG_FILE_SIZE
+// gets no ETag at all, so that proxies don't revalidate it over and over again.
+func HandleBlobETagCache(ctx *context.Context, id string, size int64) bool {
+	if setting.Repository.MaxETagFileSize > 0 && size > setting.Repository.MaxETagFileSize {
+		// an LFS pointer may have been given the ETag of its blob before the size of the object was known
+		ctx.Resp.Header().Del("Etag")
+		return false
+	}
+	if httpcache.HandleGenericETagCache(ctx.Req, ctx.Resp, BlobETag(ctx, id)) {
+		countDownload(ctx, ctx.Req.URL.Path, 0)
+		return true
+	}
+	return false
+}
+
+// isTranscodeRequested returns whether text is requested to be transcoded to UTF-8 with ?charset=utf-8 or ?transcode=1
+func isTranscodeRequested(ctx *context.Context) bool {
+	return strings.EqualFold(ctx.FormString("charset"), "utf-8") || ctx.FormBool("transcode")
+}
+
+// ServeOptions contains the optional behaviours of ServeData
+type ServeOptions struct {
+	// Immutable marks content which can never change, e.g. because it is addressed by its hash
+	Immutable bool
+	// LastModified is sent as Last-Modified and checked against If-Modified-Since if it is set
+	LastModified time.Time
+	// Text overrides whether the content is detected as text or binary unless it is OptionalBoolNone
+	Text util.OptionalBool
+	// ContentType is sent verbatim instead of the sniffed or mapped type of the content if it is set
+	ContentType string
+	// Digest is the digest of the full content, it is sent in the digest headers if it is set
+	Digest []byte
+	// DigestAlgorithm is the algorithm Digest has been computed with, "sha-256" if it is empty
+	DigestAlgorithm string
+	// blobRanges marks a blob whose ranges may be served in a single pass over it
+	blobRanges bool
+	// digest computes Digest if it isn't set, it is only called once the content is known to be sent in full
+	// or in part, so that refused downloads don't read the content just for its digest. HEAD requests only get
+	// a digest which has been computed before.
+	digest func() []byte
+	// Filename is the name the content is saved as instead of the base name of the served name if it is set
+	Filename string
+	// BlobID is the id of the blob the content is read from if it is set, what is detected from it is cached by it
+	BlobID string
+	// ContentLanguage is the BCP 47 tag of the language of text content, which is otherwise told by a suffix
+	// of its name like README.zh-CN.md
+	ContentLanguage string
+	// NoCache marks sensitive content, e.g. private previews, which neither browsers nor proxies may store.
+	// The content is sent without ETag and Last-Modified, so it is never revalidated either.
+	NoCache bool
+}
+
+// fullDigest returns Digest, computing it if it isn't set
+func (opts ServeOptions) fullDigest() []byte {
+	if len(opts.Digest) == 0 && opts.digest != nil {
+		return opts.digest()
+	}
+	return opts.Digest
+}
+
+// filename returns the file name sent in the Content-Disposition header for content with the given name
+func (opts ServeOptions) filename(name string) string {
+	if len(opts.Filename) > 0 {
+		return path.Base(opts.Filename)
+	}
+	return path.Base(name)
+}
+
+// cacheControl returns the Cache-Control directive for the content with the given file name and MIME type
+func (opts ServeOptions) cacheControl(name, mimeType string) string {
+	if opts.NoCache {
+		return "no-store, private"
+	}
+	return cacheControlDirective(name, mimeType, opts.Immutable)
+}
+
+// BlobFilename returns the name the blob at treePath is saved as. It is the base name of treePath unless
+// DOWNLOAD_FILENAME_TEMPLATE is set, which may refer to {owner}, {repo}, {ref} and {basename}.
+func BlobFilename(ctx *context.Context, treePath string) string {
+	basename := path.Base(treePath)
+	template := setting.Repository.DownloadFilenameTemplate
+	if len(template) == 0 || ctx.Repo == nil || ctx.Repo.Repository == nil {
+		return basename
+	}
+
+	var ref string
+	switch {
+	case ctx.Repo.IsViewBranch:
+		ref = ctx.Repo.BranchName
+	case ctx.Repo.IsViewTag:
+		ref = ctx.Repo.TagName
+	default:
+		ref = base.ShortSha(ctx.Repo.CommitID)
+	}
+	// the name of a branch may contain slashes, but a file name must not
+	return strings.NewReplacer(
+		"{owner}", ctx.Repo.Repository.OwnerName,
+		"{repo}", ctx.Repo.Repository.Name,
+		"{ref}", strings.ReplaceAll(ref, "/", "-"),
+		"{basename}", basename,
+	).Replace(template)
 }
 
 // ServeData download file from io.Reader
 func ServeData(ctx *context.Context, name string, size int64, reader io.Reader) error {
+	return ServeDataWithType(ctx, name, size, "", reader)
+}
 
-	// Chrome Dev / 网络 / 节流模式
-	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Range
-	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Range_requests
-	if _, ok := reader.(io.ReaderAt); ok {
-		if rng := ctx.Req.Header.Get("Range"); len(rng) > 0 {
-			var start int
-			var end int
-			var err, err2 error
-			// Range: bytes=131072-
-			arr := strings.Split(strings.TrimLeft(rng, "bytes="), "-")
-			start, err = strconv.Atoi(arr[0])
-			if len(arr[1]) == 0 {
-				end = int(size - 1)
+// ServeDataWithType download file from io.Reader with a known content type, which is sniffed if empty
+func ServeDataWithType(ctx *context.Context, name string, size int64, contentType string, reader io.Reader) error {
+	return ServeDataWithOptions(ctx, name, size, reader, ServeOptions{ContentType: contentType})
+}
+
+// ServeDataWithOptions download file from io.Reader using the given options
+func ServeDataWithOptions(ctx *context.Context, name string, size int64, reader io.Reader, opts ServeOptions) error {
+	if wait := reserveDownload(ctx); wait > 0 {
+		respondRateLimited(ctx, wait)
+		return nil
+	}
+	written := ctx.Resp.Size()
+	err := serveData(ctx, name, size, reader, opts)
+	written = ctx.Resp.Size() - written
+	countDownload(ctx, name, written)
+	chargeDownload(ctx, written)
+	return err
+}
+
+func serveData(ctx *context.Context, name string, size int64, reader io.Reader, opts ServeOptions) error {
+	if opts.NoCache {
+		// the caller may have validated an ETag already, but the client must not keep the content to revalidate it
+		ctx.Resp.Header().Del("Etag")
+		opts.LastModified = time.Time{}
+	}
+	if !opts.LastModified.IsZero() {
+		ctx.Resp.Header().Set("Last-Modified", opts.LastModified.UTC().Format(http.TimeFormat))
+		if isNotModifiedSince(ctx.Req, opts.LastModified) {
+			ctx.Resp.Header().Set("Cache-Control", opts.cacheControl(name, ""))
+			ctx.Status(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	setRawFileCORSHeaders(ctx)
+
+	// ?download forces the content to be saved instead of being displayed, so it wins over ?render.
+	// So do the extensions of FORCE_ATTACHMENT_EXTENSIONS, whatever the content is sniffed as.
+	forceDownload := ctx.FormBool("download") || ctx.FormBool("attachment") || isForcedAttachment(name)
+
+	// ranges are checked once it's known whether they can be served
+	if isDownloadTooLarge(size) && !(setting.Service.MaxDownloadFileSizeAllowRanges && len(ctx.Req.Header.Get("Range")) > 0) {
+		respondDownloadTooLarge(ctx, size)
+		return nil
+	}
+
+	if !hasRenderParam(ctx) {
+		// without ?render the Accept header decides whether the content is rendered
+		ctx.Resp.Header().Add("Vary", "Accept")
+	}
+	render := isRenderRequested(ctx)
+	if render && setting.UI.MaxRenderFileSize > 0 && size > setting.UI.MaxRenderFileSize {
+		// detecting the charset of huge files and rendering them takes too long, they are served raw instead
+		ctx.Resp.Header().Set("X-Gitea-Render-Skipped", "too-large")
+		ctx.Resp.Header().Add("Access-Control-Expose-Headers", "X-Gitea-Render-Skipped")
+		render = false
+	}
+
+	if render && !forceDownload && size >= 0 && size <= setting.UI.MaxDisplayFileSize {
+		highlight := isHighlightRequested(ctx)
+		if markupType := renderedMarkupType(ctx, name); highlight || len(markupType) > 0 {
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			var served bool
+			if highlight {
+				served, err = serveHighlightedCode(ctx, name, content, opts)
 			} else {
-				end, err2 = strconv.Atoi(arr[1])
-				if int64(end) > size-1 {
-					end = int(size - 1)
+				served, err = serveRenderedMarkup(ctx, name, markupType, content, opts)
+			}
+			if served || err != nil {
+				return err
+			}
+			// content which cannot be rendered is served as it is
+			reader = bytes.NewReader(content)
+		}
+	}
+
+	if width, height, ok := thumbnailSize(ctx); ok && !forceDownload && size >= 0 && size <= setting.UI.Thumbnail.MaxSourceSize {
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		if serveThumbnail(ctx, name, content, width, height, opts) {
+			return nil
+		}
+		// content which isn't an image is served as it is
+		reader = bytes.NewReader(content)
+	}
+
+	if coding := precompressedCoding(name); len(coding) > 0 && !forceDownload {
+		if mimeType := precompressedMimeType(ctx, name); len(mimeType) > 0 {
+			compressed, r, err := isPrecompressed(reader, coding)
+			if err != nil {
+				return err
+			}
+			reader = r
+			if compressed {
+				if isDownloadTooLarge(size) {
+					// precompressed content is always served in full
+					respondDownloadTooLarge(ctx, size)
+					return nil
 				}
+				return servePrecompressed(ctx, name, size, reader, mimeType, coding, opts)
 			}
+			// a file which isn't compressed after all is served as it is
+		}
+	}
 
-			length := end - start + 1
-			if length <= 0 || nil != err || nil != err2 {
-				return fmt.Errorf("invalid range header: %s", rng)
-			}
-
-			log.Warn("%s start:%d end:%d len:%d", rng, start, end, length)
-
-			//ctx.Status(206)
-			//ctx.Resp.Header().Set("Content-Length", strconv.Itoa(length))
-			//ctx.Resp.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
-			//// todo use bytes.Reader
-			//if start > 0 {
-			//	num := start + 1
-			//	buf := make([]byte, 1024)
-			//	for i := 0; ; {
-			//		i += 1024
-			//		if i > num {
-			//			if _, err := reader.Read(buf[:num%1024]); err != nil {
-			//				return err
-			//			}
-			//			break
-			//		}
-			//		if _, err := reader.Read(buf); err != nil {
-			//			return err
-			//		}
-			//	}
-			//}
-			//_, err = io.CopyN(ctx.Resp, reader, int64(length))
-			//return err
-		} else {
+	// stripping reads the whole image, which a HEAD request doesn't need, it gets the size of the stored image
+	if setting.UI.StripImageMetadata && size >= 0 && size <= setting.UI.MaxDisplayFileSize && ctx.Req.Method != http.MethodHead {
+		var stripped bool
+		var err error
+		if reader, size, stripped, err = stripImageMetadata(reader, size); err != nil {
+			return err
+		} else if stripped {
+			// the digest is of the stored image, not of the stripped one
+			opts.Digest, opts.digest = nil, nil
+		}
+	}
+
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Range_requests
+	// ranges of content of unknown size cannot be validated, so it is always served in full.
+	// Empty content has no bytes a range could cover, its ranges are all unsatisfiable.
+	// Rendered and transcoded responses aren't the bytes of the content, so ranges of it don't apply to them.
+	var ranges []byteRange
+	if isRangeable(reader) && size >= 0 && !render && !isTranscodeRequested(ctx) {
+		// a HEAD request gets the headers of the full content
+		if rng := ctx.Req.Header.Get("Range"); len(rng) > 0 && ctx.Req.Method != http.MethodHead && isIfRangeValid(ctx) {
+			var err error
+			ranges, err = parseRangeHeader(rng, size)
+			if rangeErr, ok := err.(RangeError); ok && rangeErr.Kind == RangeNotSatisfiable {
+				ctx.Resp.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+				ctx.Status(rangeErr.StatusCode())
+				return nil
+			} else if err != nil {
+				// a Range header which cannot be understood is ignored and the full content is served
+				log.Trace("ServeData: %s ignoring range %s: %v", ctx.Req.URL.Path, rng, err)
+			}
+
+			for _, r := range ranges {
+				log.Trace("ServeData: %s range %s: start=%d end=%d len=%d size=%d", ctx.Req.URL.Path, rng, r.start, r.end, r.length(), size)
+			}
+		}
+		if len(ranges) == 0 {
 			ctx.Resp.Header().Set("Accept-Ranges", "bytes")
 		}
+	} else {
+		// tell download managers not to fetch parts of the content in parallel
+		ctx.Resp.Header().Set("Accept-Ranges", "none")
+	}
+	if len(ranges) == 0 && isDownloadTooLarge(size) {
+		// the Range header cannot be served, the whole content would be sent instead
+		respondDownloadTooLarge(ctx, size)
+		return nil
 	}
 
-	buf := make([]byte, 1024)
+	sampleSize := setting.UI.SniffSampleSize
+	if render && setting.UI.RenderSampleSize > sampleSize {
+		// the charset of rendered content may only become apparent further into it
+		sampleSize = setting.UI.RenderSampleSize
+	}
+	buf := make([]byte, sampleSize)
 	n, err := util.ReadAtMost(reader, buf)
 	if err != nil {
 		return err
@@ -106,57 +695,501 @@ func ServeData(ctx *context.Context, name string, size int64, reader io.Reader)
 	if n >= 0 {
 		buf = buf[:n]
 	}
+	if len(buf) == sampleSize && sampleSize < typesniffer.ZipSniffLen && bytes.HasPrefix(buf, typesniffer.ZipMagic) {
+		// office documents and plain ZIP archives look the same at their start
+		more := make([]byte, typesniffer.ZipSniffLen-sampleSize)
+		n, err := util.ReadAtMost(reader, more)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, more[:n]...)
+	}
+	if size >= 0 && len(ranges) == 0 {
+		// the sample and the rest of the stream together make up exactly size bytes, however the reader
+		// splits them up, a stream longer than declared is cut off and a shorter one fails the response
+		if int64(len(buf)) > size {
+			buf = buf[:size]
+		}
+		reader = &sizedReader{r: reader, remaining: size - int64(len(buf))}
+	}
 
-	ctx.Resp.Header().Set("Cache-Control", "public,max-age=86400")
-
-	if size >= 0 {
+	switch {
+	case len(ranges) == 1:
+		ctx.Resp.Header().Set("Content-Length", strconv.FormatInt(ranges[0].length(), 10))
+		ctx.Resp.Header().Set("Content-Range", ranges[0].contentRange(size))
+	case len(ranges) > 1:
+		// Content-Length of a multipart/byteranges response is set by serveMultipartRanges
+	case size >= 0:
 		ctx.Resp.Header().Set("Content-Length", fmt.Sprintf("%d", size))
-	} else {
-		log.Error("ServeData called to serve data: %s with size < 0: %d", name, size)
+	default:
+		// without a Content-Length the response is sent with chunked transfer encoding
+		log.Trace("ServeData: %s has unknown size, sending it chunked", name)
+		ctx.Resp.Header().Del("Content-Length")
 	}
+	filename := opts.filename(name)
 	name = path.Base(name)
 
-	// Google Chrome dislike commas in filenames, so let's change it to a space
-	name = strings.ReplaceAll(name, ",", " ")
-
-	st := typesniffer.DetectContentType(buf)
-
+	var st typesniffer.SniffedType
 	mappedMimeType := ""
-	if setting.MimeTypeMap.Enabled {
-		fileExtension := strings.ToLower(filepath.Ext(name))
-		mappedMimeType = setting.MimeTypeMap.Map[fileExtension]
+	if len(opts.ContentType) > 0 {
+		st = typesniffer.NewSniffedType(opts.ContentType)
+	} else {
+		st = typesniffer.DetectContentType(buf)
+		mappedMimeType = lookupMimeType(ctx, name)
 	}
-	if st.IsText() || ctx.FormBool("render") {
-		cs, err := charset.DetectEncoding(buf)
-		if err != nil {
-			log.Error("Detect raw file %s charset failed: %v, using by default utf-8", name, err)
-			cs = "utf-8"
+	isText := st.IsText()
+	if !opts.Text.IsNone() {
+		isText = opts.Text.IsTrue()
+	}
+	rendered := !isText && render && !forceDownload
+	// never let browsers second-guess the type of user content, it might turn into something executable
+	ctx.Resp.Header().Set("X-Content-Type-Options", "nosniff")
+	bomStripped := false
+	var transcoding encoding.Encoding
+	if isText || rendered {
+		// a byte-order marker settles the charset, no need to guess
+		cs, bomLen := charset.DetectBOM(buf)
+		if bomLen == 0 {
+			var confidence int
+			cs, confidence, err = detectContentEncoding(opts.BlobID, buf)
+			if err != nil {
+				log.Error("Detect raw file %s charset failed: %v, using by default %s", name, err, setting.Repository.DefaultCharset)
+				cs = setting.Repository.DefaultCharset
+			} else if confidence < setting.Repository.MinCharsetConfidence {
+				// a wild guess garbles the text more than the default charset would
+				log.Warn("Detected charset %s of raw file %s has a low confidence of %d, using by default %s", cs, name, confidence, setting.Repository.DefaultCharset)
+				cs = setting.Repository.DefaultCharset
+			}
+		} else if len(ranges) == 0 && ctx.FormBool("strip_bom") {
+			buf = buf[bomLen:]
+			bomStripped = true
+			if size >= 0 {
+				ctx.Resp.Header().Set("Content-Length", strconv.FormatInt(size-int64(bomLen), 10))
+			}
 		}
-		if mappedMimeType == "" {
+		if len(ranges) == 0 && !strings.EqualFold(cs, "UTF-8") && isTranscodeRequested(ctx) {
+			// the content is transcoded while it is streamed, so its length is unknown in advance
+			if transcoding, _ = stdcharset.Lookup(cs); transcoding != nil {
+				cs = "UTF-8"
+				ctx.Resp.Header().Del("Content-Length")
+			} else {
+				log.Debug("ServeData: %s cannot be transcoded from unknown charset %s", name, cs)
+			}
+		}
+		if mappedMimeType == "" && st.IsPostScript() {
+			mappedMimeType = typesniffer.PostScriptMimeType
+		} else if mappedMimeType == "" {
 			mappedMimeType = "text/plain"
 		}
+		if forceDownload || st.IsPostScript() || setting.UI.TextContentDisposition == "attachment" {
+			// a PostScript document is a program, viewers must not run it just because its link is followed
+			ctx.Resp.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
+			ctx.Resp.Header().Set("Content-Disposition", contentDisposition("attachment", filename))
+		} else if setting.UI.AllowRawHTMLPreview && st.IsHTML() {
+			// like SVG images, HTML documents may be displayed as long as they cannot run scripts
+			mappedMimeType = "text/html"
+			ctx.Resp.Header().Set("Content-Disposition", contentDisposition("inline", filename))
+			ctx.Resp.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox")
+		} else {
+			ctx.Resp.Header().Set("Content-Disposition", contentDisposition("inline", filename))
+		}
 		ctx.Resp.Header().Set("Content-Type", mappedMimeType+"; charset="+strings.ToLower(cs))
 	} else {
 		ctx.Resp.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
-		if mappedMimeType != "" {
-			ctx.Resp.Header().Set("Content-Type", mappedMimeType)
-		}
-		if (st.IsImage() || st.IsPDF()) && (setting.UI.SVG.Enabled || !st.IsSvgImage()) {
-			ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, name))
-			if st.IsSvgImage() {
-				ctx.Resp.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
-				ctx.Resp.Header().Set("X-Content-Type-Options", "nosniff")
+		if mappedMimeType == "" {
+			// browsers must not guess the type of the content
+			mappedMimeType = st.GetMimeType()
+		}
+		ctx.Resp.Header().Set("Content-Type", mappedMimeType)
+		// a database can't be displayed whatever InlineContentTypes says, it has to be saved under its name.
+		// Few browsers can display HEIC images, which are only shown if that is enabled explicitly.
+		inline := !forceDownload && !st.IsDatabase() && isInlineContentType(st.GetMimeType()) && (setting.UI.SVG.Enabled || !st.IsSvgImage()) &&
+			(setting.UI.InlineHEIC || !st.IsHEIC())
+		if maxSize := maxInlineMediaSize(st); inline && maxSize > 0 && (size < 0 || size > maxSize) {
+			// browsers may hang trying to display huge images and documents
+			inline = false
+		}
+		if inline && setting.UI.SaveDataInlineMaxSize > 0 && (st.IsImage() || st.IsPDF() || st.IsAudio() || st.IsVideo()) {
+			ctx.Resp.Header().Add("Vary", "Save-Data")
+			// clients on metered connections shouldn't fetch large media just because it is displayed automatically
+			if (size < 0 || size > setting.UI.SaveDataInlineMaxSize) && isSaveData(ctx.Req) {
+				inline = false
+			}
+		}
+		if inline {
+			ctx.Resp.Header().Set("Content-Disposition", contentDisposition("inline", filename))
+			switch {
+			case st.IsWasm():
+				// streaming compilation requires the exact MIME type
+				ctx.Resp.Header().Set("Content-Type", typesniffer.WasmMimeType)
+			case st.IsFont():
+				// fonts referenced by pages on other sites are only loaded if CORS allows it
+				if len(setting.Repository.AccessControlAllowOrigin) > 0 && len(ctx.Resp.Header().Get("Access-Control-Allow-Origin")) == 0 {
+					ctx.Resp.Header().Set("Access-Control-Allow-Origin", setting.Repository.AccessControlAllowOrigin)
+				}
+			case st.IsSvgImage():
+				ctx.Resp.Header().Set("Content-Security-Policy", setting.UI.SVG.ContentSecurityPolicy)
 				ctx.Resp.Header().Set("Content-Type", typesniffer.SvgMimeType)
 			}
 		} else {
-			ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+			ctx.Resp.Header().Set("Content-Disposition", contentDisposition("attachment", filename))
 		}
 	}
 
-	_, err = ctx.Resp.Write(buf)
-	if err != nil {
-		return err
+	if strings.HasPrefix(ctx.Resp.Header().Get("Content-Disposition"), "inline") {
+		setFrameAncestors(ctx.Resp.Header())
+	}
+
+	if len(opts.ContentType) > 0 && !rendered {
+		ctx.Resp.Header().Set("Content-Type", opts.ContentType)
+	}
+
+	mimeType := strings.SplitN(ctx.Resp.Header().Get("Content-Type"), ";", 2)[0]
+	if len(mimeType) == 0 {
+		mimeType = st.GetMimeType()
+	}
+	ctx.Resp.Header().Set("Cache-Control", opts.cacheControl(name, mimeType))
+
+	if isText || rendered {
+		contentLanguage := opts.ContentLanguage
+		if len(contentLanguage) == 0 {
+			contentLanguage = filenameLanguage(name)
+		}
+		if len(contentLanguage) > 0 {
+			ctx.Resp.Header().Set("Content-Language", contentLanguage)
+		}
 	}
-	_, err = io.Copy(ctx.Resp, reader)
+
+	compress := setting.UI.CompressServedContent && isText
+	if compress {
+		// caches must not hand a compressed representation to clients which did not ask for it
+		ctx.Resp.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	w := newResponseWriter(ctx)
+
+	coding := ""
+	if compress && len(ranges) == 0 {
+		coding = negotiateContentEncoding(ctx.Req.Header.Get("Accept-Encoding"))
+	}
+	// the digest is of the content as it is stored, it doesn't match an encoded or altered representation
+	if len(coding) == 0 && !bomStripped && transcoding == nil {
+		if digest := opts.fullDigest(); len(digest) > 0 {
+			setDigestHeaders(ctx.Resp.Header(), opts.DigestAlgorithm, digest, len(ranges) == 0)
+		}
+	}
+
+	consumed := int64(len(buf))
+	if sr, ok := reader.(*sequentialReader); ok && len(ranges) > 0 {
+		// the content is read only once, the sample included, so the ranges have to come in its order
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+		reader, consumed = io.MultiReader(bytes.NewReader(buf), sr.Reader), 0
+	}
+	if len(ranges) == 1 {
+		ctx.Status(http.StatusPartialContent)
+		return serveRange(w, reader, consumed, ranges[0])
+	} else if len(ranges) > 1 {
+		return serveMultipartRanges(ctx, w, reader, consumed, ranges, size)
+	}
+
+	if len(coding) > 0 {
+		ctx.Resp.Header().Set("Content-Encoding", coding)
+		// the compressed bytes depend on the compressor, only what they decompress to stays the same
+		if etag := ctx.Resp.Header().Get("Etag"); len(etag) > 0 {
+			ctx.Resp.Header().Set("Etag", httpcache.WeakETag(etag))
+		}
+		if length := ctx.Resp.Header().Get("Content-Length"); len(length) > 0 {
+			// the size of the compressed content isn't known in advance, but clients may still show the progress
+			ctx.Resp.Header().Set("X-Uncompressed-Content-Length", length)
+			ctx.Resp.Header().Add("Access-Control-Expose-Headers", "X-Uncompressed-Content-Length")
+		}
+		ctx.Resp.Header().Del("Content-Length")
+	}
+
+	if ctx.Req.Method == http.MethodHead {
+		// all headers are set, there is no need to read the rest of the content
+		ctx.Status(http.StatusOK)
+		return nil
+	}
+
+	if len(coding) > 0 {
+		cw := newCompressWriter(w, coding)
+		defer func() {
+			if err := cw.Close(); err != nil {
+				log.Error("ServeData: Close: %v", err)
+			}
+		}()
+		w = cw
+	}
+
+	// the sample has already been read from the reader, it is sent first and only once
+	body := io.MultiReader(bytes.NewReader(buf), reader)
+	if transcoding != nil {
+		body = transform.NewReader(body, transcoding.NewDecoder())
+	}
+	_, err = io.Copy(w, body)
 	return err
 }
+
+// sizedReader reads exactly remaining bytes from r. Nothing beyond them is read, and if r ends before all of
+// them have been read the read fails with io.ErrUnexpectedEOF rather than silently truncating the response.
+type sizedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (s *sizedReader) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// stripImageMetadata returns a reader of the content of reader with its metadata removed and the new size
+// if the content is a JPEG, PNG or WebP image, otherwise it returns a reader of the content as it is
+func stripImageMetadata(reader io.Reader, size int64) (io.Reader, int64, bool, error) {
+	sample := make([]byte, 512)
+	if size < int64(len(sample)) {
+		sample = sample[:size]
+	}
+	var n int
+	var err error
+	// a reader which can serve ranges is left as it is, so that it still can
+	switch rd := reader.(type) {
+	case io.ReaderAt:
+		if n, err = rd.ReadAt(sample, 0); err != nil && err != io.EOF {
+			return nil, 0, false, err
+		}
+	case RangeReader:
+		rc, err := rd.ReadRange(0, int64(len(sample)))
+		if err != nil {
+			return nil, 0, false, err
+		}
+		n, err = util.ReadAtMost(rc, sample)
+		rc.Close()
+		if err != nil {
+			return nil, 0, false, err
+		}
+	case io.Seeker:
+		if n, err = util.ReadAtMost(reader, sample); err != nil {
+			return nil, 0, false, err
+		}
+		if _, err = rd.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, false, err
+		}
+	case *sequentialReader:
+		if n, err = util.ReadAtMost(rd.Reader, sample); err != nil {
+			return nil, 0, false, err
+		}
+		reader = &sequentialReader{Reader: io.MultiReader(bytes.NewReader(sample[:n]), rd.Reader)}
+	default:
+		if n, err = util.ReadAtMost(reader, sample); err != nil {
+			return nil, 0, false, err
+		}
+		reader = io.MultiReader(bytes.NewReader(sample[:n]), reader)
+	}
+	mimeType := typesniffer.DetectContentType(sample[:n]).GetMimeType()
+	if !imagemeta.IsStrippable(mimeType) {
+		return reader, size, false, nil
+	}
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	stripped, err := imagemeta.Strip(content, mimeType)
+	if err != nil {
+		// an image which cannot be parsed cannot be displayed either, it is served as it is
+		log.Debug("ServeData: unable to strip the metadata of a %s image: %v", mimeType, err)
+		return bytes.NewReader(content), size, false, nil
+	}
+	return bytes.NewReader(stripped), int64(len(stripped)), true, nil
+}
+
+// newResponseWriter returns the writer the content of a response is written to. It stops the reading
+// of the content as soon as the client has gone away and limits the download bandwidth if configured.
+func newResponseWriter(ctx *context.Context) io.Writer {
+	var w io.Writer = &contextWriter{ctx: ctx.Req.Context(), w: ctx.Resp}
+	if setting.Service.MaxDownloadBandwidthPerRequest > 0 {
+		w = newThrottledWriter(ctx.Req.Context(), w, setting.Service.MaxDownloadBandwidthPerRequest)
+	}
+	return w
+}
+
+// isForcedAttachment returns whether content with the given name is always saved as an attachment according
+// to the ForceAttachmentExtensions setting. The extension of a gzipped file is checked without the .gz as well.
+func isForcedAttachment(name string) bool {
+	if len(setting.UI.ForceAttachmentExtensions) == 0 {
+		return false
+	}
+	exts := []string{path.Ext(name)}
+	if strings.EqualFold(exts[0], ".gz") {
+		exts = append(exts, path.Ext(name[:len(name)-len(".gz")]))
+	}
+	for _, forced := range setting.UI.ForceAttachmentExtensions {
+		forced = strings.TrimSpace(forced)
+		if len(forced) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(forced, ".") {
+			forced = "." + forced
+		}
+		for _, ext := range exts {
+			if strings.EqualFold(ext, forced) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isInlineContentType returns whether content of the given MIME type may be displayed by the browser
+// according to the InlineContentTypes setting, whose entries may end with "/*" to match a whole category
+func isInlineContentType(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	for _, allowed := range setting.UI.InlineContentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mimeType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mimeType, allowed[:len(allowed)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxInlineMediaSize returns the size above which content of the sniffed type is downloaded instead of
+// being displayed according to the [ui.max_inline_media_size] of its category, or 0 if there is no limit
+func maxInlineMediaSize(st typesniffer.SniffedType) int64 {
+	switch {
+	case st.IsImage():
+		return setting.UI.MaxInlineMediaSize.Image
+	case st.IsPDF():
+		return setting.UI.MaxInlineMediaSize.PDF
+	}
+	return 0
+}
+
+// lookupMimeType returns the MIME type configured for the extension of name, preferring the overrides
+// of the current repository over the instance wide MimeTypeMap, or an empty string if there is none.
+// Multi-part extensions like ".tar.gz" are tried before the last extension alone.
+func lookupMimeType(ctx *context.Context, name string) string {
+	extensions := fileExtensions(name)
+	if ctx.Repo != nil && ctx.Repo.Repository != nil {
+		for _, ext := range extensions {
+			// repository admins must not be able to run scripts on the origin of Gitea, whatever has been stored
+			if mimeType, ok := ctx.Repo.Repository.MimeTypeMap[ext]; ok && !typesniffer.IsScriptableMimeType(mimeType) {
+				return mimeType
+			}
+		}
+	}
+	if setting.MimeTypeMap.Enabled {
+		for _, ext := range extensions {
+			if mimeType, ok := setting.MimeTypeMap.Map[ext]; ok {
+				return mimeType
+			}
+		}
+	}
+	for _, ext := range extensions {
+		if mimeType, ok := setting.DefaultMimeType(ext); ok {
+			return mimeType
+		}
+	}
+	return ""
+}
+
+// fileExtensions returns all lowercased extensions of name from the longest to the shortest,
+// e.g. ".tar.gz" and ".gz" for "archive.tar.gz"
+func fileExtensions(name string) []string {
+	name = strings.ToLower(filepath.Base(name))
+	var extensions []string
+	for i := strings.IndexByte(name, '.'); i >= 0; {
+		extensions = append(extensions, name[i:])
+		next := strings.IndexByte(name[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return extensions
+}
+
+// cacheControlDirective returns the Cache-Control directive for content with the given file name and MIME type
+func cacheControlDirective(name, mimeType string, immutable bool) string {
+	if immutable {
+		return "public,max-age=31536000,immutable"
+	}
+	if setting.CacheControl.Enabled {
+		mimeType = strings.ToLower(mimeType)
+		category := strings.SplitN(mimeType, "/", 2)[0]
+		for _, key := range []string{strings.ToLower(filepath.Ext(name)), mimeType, category} {
+			if directive, ok := setting.CacheControl.Map[key]; ok && len(key) > 0 {
+				return directive
+			}
+		}
+	}
+	return "public,max-age=86400"
+}
+
+// isSaveData returns whether the client asks to reduce the data it is sent with the Save-Data client hint
+func isSaveData(req *http.Request) bool {
+	return strings.EqualFold(strings.TrimSpace(req.Header.Get("Save-Data")), "on")
+}
+
+// isNotModifiedSince checks whether content last modified at modTime is unchanged since the If-Modified-Since
+// time of the request. As required by RFC 7232 the header is ignored if the request also contains If-None-Match,
+// and it is ignored as well if the client asks for fresh content with no-cache.
+func isNotModifiedSince(req *http.Request, modTime time.Time) bool {
+	ifModifiedSince := req.Header.Get("If-Modified-Since")
+	if len(ifModifiedSince) == 0 || len(req.Header.Get("If-None-Match")) > 0 || httpcache.IsNoCacheRequested(req) {
+		return false
+	}
+	t, err := time.Parse(http.TimeFormat, ifModifiedSince)
+	return err == nil && modTime.Unix() <= t.Unix()
+}
+
+// contentDisposition returns a Content-Disposition header value for the file name. The filename parameter
+// holds an ASCII-only fallback without commas, which old versions of Google Chrome choke on, and if that
+// differs from the name the exact name is added as an RFC 5987 encoded filename* parameter.
+func contentDisposition(disposition, name string) string {
+	var fallback strings.Builder
+	for _, r := range name {
+		switch {
+		case r == ',':
+			fallback.WriteByte(' ')
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		case r < ' ' || r > '~':
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	header := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback.String())
+	if fallback.String() != name {
+		header += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return header
+}
+
+// encodeRFC5987 percent-encodes all bytes of s which are not an attr-char as defined by RFC 5987
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,2690 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	gocontext "context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/cache"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/test"
+	"code.gitea.io/gitea/modules/util"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"gopkg.in/ini.v1"
+)
+
+func mockServeDataContext(t *testing.T, rng string) (*context.Context, *httptest.ResponseRecorder) {
+	ctx := test.MockContext(t, "user2/repo1/raw/branch/master/file.bin")
+	recorder := httptest.NewRecorder()
+	ctx.Resp = context.NewResponse(recorder)
+	ctx.Req.Header = make(http.Header)
+	if rng != "" {
+		ctx.Req.Header.Set("Range", rng)
+	}
+	return ctx, recorder
+}
+
+func TestServeDataRange(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+	size := int64(len(content))
+
+	t.Run("NoRange", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "bytes", recorder.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "100", recorder.Header().Get("Content-Length"))
+		assert.Empty(t, recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+
+	t.Run("Middle", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=10-19")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "10", recorder.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes 10-19/100", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content[10:20], recorder.Body.Bytes())
+	})
+
+	t.Run("EOF", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=95-")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "5", recorder.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes 95-99/100", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content[95:], recorder.Body.Bytes())
+
+		ctx, recorder = mockServeDataContext(t, "bytes=95-200")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "bytes 95-99/100", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content[95:], recorder.Body.Bytes())
+	})
+
+	t.Run("FullFile", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=0-")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "100", recorder.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes 0-99/100", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+}
+
+func TestServeDataSuffixRange(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	size := int64(len(content))
+
+	t.Run("Suffix", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=-500")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "500", recorder.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes 500-999/1000", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content[500:], recorder.Body.Bytes())
+	})
+
+	t.Run("ZeroSuffix", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=-0")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, recorder.Code)
+		assert.Equal(t, "bytes */1000", recorder.Header().Get("Content-Range"))
+	})
+
+	t.Run("SuffixLargerThanFile", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=-5000")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "1000", recorder.Header().Get("Content-Length"))
+		assert.Equal(t, "bytes 0-999/1000", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+}
+
+func TestServeDataRangeNotSatisfiable(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+	size := int64(len(content))
+
+	for _, rng := range []string{"bytes=100-", "bytes=150-200", "bytes=50-10"} {
+		ctx, recorder := mockServeDataContext(t, rng)
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)), rng)
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, recorder.Code, rng)
+		assert.Equal(t, "bytes */100", recorder.Header().Get("Content-Range"), rng)
+		assert.Empty(t, recorder.Body.Bytes(), rng)
+	}
+
+	t.Run("EmptyContent", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=0-")
+		assert.NoError(t, ServeData(ctx, "file.bin", 0, bytes.NewReader([]byte{})))
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, recorder.Code)
+		assert.Equal(t, "bytes */0", recorder.Header().Get("Content-Range"))
+		assert.Empty(t, recorder.Body.Bytes())
+	})
+}
+
+func TestServeDataRangeUnit(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+	size := int64(len(content))
+
+	// only bytes are supported, ranges of other units are ignored just like malformed ones
+	for _, rng := range []string{"items=0-9", "byte=0-9", "ytes=0-9", "sbytes=0-9", "bytes=a-9", "bytes=+0-9", "bytes="} {
+		ctx, recorder := mockServeDataContext(t, rng)
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)), rng)
+		assert.Equal(t, http.StatusOK, recorder.Code, rng)
+		assert.Equal(t, "bytes", recorder.Header().Get("Accept-Ranges"), rng)
+		assert.Empty(t, recorder.Header().Get("Content-Range"), rng)
+		assert.Equal(t, content, recorder.Body.Bytes(), rng)
+	}
+
+	// so are ranges which would send the content over and over again
+	for _, rng := range []string{"bytes=0-,0-", "bytes=" + strings.Repeat("0-0,", maxRanges+1)} {
+		ctx, recorder := mockServeDataContext(t, rng)
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content, recorder.Body.Bytes())
+	}
+}
+
+func TestServeDataIfRange(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+	size := int64(len(content))
+	etag := `"65f3c2d1cb1d7cd2f6b36a5d6bd37e0b3e2fa4b0"`
+
+	t.Run("Matching", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=10-19")
+		ctx.Req.Header.Set("If-Range", etag)
+		ctx.Resp.Header().Set("Etag", etag)
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "bytes 10-19/100", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content[10:20], recorder.Body.Bytes())
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=10-19")
+		ctx.Req.Header.Set("If-Range", `"0000000000000000000000000000000000000000"`)
+		ctx.Resp.Header().Set("Etag", etag)
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "100", recorder.Header().Get("Content-Length"))
+		assert.Empty(t, recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+
+	t.Run("Weak", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=10-19")
+		ctx.Req.Header.Set("If-Range", "W/"+etag)
+		ctx.Resp.Header().Set("Etag", etag)
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+}
+
+func TestServeDataMultipleRanges(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 30))
+	size := int64(len(content))
+
+	t.Run("Multipart", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=0-99,200-299")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Content-Range"))
+		assert.Equal(t, strconv.Itoa(recorder.Body.Len()), recorder.Header().Get("Content-Length"))
+
+		mediaType, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Type"))
+		assert.NoError(t, err)
+		assert.Equal(t, "multipart/byteranges", mediaType)
+
+		mr := multipart.NewReader(recorder.Body, params["boundary"])
+		for _, expected := range []struct {
+			contentRange string
+			data         []byte
+		}{
+			{"bytes 0-99/300", content[0:100]},
+			{"bytes 200-299/300", content[200:300]},
+		} {
+			part, err := mr.NextPart()
+			assert.NoError(t, err)
+			assert.Equal(t, expected.contentRange, part.Header.Get("Content-Range"))
+			assert.NotEmpty(t, part.Header.Get("Content-Type"))
+			data, err := io.ReadAll(part)
+			assert.NoError(t, err)
+			assert.Equal(t, expected.data, data)
+		}
+		_, err = mr.NextPart()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("SingleSatisfiable", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "bytes=0-9,500-600")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "bytes 0-9/300", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content[0:10], recorder.Body.Bytes())
+	})
+
+	t.Run("Overlapping", func(t *testing.T) {
+		// overlapping ranges are sent as one
+		ctx, recorder := mockServeDataContext(t, "bytes=0-9,5-19")
+		assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, "bytes 0-19/300", recorder.Header().Get("Content-Range"))
+		assert.Equal(t, content[0:20], recorder.Body.Bytes())
+	})
+
+	t.Run("Excessive", func(t *testing.T) {
+		// the content must not be sent over and over again, it is sent once in full instead
+		for _, rng := range []string{"bytes=0-,0-,0-", "bytes=" + strings.Repeat("0-0,", 1000)} {
+			ctx, recorder := mockServeDataContext(t, rng)
+			assert.NoError(t, ServeData(ctx, "file.bin", size, bytes.NewReader(content)))
+			assert.Equal(t, http.StatusOK, recorder.Code)
+			assert.Equal(t, content, recorder.Body.Bytes())
+		}
+	})
+}
+
+func TestServeDataSniffSampleSize(t *testing.T) {
+	defer func(size int) {
+		setting.UI.SniffSampleSize = size
+	}(setting.UI.SniffSampleSize)
+
+	content := []byte(strings.Repeat("0123456789", 300))
+	for _, sampleSize := range []int{1, 1024, 2999, 3000, 4096} {
+		setting.UI.SniffSampleSize = sampleSize
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+		assert.Equal(t, content, recorder.Body.Bytes(), "sample size %d", sampleSize)
+	}
+}
+
+func TestServeDataRenderSampleSize(t *testing.T) {
+	defer func(size int) { setting.UI.RenderSampleSize = size }(setting.UI.RenderSampleSize)
+	setting.UI.RenderSampleSize = 8192
+
+	// ISO-8859-1 text whose first non-ASCII character only comes after 2176 bytes, far beyond the sniff sample
+	content := append([]byte(strings.Repeat("plain ascii text\n", 128)), []byte(strings.Repeat("caf\xe9 na\xefve r\xe9sum\xe9 \xe0 la cr\xe8me br\xfbl\xe9e\n", 20))...)
+
+	serve := func(render bool) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		if render {
+			ctx.Req.Form.Set("render", "1")
+		}
+		assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	recorder := serve(false)
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+
+	recorder = serve(true)
+	assert.NotContains(t, recorder.Header().Get("Content-Type"), "utf-8")
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain; charset=")
+	assert.Equal(t, content, recorder.Body.Bytes())
+}
+
+func TestServeBlobByPath(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+
+	t.Run("File", func(t *testing.T) {
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, `"ce013625030ba8dba906f756967f9e9ca394464a"`, recorder.Header().Get("Etag"))
+		assert.Equal(t, "hello\n", recorder.Body.String())
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c"))
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("NotExist", func(t *testing.T) {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/nonexistent"))
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
+
+func TestServeBlobRange(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, _ := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+	blob, err := ctx.Repo.Commit.GetBlobByPath("a/c/hi")
+	assert.NoError(t, err)
+	catFile, err := git.NewCommand("cat-file", "blob", blob.ID.String()).RunInDirBytes(ctx.Repo.Repository.RepoPath())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(catFile))
+
+	serve := func(start, length int64) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Repo = &context.Repository{TreePath: "a/c/hi"}
+		assert.NoError(t, ServeBlobRange(ctx, blob, start, length))
+		return recorder
+	}
+
+	kases := []struct {
+		start, length int64
+		end           int64
+	}{
+		{0, 6, 5},
+		{0, 1, 0},
+		{1, 3, 3},
+		{5, 1, 5},
+		{4, 100, 5},
+	}
+	for _, kase := range kases {
+		recorder := serve(kase.start, kase.length)
+		assert.Equal(t, http.StatusPartialContent, recorder.Code)
+		assert.Equal(t, catFile[kase.start:kase.end+1], recorder.Body.Bytes(), "start %d length %d", kase.start, kase.length)
+		assert.Equal(t, fmt.Sprintf("bytes %d-%d/6", kase.start, kase.end), recorder.Header().Get("Content-Range"))
+		assert.Equal(t, strconv.FormatInt(kase.end-kase.start+1, 10), recorder.Header().Get("Content-Length"))
+		assert.Equal(t, `attachment; filename="hi"`, recorder.Header().Get("Content-Disposition"))
+	}
+
+	for _, kase := range [][2]int64{{6, 1}, {-1, 2}, {0, 0}} {
+		recorder := serve(kase[0], kase[1])
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, recorder.Code)
+		assert.Equal(t, "bytes */6", recorder.Header().Get("Content-Range"))
+		assert.Empty(t, recorder.Body.Bytes())
+	}
+}
+
+func TestServeBlobRenderETag(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	serve := func(render bool, ifNoneMatch string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+		test.LoadRepoCommit(t, ctx)
+		if render {
+			ctx.Req.Form.Set("render", "1")
+		}
+		if ifNoneMatch != "" {
+			ctx.Req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+		return recorder
+	}
+
+	raw := serve(false, "")
+	rendered := serve(true, "")
+	assert.Equal(t, `"ce013625030ba8dba906f756967f9e9ca394464a"`, raw.Header().Get("Etag"))
+	assert.Equal(t, `W/"ce013625030ba8dba906f756967f9e9ca394464a-render"`, rendered.Header().Get("Etag"))
+
+	// each representation only validates against its own ETag
+	assert.Equal(t, http.StatusNotModified, serve(true, rendered.Header().Get("Etag")).Code)
+	assert.Equal(t, http.StatusOK, serve(true, raw.Header().Get("Etag")).Code)
+	assert.Equal(t, http.StatusOK, serve(false, rendered.Header().Get("Etag")).Code)
+}
+
+func TestServeBlobWeakETag(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(enabled bool) { setting.UI.CompressServedContent = enabled }(setting.UI.CompressServedContent)
+	setting.UI.CompressServedContent = true
+
+	serve := func(form, header map[string]string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+		test.LoadRepoCommit(t, ctx)
+		for k, v := range form {
+			ctx.Req.Form.Set(k, v)
+		}
+		for k, v := range header {
+			ctx.Req.Header.Set(k, v)
+		}
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+		return recorder
+	}
+
+	kases := []struct {
+		form   map[string]string
+		header map[string]string
+		etag   string
+	}{
+		{nil, nil, `"ce013625030ba8dba906f756967f9e9ca394464a"`},
+		{nil, map[string]string{"Accept-Encoding": "gzip"}, `W/"ce013625030ba8dba906f756967f9e9ca394464a"`},
+		{map[string]string{"render": "1"}, nil, `W/"ce013625030ba8dba906f756967f9e9ca394464a-render"`},
+		{map[string]string{"charset": "utf-8"}, nil, `W/"ce013625030ba8dba906f756967f9e9ca394464a-utf-8"`},
+	}
+	for _, kase := range kases {
+		recorder := serve(kase.form, kase.header)
+		assert.Equal(t, http.StatusOK, recorder.Code, kase.etag)
+		assert.Equal(t, kase.etag, recorder.Header().Get("Etag"))
+	}
+
+	// a weak ETag still revalidates the compressed representation, but it cannot validate ranges
+	compressed := map[string]string{"Accept-Encoding": "gzip", "If-None-Match": `W/"ce013625030ba8dba906f756967f9e9ca394464a"`}
+	assert.Equal(t, http.StatusNotModified, serve(nil, compressed).Code)
+	recorder := serve(map[string]string{"render": "1"}, map[string]string{"Range": "bytes=0-1", "If-Range": `W/"ce013625030ba8dba906f756967f9e9ca394464a-render"`})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello\n", recorder.Body.String())
+}
+
+func TestServeBlobTextContentDisposition(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(disposition string) { setting.UI.TextContentDisposition = disposition }(setting.UI.TextContentDisposition)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	commit := commitTestRepo(t, ctx, map[string]string{"notes.txt": "some notes\n"})
+
+	serve := func() {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, commit, "notes.txt"))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "some notes\n", recorder.Body.String())
+	}
+
+	setting.UI.TextContentDisposition = "inline"
+	serve()
+	assert.Equal(t, `inline; filename="notes.txt"`, recorder.Header().Get("Content-Disposition"))
+
+	setting.UI.TextContentDisposition = "attachment"
+	serve()
+	assert.Equal(t, `attachment; filename="notes.txt"`, recorder.Header().Get("Content-Disposition"))
+	assert.Contains(t, recorder.Header().Values("Access-Control-Expose-Headers"), "Content-Disposition")
+}
+
+func TestServeBlobNoCache(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	serve := func(conditional, noCache map[string]string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+		test.LoadRepoCommit(t, ctx)
+		for k, v := range conditional {
+			ctx.Req.Header.Set(k, v)
+		}
+		for k, v := range noCache {
+			ctx.Req.Header.Set(k, v)
+		}
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+		return recorder
+	}
+
+	fresh := serve(nil, nil)
+	for _, conditional := range []map[string]string{
+		{"If-None-Match": fresh.Header().Get("Etag")},
+		{"If-Modified-Since": fresh.Header().Get("Last-Modified")},
+	} {
+		assert.Equal(t, http.StatusNotModified, serve(conditional, nil).Code, conditional)
+		for _, noCache := range []map[string]string{{"Cache-Control": "no-cache"}, {"Pragma": "no-cache"}} {
+			recorder := serve(conditional, noCache)
+			assert.Equal(t, http.StatusOK, recorder.Code, conditional, noCache)
+			assert.Equal(t, "hello\n", recorder.Body.String(), conditional, noCache)
+		}
+	}
+}
+
+func TestServeBlobReadError(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	t.Run("MissingObject", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "")
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+
+		blob, err := ctx.Repo.GitRepo.GetBlob("0123456789abcdef0123456789abcdef01234567")
+		assert.NoError(t, err)
+		assert.NoError(t, ServeBlob(ctx, blob))
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("IOError", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "")
+		test.LoadRepo(t, ctx, 31)
+
+		// a git repository whose context has been cancelled cannot read anything anymore
+		repoCtx, cancel := gocontext.WithCancel(gocontext.Background())
+		gitRepo, err := git.OpenRepositoryCtx(repoCtx, ctx.Repo.Repository.RepoPath())
+		assert.NoError(t, err)
+		defer gitRepo.Close()
+		cancel()
+
+		blob, err := gitRepo.GetBlob("ce013625030ba8dba906f756967f9e9ca394464a")
+		assert.NoError(t, err)
+		err = ServeBlob(ctx, blob)
+		assert.Error(t, err)
+		assert.False(t, git.IsErrNotExist(err))
+		assert.False(t, ctx.Written())
+		assert.Empty(t, recorder.Body.Bytes())
+	})
+}
+
+func TestServeDataCacheControl(t *testing.T) {
+	defer func(enabled bool, m map[string]string) {
+		setting.CacheControl.Enabled = enabled
+		setting.CacheControl.Map = m
+	}(setting.CacheControl.Enabled, setting.CacheControl.Map)
+
+	png, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+
+	serve := func(name string, content []byte, opts ServeOptions) string {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeDataWithOptions(ctx, name, int64(len(content)), bytes.NewReader(content), opts))
+		return recorder.Header().Get("Cache-Control")
+	}
+
+	setting.CacheControl.Enabled = false
+	assert.Equal(t, "public,max-age=86400", serve("image.png", png, ServeOptions{}))
+	assert.Equal(t, "public,max-age=31536000,immutable", serve("image.png", png, ServeOptions{Immutable: true}))
+
+	setting.CacheControl.Enabled = true
+	setting.CacheControl.Map = map[string]string{
+		".iso":      "no-cache",
+		"image":     "public,max-age=604800",
+		"image/png": "public,max-age=3600",
+		"text":      "public,max-age=60",
+	}
+	assert.Equal(t, "public,max-age=3600", serve("image.png", png, ServeOptions{}))
+	assert.Equal(t, "no-cache", serve("image.iso", png, ServeOptions{}))
+	assert.Equal(t, "public,max-age=60", serve("file.txt", []byte("plain text"), ServeOptions{}))
+	assert.Equal(t, "public,max-age=86400", serve("file.bin", []byte{0, 1, 2, 3}, ServeOptions{}))
+	assert.Equal(t, "public,max-age=31536000,immutable", serve("file.txt", []byte("plain text"), ServeOptions{Immutable: true}))
+
+	delete(setting.CacheControl.Map, "image/png")
+	assert.Equal(t, "public,max-age=604800", serve("image.png", png, ServeOptions{}))
+}
+
+func TestServeDataNoCache(t *testing.T) {
+	content := []byte("TOKEN=secret")
+	lastModified := time.Date(2021, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	// an ETag the caller has sent already is withdrawn, even if the client has it
+	ctx.Resp.Header().Set("Etag", `"abc"`)
+	ctx.Req.Header.Set("If-None-Match", `"abc"`)
+	ctx.Req.Header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+	opts := ServeOptions{NoCache: true, Immutable: true, LastModified: lastModified}
+	assert.NoError(t, ServeDataWithOptions(ctx, "app.ini", int64(len(content)), bytes.NewReader(content), opts))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "no-store, private", recorder.Header().Get("Cache-Control"))
+	assert.Empty(t, recorder.Header().Get("Etag"))
+	assert.Empty(t, recorder.Header().Get("Last-Modified"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+
+	// compressed content is not stored either
+	defer func(compress bool) { setting.UI.CompressServedContent = compress }(setting.UI.CompressServedContent)
+	setting.UI.CompressServedContent = true
+	ctx, recorder = mockServeDataContext(t, "")
+	ctx.Req.Header.Set("Accept-Encoding", "gzip")
+	assert.NoError(t, ServeDataWithOptions(ctx, "app.ini", int64(len(content)), bytes.NewReader(content), ServeOptions{NoCache: true}))
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, "no-store, private", recorder.Header().Get("Cache-Control"))
+	assert.Empty(t, recorder.Header().Get("Etag"))
+}
+
+func TestServeDataLastModified(t *testing.T) {
+	content := []byte("plain text")
+	lastModified := time.Date(2021, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	t.Run("NoIfModifiedSince", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeDataWithOptions(ctx, "file.txt", int64(len(content)), bytes.NewReader(content), ServeOptions{LastModified: lastModified}))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "Fri, 24 Dec 2021 10:00:00 GMT", recorder.Header().Get("Last-Modified"))
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+
+	t.Run("ModifiedSince", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Header.Set("If-Modified-Since", lastModified.Add(-time.Hour).Format(http.TimeFormat))
+		assert.NoError(t, ServeDataWithOptions(ctx, "file.txt", int64(len(content)), bytes.NewReader(content), ServeOptions{LastModified: lastModified}))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+
+	t.Run("NotModifiedSince", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Header.Set("If-Modified-Since", lastModified.Add(time.Hour).Format(http.TimeFormat))
+		assert.NoError(t, ServeDataWithOptions(ctx, "file.txt", int64(len(content)), bytes.NewReader(content), ServeOptions{LastModified: lastModified}))
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		assert.Equal(t, "Fri, 24 Dec 2021 10:00:00 GMT", recorder.Header().Get("Last-Modified"))
+		assert.NotEmpty(t, recorder.Header().Get("Cache-Control"))
+		assert.Empty(t, recorder.Body.Bytes())
+	})
+
+	t.Run("IfNoneMatchTakesPrecedence", func(t *testing.T) {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Header.Set("If-Modified-Since", lastModified.Add(time.Hour).Format(http.TimeFormat))
+		ctx.Req.Header.Set("If-None-Match", `"stale"`)
+		assert.NoError(t, ServeDataWithOptions(ctx, "file.txt", int64(len(content)), bytes.NewReader(content), ServeOptions{LastModified: lastModified}))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+}
+
+func TestContentDisposition(t *testing.T) {
+	kases := []struct {
+		name     string
+		expected string
+	}{
+		{"file.bin", `attachment; filename="file.bin"`},
+		{"Привет.txt", `attachment; filename="______.txt"; filename*=UTF-8''%D0%9F%D1%80%D0%B8%D0%B2%D0%B5%D1%82.txt`},
+		{"my file, v2.bin", `attachment; filename="my file  v2.bin"; filename*=UTF-8''my%20file%2C%20v2.bin`},
+		{"data,2024.csv", `attachment; filename="data 2024.csv"; filename*=UTF-8''data%2C2024.csv`},
+		{`say "hi".bin`, `attachment; filename="say \"hi\".bin"; filename*=UTF-8''say%20%22hi%22.bin`},
+		{`back\slash.bin`, `attachment; filename="back\\slash.bin"; filename*=UTF-8''back%5Cslash.bin`},
+	}
+	for _, kase := range kases {
+		assert.Equal(t, kase.expected, contentDisposition("attachment", kase.name), kase.name)
+	}
+
+	ctx, recorder := mockServeDataContext(t, "")
+	content := []byte{0, 1, 2, 3}
+	assert.NoError(t, ServeData(ctx, "dir/Привет.bin", int64(len(content)), bytes.NewReader(content)))
+	assert.Equal(t, `attachment; filename="______.bin"; filename*=UTF-8''%D0%9F%D1%80%D0%B8%D0%B2%D0%B5%D1%82.bin`, recorder.Header().Get("Content-Disposition"))
+}
+
+func TestServeDataForceDownload(t *testing.T) {
+	png, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+	text := []byte("plain text")
+	binary := []byte{0, 1, 2, 3}
+
+	serve := func(name string, content []byte, form map[string]string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		for k, v := range form {
+			ctx.Req.Form.Set(k, v)
+		}
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	recorder := serve("image.png", png, nil)
+	assert.Equal(t, `inline; filename="image.png"`, recorder.Header().Get("Content-Disposition"))
+
+	recorder = serve("image.png", png, map[string]string{"download": "1"})
+	assert.Equal(t, `attachment; filename="image.png"`, recorder.Header().Get("Content-Disposition"))
+
+	recorder = serve("image.png", png, map[string]string{"attachment": "true"})
+	assert.Equal(t, `attachment; filename="image.png"`, recorder.Header().Get("Content-Disposition"))
+
+	recorder = serve("file.txt", text, map[string]string{"download": "1"})
+	assert.Equal(t, `attachment; filename="file.txt"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+
+	recorder = serve("file.bin", binary, map[string]string{"render": "1"})
+	assert.Equal(t, `inline; filename="file.bin"`, recorder.Header().Get("Content-Disposition"))
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+
+	recorder = serve("file.bin", binary, map[string]string{"render": "1", "download": "1"})
+	assert.Equal(t, `attachment; filename="file.bin"`, recorder.Header().Get("Content-Disposition"))
+	assert.False(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+}
+
+func TestServeDataTextOverride(t *testing.T) {
+	minified := []byte("plain text")
+	generated := []byte{0, 1, 2, 3}
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeDataWithOptions(ctx, "app.min.js", int64(len(minified)), bytes.NewReader(minified), ServeOptions{Text: util.OptionalBoolFalse}))
+	assert.Equal(t, `attachment; filename="app.min.js"`, recorder.Header().Get("Content-Disposition"))
+
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeDataWithOptions(ctx, "generated.dat", int64(len(generated)), bytes.NewReader(generated), ServeOptions{Text: util.OptionalBoolTrue}))
+	assert.Equal(t, `inline; filename="generated.dat"`, recorder.Header().Get("Content-Disposition"))
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeDataWithOptions(ctx, "file.txt", int64(len(minified)), bytes.NewReader(minified), ServeOptions{}))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+}
+
+func TestServeDataCompression(t *testing.T) {
+	defer func(enabled bool) { setting.UI.CompressServedContent = enabled }(setting.UI.CompressServedContent)
+	setting.UI.CompressServedContent = true
+
+	text := []byte(strings.Repeat("some compressible text\n", 100))
+	binary := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+
+	serve := func(content []byte, acceptEncoding, rng string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, rng)
+		if acceptEncoding != "" {
+			ctx.Req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		recorder := serve(text, "gzip, deflate", "")
+		assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+		assert.Empty(t, recorder.Header().Get("Content-Length"))
+		assert.Equal(t, strconv.Itoa(len(text)), recorder.Header().Get("X-Uncompressed-Content-Length"))
+		assert.Less(t, recorder.Body.Len(), len(text))
+		gr, err := gzip.NewReader(recorder.Body)
+		assert.NoError(t, err)
+		decoded, err := io.ReadAll(gr)
+		assert.NoError(t, err)
+		assert.Equal(t, text, decoded)
+	})
+
+	t.Run("Brotli", func(t *testing.T) {
+		recorder := serve(text, "gzip;q=0.8, br", "")
+		assert.Equal(t, "br", recorder.Header().Get("Content-Encoding"))
+		decoded, err := io.ReadAll(brotli.NewReader(recorder.Body))
+		assert.NoError(t, err)
+		assert.Equal(t, text, decoded)
+	})
+
+	t.Run("NotAccepted", func(t *testing.T) {
+		recorder := serve(text, "", "")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Empty(t, recorder.Header().Get("X-Uncompressed-Content-Length"))
+		assert.Equal(t, text, recorder.Body.Bytes())
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		recorder := serve(binary, "gzip", "")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, binary, recorder.Body.Bytes())
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		recorder := serve(text, "gzip", "bytes=0-9")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, text[:10], recorder.Body.Bytes())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		setting.UI.CompressServedContent = false
+		defer func() { setting.UI.CompressServedContent = true }()
+		recorder := serve(text, "gzip", "")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, text, recorder.Body.Bytes())
+	})
+}
+
+func TestServeDataPrecompressed(t *testing.T) {
+	defer func(enabled bool) { setting.UI.ServePrecompressedGzip = enabled }(setting.UI.ServePrecompressedGzip)
+	setting.UI.ServePrecompressedGzip = true
+
+	text := []byte(strings.Repeat("console.log('bundled');\n", 100))
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, err := gw.Write(text)
+	assert.NoError(t, err)
+	assert.NoError(t, gw.Close())
+
+	serve := func(name string, content []byte, acceptEncoding, query string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		if acceptEncoding != "" {
+			ctx.Req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if query != "" {
+			ctx.Req.Form.Set(query, "1")
+		}
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	t.Run("AcceptsGzip", func(t *testing.T) {
+		recorder := serve("bundle.js.gz", gzipped.Bytes(), "gzip, deflate, br", "")
+		assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, strings.SplitN(mime.TypeByExtension(".js"), ";", 2)[0], recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `inline; filename="bundle.js"`, recorder.Header().Get("Content-Disposition"))
+		assert.Equal(t, strconv.Itoa(gzipped.Len()), recorder.Header().Get("Content-Length"))
+		assert.Contains(t, recorder.Header().Values("Vary"), "Accept-Encoding")
+		assert.Equal(t, gzipped.Bytes(), recorder.Body.Bytes())
+	})
+
+	t.Run("DoesNotAcceptGzip", func(t *testing.T) {
+		for _, acceptEncoding := range []string{"", "br", "gzip;q=0", "*;q=0", "gzip;q=0, *"} {
+			recorder := serve("bundle.js.gz", gzipped.Bytes(), acceptEncoding, "")
+			assert.Empty(t, recorder.Header().Get("Content-Encoding"), acceptEncoding)
+			assert.Empty(t, recorder.Header().Get("Content-Length"), acceptEncoding)
+			assert.Equal(t, strings.SplitN(mime.TypeByExtension(".js"), ";", 2)[0], recorder.Header().Get("Content-Type"), acceptEncoding)
+			assert.Equal(t, text, recorder.Body.Bytes(), acceptEncoding)
+		}
+	})
+
+	t.Run("Wildcard", func(t *testing.T) {
+		recorder := serve("style.css.gz", gzipped.Bytes(), "*", "")
+		assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/css", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("NotText", func(t *testing.T) {
+		recorder := serve("archive.tar.gz", gzipped.Bytes(), "gzip", "")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, gzipped.Bytes(), recorder.Body.Bytes())
+	})
+
+	t.Run("NotGzipped", func(t *testing.T) {
+		recorder := serve("bundle.js.gz", text, "gzip", "")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, text, recorder.Body.Bytes())
+	})
+
+	t.Run("Download", func(t *testing.T) {
+		recorder := serve("bundle.js.gz", gzipped.Bytes(), "gzip", "download")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Contains(t, recorder.Header().Get("Content-Disposition"), `filename="bundle.js.gz"`)
+		assert.Equal(t, gzipped.Bytes(), recorder.Body.Bytes())
+	})
+
+	t.Run("ETag", func(t *testing.T) {
+		for acceptEncoding, etag := range map[string]string{"gzip": `"abc"`, "": `W/"abc"`} {
+			ctx, recorder := mockServeDataContext(t, "")
+			ctx.Req.Header.Set("Accept-Encoding", acceptEncoding)
+			ctx.Resp.Header().Set("Etag", `"abc"`)
+			assert.NoError(t, ServeData(ctx, "bundle.js.gz", int64(gzipped.Len()), bytes.NewReader(gzipped.Bytes())))
+			// the text isn't byte for byte the content the ETag was computed of
+			assert.Equal(t, etag, recorder.Header().Get("Etag"), acceptEncoding)
+		}
+	})
+
+	t.Run("TextContentDisposition", func(t *testing.T) {
+		defer func(disposition string) { setting.UI.TextContentDisposition = disposition }(setting.UI.TextContentDisposition)
+		setting.UI.TextContentDisposition = "attachment"
+		recorder := serve("bundle.js.gz", gzipped.Bytes(), "gzip", "")
+		assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, `attachment; filename="bundle.js"`, recorder.Header().Get("Content-Disposition"))
+	})
+
+	t.Run("MaxDownloadFileSize", func(t *testing.T) {
+		defer func(size int64) { setting.Service.MaxDownloadFileSize = size }(setting.Service.MaxDownloadFileSize)
+		setting.Service.MaxDownloadFileSize = int64(len(text) - 1)
+		ctx, recorder := mockServeDataContext(t, "")
+		err := ServeData(ctx, "bundle.js.gz", int64(gzipped.Len()), bytes.NewReader(gzipped.Bytes()))
+		assert.ErrorIs(t, err, errDecompressedTooLarge)
+		assert.Equal(t, len(text)-1, recorder.Body.Len())
+
+		setting.Service.MaxDownloadFileSize = int64(len(text))
+		recorder = serve("bundle.js.gz", gzipped.Bytes(), "", "")
+		assert.Equal(t, text, recorder.Body.Bytes())
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		recorder := serve("page.html.gz", gzipped.Bytes(), "gzip", "")
+		assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+		assert.Contains(t, recorder.Header().Get("Content-Security-Policy"), "sandbox")
+	})
+
+	t.Run("ScriptableDocuments", func(t *testing.T) {
+		defer func(allow, svg bool) {
+			setting.UI.AllowRawHTMLPreview, setting.UI.SVG.Enabled = allow, svg
+		}(setting.UI.AllowRawHTMLPreview, setting.UI.SVG.Enabled)
+		setting.UI.AllowRawHTMLPreview, setting.UI.SVG.Enabled = true, true
+
+		// neither ALLOW_RAW_HTML_PREVIEW nor [ui.svg] ENABLE_RENDER lets other documents than HTML be displayed
+		for _, name := range []string{"page.xhtml.gz", "icon.svg.gz", "feed.atom.gz", "data.xml.gz"} {
+			recorder := serve(name, gzipped.Bytes(), "gzip", "")
+			assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"), name)
+		}
+		recorder := serve("page.html.gz", gzipped.Bytes(), "gzip", "")
+		assert.Equal(t, "text/html", recorder.Header().Get("Content-Type"))
+		assert.Contains(t, recorder.Header().Get("Content-Security-Policy"), "sandbox")
+
+		// scripts and other data are still served as what they are
+		recorder = serve("data.json.gz", gzipped.Bytes(), "gzip", "")
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		setting.UI.ServePrecompressedGzip = false
+		defer func() { setting.UI.ServePrecompressedGzip = true }()
+		recorder := serve("bundle.js.gz", gzipped.Bytes(), "gzip", "")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, gzipped.Bytes(), recorder.Body.Bytes())
+	})
+}
+
+func TestServeDataPrecompressedBrotli(t *testing.T) {
+	defer func(enabled bool) { setting.UI.ServePrecompressedBrotli = enabled }(setting.UI.ServePrecompressedBrotli)
+	setting.UI.ServePrecompressedBrotli = true
+
+	text := []byte(strings.Repeat("body { color: #000; }\n", 500))
+	var compressed bytes.Buffer
+	bw := brotli.NewWriter(&compressed)
+	_, err := bw.Write(text)
+	assert.NoError(t, err)
+	assert.NoError(t, bw.Close())
+
+	serve := func(name string, reader io.Reader, size int, acceptEncoding string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		if acceptEncoding != "" {
+			ctx.Req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		assert.NoError(t, ServeData(ctx, name, int64(size), reader))
+		return recorder
+	}
+
+	t.Run("AcceptsBrotli", func(t *testing.T) {
+		recorder := serve("style.css.br", bytes.NewReader(compressed.Bytes()), compressed.Len(), "gzip, deflate, br")
+		assert.Equal(t, "br", recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/css", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `inline; filename="style.css"`, recorder.Header().Get("Content-Disposition"))
+		assert.Equal(t, strconv.Itoa(compressed.Len()), recorder.Header().Get("Content-Length"))
+		assert.Contains(t, recorder.Header().Values("Vary"), "Accept-Encoding")
+		assert.Equal(t, compressed.Bytes(), recorder.Body.Bytes())
+	})
+
+	t.Run("DoesNotAcceptBrotli", func(t *testing.T) {
+		for _, acceptEncoding := range []string{"", "gzip", "br;q=0"} {
+			// a stream has to be sampled to tell it is compressed, the sample must not get lost
+			recorder := serve("style.css.br", readerOnly{bytes.NewReader(compressed.Bytes())}, compressed.Len(), acceptEncoding)
+			assert.Empty(t, recorder.Header().Get("Content-Encoding"), acceptEncoding)
+			assert.Empty(t, recorder.Header().Get("Content-Length"), acceptEncoding)
+			assert.Equal(t, "text/css", recorder.Header().Get("Content-Type"), acceptEncoding)
+			assert.Equal(t, text, recorder.Body.Bytes(), acceptEncoding)
+		}
+	})
+
+	t.Run("NotBrotli", func(t *testing.T) {
+		for _, content := range [][]byte{text, []byte("a"), {}} {
+			recorder := serve("style.css.br", bytes.NewReader(content), len(content), "br")
+			assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+			assert.Equal(t, string(content), recorder.Body.String())
+		}
+	})
+
+	t.Run("MaxDownloadFileSize", func(t *testing.T) {
+		defer func(size int64) { setting.Service.MaxDownloadFileSize = size }(setting.Service.MaxDownloadFileSize)
+		// the compressed content is small enough to be served, but not the text it expands to
+		setting.Service.MaxDownloadFileSize = int64(len(text) - 1)
+		ctx, recorder := mockServeDataContext(t, "")
+		err := ServeData(ctx, "style.css.br", int64(compressed.Len()), bytes.NewReader(compressed.Bytes()))
+		assert.ErrorIs(t, err, errDecompressedTooLarge)
+		assert.Equal(t, len(text)-1, recorder.Body.Len())
+
+		setting.Service.MaxDownloadFileSize = int64(len(text))
+		recorder = serve("style.css.br", bytes.NewReader(compressed.Bytes()), compressed.Len(), "")
+		assert.Equal(t, text, recorder.Body.Bytes())
+
+		// clients accepting brotli get the content as it is
+		setting.Service.MaxDownloadFileSize = int64(compressed.Len())
+		recorder = serve("style.css.br", bytes.NewReader(compressed.Bytes()), compressed.Len(), "br")
+		assert.Equal(t, compressed.Bytes(), recorder.Body.Bytes())
+	})
+
+	t.Run("SVG", func(t *testing.T) {
+		recorder := serve("icon.svg.br", bytes.NewReader(compressed.Bytes()), compressed.Len(), "br")
+		assert.Equal(t, "br", recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		setting.UI.ServePrecompressedBrotli = false
+		defer func() { setting.UI.ServePrecompressedBrotli = true }()
+		recorder := serve("style.css.br", bytes.NewReader(compressed.Bytes()), compressed.Len(), "br")
+		assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+		assert.Equal(t, compressed.Bytes(), recorder.Body.Bytes())
+	})
+}
+
+func TestNegotiateContentEncoding(t *testing.T) {
+	kases := map[string]string{
+		"":                     "",
+		"identity":             "",
+		"gzip":                 "gzip",
+		"deflate, gzip":        "gzip",
+		"gzip, br":             "br",
+		"br;q=0.5, gzip":       "gzip",
+		"GZIP;q=0.1":           "gzip",
+		"gzip;q=0, br;q=0":     "",
+		"br;q=invalid, gzip":   "gzip",
+		"gzip;q=0.9, br;q=1.0": "br",
+	}
+	for acceptEncoding, expected := range kases {
+		assert.Equal(t, expected, negotiateContentEncoding(acceptEncoding), acceptEncoding)
+	}
+}
+
+func TestServeDataMedia(t *testing.T) {
+	mp4, _ := base64.StdEncoding.DecodeString("AAAAGGZ0eXBtcDQyAAAAAGlzb21tcDQyAAEI721vb3YAAABsbXZoZAAAAADaBlwX2gZcFwAAA+gA")
+	mp3, _ := base64.StdEncoding.DecodeString("SUQzBAAAAAABAFRYWFgAAAASAAADbWFqb3JfYnJhbmQAbXA0MgBUWFhYAAAAEQAAA21pbm9yX3Zl")
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "video.mp4", int64(len(mp4)), bytes.NewReader(mp4)))
+	assert.Equal(t, `inline; filename="video.mp4"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "video/mp4", recorder.Header().Get("Content-Type"))
+
+	ctx, recorder = mockServeDataContext(t, "bytes=4-7")
+	assert.NoError(t, ServeData(ctx, "audio.mp3", int64(len(mp3)), bytes.NewReader(mp3)))
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, `inline; filename="audio.mp3"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "audio/mpeg", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, mp3[4:8], recorder.Body.Bytes())
+
+	ctx, recorder = mockServeDataContext(t, "")
+	ctx.Req.Form.Set("download", "1")
+	assert.NoError(t, ServeData(ctx, "video.mp4", int64(len(mp4)), bytes.NewReader(mp4)))
+	assert.Equal(t, `attachment; filename="video.mp4"`, recorder.Header().Get("Content-Disposition"))
+}
+
+func TestServeDataParquet(t *testing.T) {
+	parquet := []byte("PAR1\x15\x04\x15\x10\x15\x14\x4c\x15\x02\x15\x00\x12\x00\x00PAR1")
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "dir/data,2022.parquet", int64(len(parquet)), bytes.NewReader(parquet)))
+	assert.Equal(t, `attachment; filename="data 2022.parquet"; filename*=UTF-8''data%2C2022.parquet`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "application/vnd.apache.parquet", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, parquet, recorder.Body.Bytes())
+}
+
+func TestServeDataSQLite(t *testing.T) {
+	defer func(types []string) { setting.UI.InlineContentTypes = types }(setting.UI.InlineContentTypes)
+	setting.UI.InlineContentTypes = []string{"application/*"}
+
+	sqlite := []byte("SQLite format 3\x00\x10\x00\x01\x01\x00\x40\x20\x20")
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "db/app.db", int64(len(sqlite)), bytes.NewReader(sqlite)))
+	assert.Equal(t, "application/vnd.sqlite3", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="app.db"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, sqlite, recorder.Body.Bytes())
+}
+
+func TestServeDataOfficeDocument(t *testing.T) {
+	// the document part follows a [Content_Types].xml which is larger than the default sniff sample
+	var docx bytes.Buffer
+	zw := zip.NewWriter(&docx)
+	for _, entry := range [][2]string{
+		{"[Content_Types].xml", "<Types>" + strings.Repeat(`<Default Extension="png" ContentType="image/png"/>`, 50) + "</Types>"},
+		{"word/document.xml", "<w:document/>"},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry[0], Method: zip.Store})
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(entry[1]))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "report", int64(docx.Len()), bytes.NewReader(docx.Bytes())))
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="report"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, docx.Bytes(), recorder.Body.Bytes())
+}
+
+func TestServeDataWasm(t *testing.T) {
+	wasm := []byte("\x00asm\x01\x00\x00\x00")
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "module.wasm", int64(len(wasm)), bytes.NewReader(wasm)))
+	assert.Equal(t, "application/wasm", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `inline; filename="module.wasm"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "nosniff", recorder.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, wasm, recorder.Body.Bytes())
+}
+
+func TestServeDataDefaultCharset(t *testing.T) {
+	defer func(cs string) { setting.Repository.DefaultCharset = cs }(setting.Repository.DefaultCharset)
+
+	// a lone continuation byte is not detected as any charset
+	undetectable := []byte{0x80}
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeDataWithOptions(ctx, "file.txt", int64(len(undetectable)), bytes.NewReader(undetectable), ServeOptions{Text: util.OptionalBoolTrue}))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+
+	setting.Repository.DefaultCharset = "gbk"
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeDataWithOptions(ctx, "file.txt", int64(len(undetectable)), bytes.NewReader(undetectable), ServeOptions{Text: util.OptionalBoolTrue}))
+	assert.Equal(t, "text/plain; charset=gbk", recorder.Header().Get("Content-Type"))
+
+	text := []byte("detected as utf-8")
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(text)), bytes.NewReader(text)))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+}
+
+func TestServeDataBOM(t *testing.T) {
+	kases := []struct {
+		name    string
+		bom     []byte
+		text    []byte
+		charset string
+	}{
+		{"UTF-8", []byte{0xef, 0xbb, 0xbf}, []byte(`{"key": "value"}`), "utf-8"},
+		{"UTF-16LE", []byte{0xff, 0xfe}, []byte{'h', 0, 'i', 0}, "utf-16le"},
+		{"UTF-16BE", []byte{0xfe, 0xff}, []byte{0, 'h', 0, 'i'}, "utf-16be"},
+	}
+	for _, kase := range kases {
+		t.Run(kase.name, func(t *testing.T) {
+			content := append(append([]byte{}, kase.bom...), kase.text...)
+
+			ctx, recorder := mockServeDataContext(t, "")
+			assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+			assert.Equal(t, "text/plain; charset="+kase.charset, recorder.Header().Get("Content-Type"))
+			assert.Equal(t, content, recorder.Body.Bytes())
+
+			ctx, recorder = mockServeDataContext(t, "")
+			ctx.Req.Form.Set("strip_bom", "1")
+			assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+			assert.Equal(t, "text/plain; charset="+kase.charset, recorder.Header().Get("Content-Type"))
+			assert.Equal(t, strconv.Itoa(len(kase.text)), recorder.Header().Get("Content-Length"))
+			assert.Equal(t, kase.text, recorder.Body.Bytes())
+
+			ctx, recorder = mockServeDataContext(t, "bytes=0-1")
+			ctx.Req.Form.Set("strip_bom", "1")
+			assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+			assert.Equal(t, content[:2], recorder.Body.Bytes())
+		})
+	}
+}
+
+func TestServeDataVary(t *testing.T) {
+	defer func(enabled bool) { setting.UI.CompressServedContent = enabled }(setting.UI.CompressServedContent)
+	text := []byte("plain text")
+	binary := []byte{0, 1, 2, 3}
+
+	// compressible responses vary on Accept-Encoding, whether or not this client accepted a coding
+	setting.UI.CompressServedContent = true
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(text)), bytes.NewReader(text)))
+	assert.Equal(t, []string{"Accept", "Accept-Encoding"}, recorder.Header().Values("Vary"))
+
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "file.bin", int64(len(binary)), bytes.NewReader(binary)))
+	assert.Equal(t, []string{"Accept"}, recorder.Header().Values("Vary"))
+
+	setting.UI.CompressServedContent = false
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(text)), bytes.NewReader(text)))
+	assert.Equal(t, []string{"Accept"}, recorder.Header().Values("Vary"))
+
+	// the query parameter takes precedence over the Accept header
+	ctx, recorder = mockServeDataContext(t, "")
+	ctx.Req.Form.Set("render", "0")
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(text)), bytes.NewReader(text)))
+	assert.Empty(t, recorder.Header().Values("Vary"))
+}
+
+func TestServeDataMinCharsetConfidence(t *testing.T) {
+	defer func(min int) { setting.Repository.MinCharsetConfidence = min }(setting.Repository.MinCharsetConfidence)
+	// iso-8859-1: caf<accented e>, too short to tell it from a multi-byte charset
+	content := []byte{0x63, 0x61, 0x66, 0xe9}
+
+	serve := func() string {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeDataWithOptions(ctx, "file.txt", int64(len(content)), bytes.NewReader(content), ServeOptions{Text: util.OptionalBoolTrue}))
+		return recorder.Header().Get("Content-Type")
+	}
+
+	setting.Repository.MinCharsetConfidence = 0
+	assert.NotEqual(t, "text/plain; charset="+setting.Repository.DefaultCharset, serve())
+
+	setting.Repository.MinCharsetConfidence = 50
+	assert.Equal(t, "text/plain; charset="+setting.Repository.DefaultCharset, serve())
+}
+
+func TestServeDataTranscode(t *testing.T) {
+	text := strings.Repeat("这是一个使用国标编码的旧源文件，应当转换为统一码。\n", 20)
+	gbk, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(text))
+	assert.NoError(t, err)
+
+	serve := func(name string, content []byte, form map[string]string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		for k, v := range form {
+			ctx.Req.Form.Set(k, v)
+		}
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	for _, form := range []map[string]string{{"charset": "utf-8"}, {"charset": "UTF-8"}, {"transcode": "1"}} {
+		recorder := serve("legacy.c", gbk, form)
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"), form)
+		assert.Empty(t, recorder.Header().Get("Content-Length"), form)
+		assert.Equal(t, text, recorder.Body.String(), form)
+	}
+
+	// without the parameter the content is served as it is stored
+	recorder := serve("legacy.c", gbk, nil)
+	assert.NotEqual(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, strconv.Itoa(len(gbk)), recorder.Header().Get("Content-Length"))
+	assert.Equal(t, gbk, recorder.Body.Bytes())
+
+	// binary content ignores the parameter
+	binary := []byte{0, 1, 2, 3, 0xd5, 0xe2}
+	recorder = serve("file.bin", binary, map[string]string{"charset": "utf-8"})
+	assert.Equal(t, "application/octet-stream", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, binary, recorder.Body.Bytes())
+}
+
+func TestServeDataMaxDownloadFileSize(t *testing.T) {
+	defer func(size int64, allowRanges bool) {
+		setting.Service.MaxDownloadFileSize = size
+		setting.Service.MaxDownloadFileSizeAllowRanges = allowRanges
+	}(setting.Service.MaxDownloadFileSize, setting.Service.MaxDownloadFileSizeAllowRanges)
+	setting.Service.MaxDownloadFileSize = 10
+
+	serve := func(content []byte, rng string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, rng)
+		assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	atLimit := []byte("0123456789")
+	aboveLimit := []byte("0123456789a")
+
+	recorder := serve(atLimit, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, atLimit, recorder.Body.Bytes())
+
+	recorder = serve(aboveLimit, "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "larger than 10 bytes")
+
+	setting.Service.MaxDownloadFileSizeAllowRanges = false
+	recorder = serve(aboveLimit, "bytes=0-4")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	setting.Service.MaxDownloadFileSizeAllowRanges = true
+	recorder = serve(aboveLimit, "bytes=0-4")
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, "01234", recorder.Body.String())
+
+	// a Range header which cannot be understood would have the whole content served
+	recorder = serve(aboveLimit, "lines=0-4")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	// no limit
+	setting.Service.MaxDownloadFileSize = 0
+	recorder = serve(aboveLimit, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestServeDataRenderNoRanges(t *testing.T) {
+	content := []byte("hello world, this is plain text\n")
+
+	serve := func(form map[string]string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "bytes=0-4")
+		for k, v := range form {
+			ctx.Req.Form.Set(k, v)
+		}
+		assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	for _, form := range []map[string]string{{"render": "1"}, {"charset": "utf-8"}} {
+		recorder := serve(form)
+		assert.Equal(t, http.StatusOK, recorder.Code, form)
+		assert.Equal(t, "none", recorder.Header().Get("Accept-Ranges"), form)
+		assert.Empty(t, recorder.Header().Get("Content-Range"), form)
+		assert.Equal(t, content, recorder.Body.Bytes(), form)
+	}
+
+	// the raw content still has its ranges served
+	recorder := serve(nil)
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, "hello", recorder.Body.String())
+}
+
+func TestServeDataMaxRenderFileSize(t *testing.T) {
+	defer func(size int64) { setting.UI.MaxRenderFileSize = size }(setting.UI.MaxRenderFileSize)
+	setting.UI.MaxRenderFileSize = 8
+
+	serve := func(content []byte) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Form.Set("render", "1")
+		assert.NoError(t, ServeData(ctx, "file.bin", int64(len(content)), bytes.NewReader(content)))
+		assert.Equal(t, content, recorder.Body.Bytes())
+		return recorder
+	}
+
+	recorder := serve([]byte{0, 1, 2, 3, 4, 5, 6, 7})
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	assert.Empty(t, recorder.Header().Get("X-Gitea-Render-Skipped"))
+
+	recorder = serve([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8})
+	assert.Equal(t, "application/octet-stream", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "too-large", recorder.Header().Get("X-Gitea-Render-Skipped"))
+	assert.Contains(t, recorder.Header().Get("Content-Disposition"), "attachment")
+
+	setting.UI.MaxRenderFileSize = 0
+	recorder = serve([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8})
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	assert.Empty(t, recorder.Header().Get("X-Gitea-Render-Skipped"))
+}
+
+func TestServeDataAcceptRender(t *testing.T) {
+	binary := []byte{0, 1, 2, 3}
+
+	serve := func(accept, render string) bool {
+		ctx, recorder := mockServeDataContext(t, "")
+		if accept != "" {
+			ctx.Req.Header.Set("Accept", accept)
+		}
+		if render != "" {
+			ctx.Req.Form.Set("render", render)
+		}
+		assert.NoError(t, ServeData(ctx, "file.bin", int64(len(binary)), bytes.NewReader(binary)))
+		assert.Equal(t, binary, recorder.Body.Bytes())
+		return strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain")
+	}
+
+	kases := map[string]bool{
+		"":                            false,
+		"text/html":                   true,
+		"TEXT/HTML":                   true,
+		"text/plain":                  false,
+		"text/html;q=0.5, text/plain": false,
+		"text/html, text/plain;q=0.5": true,
+		"text/html, text/plain":       false,
+		"text/html, */*;q=0":          true,
+		"application/json":            false,
+		"text/html, application/xhtml+xml, application/xml;q=0.9, */*;q=0.8": false,
+		"text/html, text/*;q=0.1": false,
+	}
+	for accept, rendered := range kases {
+		assert.Equal(t, rendered, serve(accept, ""), accept)
+	}
+
+	// the query parameter takes precedence over the Accept header
+	assert.False(t, serve("text/html", "0"))
+	assert.True(t, serve("text/plain", "1"))
+}
+
+func TestServeDataRepoMimeTypeMap(t *testing.T) {
+	defer func(enabled bool, m map[string]string) {
+		setting.MimeTypeMap.Enabled = enabled
+		setting.MimeTypeMap.Map = m
+	}(setting.MimeTypeMap.Enabled, setting.MimeTypeMap.Map)
+	setting.MimeTypeMap.Enabled = true
+	setting.MimeTypeMap.Map = map[string]string{".ts": "application/typescript", ".json": "application/json"}
+
+	content := []byte{0x47, 0x40, 0x00, 0x10}
+	serve := func(name string, overrides map[string]string) string {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Repo = &context.Repository{Repository: &repo_model.Repository{MimeTypeMap: overrides}}
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder.Header().Get("Content-Type")
+	}
+
+	overrides := map[string]string{".ts": "video/mp2t"}
+	assert.Equal(t, "video/mp2t", serve("stream.ts", overrides))
+	assert.Equal(t, "video/mp2t", serve("STREAM.TS", overrides))
+	assert.Equal(t, "application/json", serve("data.json", overrides))
+	assert.Equal(t, "application/typescript", serve("stream.ts", nil))
+
+	// overrides of types which may run scripts are ignored, even if they have been stored before they were refused
+	assert.Equal(t, "application/typescript", serve("stream.ts", map[string]string{".ts": "text/html"}))
+	assert.Equal(t, "application/octet-stream", serve("data.bin", map[string]string{".bin": "application/xhtml+xml"}))
+}
+
+func TestServeDataGeoJSON(t *testing.T) {
+	content := []byte(`{"type": "FeatureCollection", "features": []}`)
+	serve := func(name string, form map[string]string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		for k, v := range form {
+			ctx.Req.Form.Set(k, v)
+		}
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+	defer func(enabled bool, m map[string]string) {
+		setting.MimeTypeMap.Enabled = enabled
+		setting.MimeTypeMap.Map = m
+	}(setting.MimeTypeMap.Enabled, setting.MimeTypeMap.Map)
+	// the types apply without any [repository.mimetype_mapping]
+	setting.MimeTypeMap.Enabled = false
+
+	recorder := serve("map.geojson", nil)
+	assert.Equal(t, "application/geo+json; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `inline; filename="map.geojson"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+
+	recorder = serve("MAP.GEOJSON", nil)
+	assert.Equal(t, "application/geo+json; charset=utf-8", recorder.Header().Get("Content-Type"))
+
+	recorder = serve("map.topojson", nil)
+	assert.Equal(t, "application/json; charset=utf-8", recorder.Header().Get("Content-Type"))
+
+	// the JSON is still text which is rendered as it is
+	recorder = serve("map.geojson", map[string]string{"render": "1"})
+	assert.Equal(t, "application/geo+json; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+
+	// an empty type lets the type be detected again
+	setting.MimeTypeMap.Enabled = true
+	setting.MimeTypeMap.Map = map[string]string{".geojson": ""}
+	recorder = serve("map.geojson", nil)
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+}
+
+func TestFileExtensions(t *testing.T) {
+	assert.Equal(t, []string{".tar.gz", ".gz"}, fileExtensions("archive.tar.gz"))
+	assert.Equal(t, []string{".d.ts", ".ts"}, fileExtensions("dir.v2/Types.D.TS"))
+	assert.Equal(t, []string{".png"}, fileExtensions("image.png"))
+	assert.Equal(t, []string{".eslintrc.json", ".json"}, fileExtensions(".eslintrc.json"))
+	assert.Empty(t, fileExtensions("Makefile"))
+}
+
+func TestServeDataMimeTypeMapMultiExtension(t *testing.T) {
+	defer func(enabled bool, m map[string]string) {
+		setting.MimeTypeMap.Enabled = enabled
+		setting.MimeTypeMap.Map = m
+	}(setting.MimeTypeMap.Enabled, setting.MimeTypeMap.Map)
+	setting.MimeTypeMap.Enabled = true
+	setting.MimeTypeMap.Map = map[string]string{
+		".gz":     "application/gzip",
+		".tar.gz": "application/x-gtar",
+		".ts":     "video/mp2t",
+		".d.ts":   "application/typescript",
+		".png":    "image/png",
+	}
+
+	content := []byte{0x1f, 0x8b, 0x08, 0x00}
+	serve := func(name string) string {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder.Header().Get("Content-Type")
+	}
+
+	assert.Equal(t, "application/x-gtar", serve("archive.tar.gz"))
+	assert.Equal(t, "application/gzip", serve("file.gz"))
+	assert.Equal(t, "application/typescript", serve("index.d.ts"))
+	assert.Equal(t, "video/mp2t", serve("stream.ts"))
+	assert.Equal(t, "image/png", serve("image.png"))
+}
+
+func TestServeDataThrottled(t *testing.T) {
+	defer func(rate int64) { setting.Service.MaxDownloadBandwidthPerRequest = rate }(setting.Service.MaxDownloadBandwidthPerRequest)
+	setting.Service.MaxDownloadBandwidthPerRequest = 2000
+
+	content := []byte(strings.Repeat("0123456789", 300))
+	ctx, recorder := mockServeDataContext(t, "")
+	start := time.Now()
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+	assert.GreaterOrEqual(t, time.Since(start), 1400*time.Millisecond)
+	assert.Equal(t, content, recorder.Body.Bytes())
+}
+
+// cancelAfterReader cancels its context once n bytes have been read from it
+type cancelAfterReader struct {
+	r      io.Reader
+	n      int
+	read   int
+	cancel gocontext.CancelFunc
+}
+
+func (c *cancelAfterReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	if c.read >= c.n {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestServeDataClientGone(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100*1024)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	reqCtx, cancel := gocontext.WithCancel(ctx.Req.Context())
+	defer cancel()
+	ctx.Req = ctx.Req.WithContext(reqCtx)
+
+	// only a plain reader, so that io.Copy has to go through its buffer
+	reader := &cancelAfterReader{r: struct{ io.Reader }{bytes.NewReader(content)}, n: 64 * 1024, cancel: cancel}
+	err := ServeData(ctx, "file.bin", int64(len(content)), reader)
+	assert.ErrorIs(t, err, gocontext.Canceled)
+	assert.Less(t, reader.read, len(content))
+	assert.Less(t, recorder.Body.Len(), len(content))
+}
+
+func TestServeDataWithType(t *testing.T) {
+	// looks like text, but the caller knows better
+	content := []byte("not really text")
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeDataWithType(ctx, "image.png", int64(len(content)), "image/png", bytes.NewReader(content)))
+	assert.Equal(t, "image/png", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `inline; filename="image.png"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeDataWithType(ctx, "data.json", int64(len(content)), "application/json", bytes.NewReader(content)))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="data.json"`, recorder.Header().Get("Content-Disposition"))
+
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeDataWithType(ctx, "notes.md", int64(len(content)), "text/markdown; charset=iso-8859-1", bytes.NewReader(content)))
+	assert.Equal(t, "text/markdown; charset=iso-8859-1", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `inline; filename="notes.md"`, recorder.Header().Get("Content-Disposition"))
+
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeDataWithType(ctx, "file.txt", int64(len(content)), "", bytes.NewReader(content)))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+}
+
+func TestServeDataNoSniff(t *testing.T) {
+	serve := func(name string, content []byte) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	recorder := serve("file.bin", []byte{0, 1, 2, 3})
+	assert.Equal(t, `attachment; filename="file.bin"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "nosniff", recorder.Header().Get("X-Content-Type-Options"))
+
+	recorder = serve("index.html", []byte("<html><body><script>alert(1)</script></body></html>"))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", recorder.Header().Get("X-Content-Type-Options"))
+
+	recorder = serve("image.svg", []byte("<svg></svg>"))
+	assert.Equal(t, "nosniff", recorder.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "default-src 'none'; style-src 'unsafe-inline'; sandbox; frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"))
+}
+
+func TestServeDataSvgContentSecurityPolicy(t *testing.T) {
+	defer func(csp string) { setting.UI.SVG.ContentSecurityPolicy = csp }(setting.UI.SVG.ContentSecurityPolicy)
+	setting.UI.SVG.ContentSecurityPolicy = "default-src 'none'; img-src https://example.com; sandbox"
+
+	content := []byte("<svg></svg>")
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "image.svg", int64(len(content)), bytes.NewReader(content)))
+	assert.Equal(t, "image/svg+xml", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "default-src 'none'; img-src https://example.com; sandbox; frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"))
+}
+
+func TestServeDataForceAttachmentExtensions(t *testing.T) {
+	defer func(exts []string, svg, html bool) {
+		setting.UI.ForceAttachmentExtensions = exts
+		setting.UI.SVG.Enabled = svg
+		setting.UI.AllowRawHTMLPreview = html
+	}(setting.UI.ForceAttachmentExtensions, setting.UI.SVG.Enabled, setting.UI.AllowRawHTMLPreview)
+	setting.UI.SVG.Enabled = true
+	setting.UI.AllowRawHTMLPreview = true
+	svg := []byte("<svg></svg>")
+	html := []byte("<!DOCTYPE html><html><body></body></html>")
+	png, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+
+	serve := func(name string, content []byte) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	setting.UI.ForceAttachmentExtensions = nil
+	assert.Equal(t, `inline; filename="image.svg"`, serve("image.svg", svg).Header().Get("Content-Disposition"))
+	assert.Equal(t, `inline; filename="index.html"`, serve("index.html", html).Header().Get("Content-Disposition"))
+
+	setting.UI.ForceAttachmentExtensions = []string{".html", " SVG", ".js"}
+	recorder := serve("image.svg", svg)
+	assert.Equal(t, `attachment; filename="image.svg"`, recorder.Header().Get("Content-Disposition"))
+	assert.Empty(t, recorder.Header().Get("Content-Security-Policy"))
+	recorder = serve("dir/index.HTML", html)
+	assert.Equal(t, `attachment; filename="index.HTML"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	// the extension decides, not the content
+	assert.Equal(t, `attachment; filename="image.js"`, serve("image.js", png).Header().Get("Content-Disposition"))
+	assert.Equal(t, `inline; filename="image.png"`, serve("image.png", png).Header().Get("Content-Disposition"))
+
+	assert.True(t, isForcedAttachment("bundle.js.gz"))
+	assert.False(t, isForcedAttachment("archive.tar.gz"))
+	assert.False(t, isForcedAttachment("Makefile"))
+}
+
+func TestServeDataHTMLPreview(t *testing.T) {
+	defer func(allow bool) { setting.UI.AllowRawHTMLPreview = allow }(setting.UI.AllowRawHTMLPreview)
+	html := []byte("<!DOCTYPE html><html><body><script>alert(1)</script></body></html>")
+
+	serve := func(form map[string]string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		for k, v := range form {
+			ctx.Req.Form.Set(k, v)
+		}
+		assert.NoError(t, ServeData(ctx, "index.html", int64(len(html)), bytes.NewReader(html)))
+		return recorder
+	}
+
+	setting.UI.AllowRawHTMLPreview = false
+	recorder := serve(nil)
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	// plain text is only restricted in the frames it may be displayed in
+	assert.Equal(t, "frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"))
+
+	setting.UI.AllowRawHTMLPreview = true
+	recorder = serve(nil)
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `inline; filename="index.html"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox; frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", recorder.Header().Get("X-Content-Type-Options"))
+
+	recorder = serve(map[string]string{"download": "1"})
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="index.html"`, recorder.Header().Get("Content-Disposition"))
+	assert.Empty(t, recorder.Header().Get("Content-Security-Policy"))
+}
+
+func TestServeDataFont(t *testing.T) {
+	defer func(origin string) { setting.Repository.AccessControlAllowOrigin = origin }(setting.Repository.AccessControlAllowOrigin)
+
+	kases := map[string][]byte{
+		"font/woff":  []byte("wOFF\x00\x01\x00\x00"),
+		"font/woff2": []byte("wOF2\x00\x01\x00\x00"),
+		"font/otf":   []byte("OTTO\x00\x0a\x00\x80"),
+		"font/ttf":   []byte("\x00\x01\x00\x00\x00\x0a\x00\x80"),
+	}
+	for contentType, content := range kases {
+		setting.Repository.AccessControlAllowOrigin = ""
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, "font.bin", int64(len(content)), bytes.NewReader(content)))
+		assert.Equal(t, contentType, recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `inline; filename="font.bin"`, recorder.Header().Get("Content-Disposition"))
+		assert.Empty(t, recorder.Header().Get("Access-Control-Allow-Origin"))
+
+		setting.Repository.AccessControlAllowOrigin = "https://example.com"
+		ctx, recorder = mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, "font.bin", int64(len(content)), bytes.NewReader(content)))
+		assert.Equal(t, "https://example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestServeDataHead(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10*1024)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	ctx.Req.Method = http.MethodHead
+	reader := &countingReader{r: bytes.NewReader(content)}
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), reader))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, strconv.Itoa(len(content)), recorder.Header().Get("Content-Length"))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Empty(t, recorder.Body.Bytes())
+	assert.Equal(t, setting.UI.SniffSampleSize, reader.read)
+
+	ctx, recorder = mockServeDataContext(t, "bytes=0-9")
+	ctx.Req.Method = http.MethodHead
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, strconv.Itoa(len(content)), recorder.Header().Get("Content-Length"))
+	assert.Equal(t, "bytes", recorder.Header().Get("Accept-Ranges"))
+	assert.Empty(t, recorder.Body.Bytes())
+}
+
+func TestServeDataAcceptRanges(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), bytes.NewReader(content)))
+	assert.Equal(t, "bytes", recorder.Header().Get("Accept-Ranges"))
+
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), readerOnly{bytes.NewReader(content)}))
+	assert.Equal(t, "none", recorder.Header().Get("Accept-Ranges"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+
+	ctx, recorder = mockServeDataContext(t, "bytes=0-9")
+	assert.NoError(t, ServeData(ctx, "file.txt", int64(len(content)), readerOnly{bytes.NewReader(content)}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "none", recorder.Header().Get("Accept-Ranges"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+}
+
+func TestServeDataUnknownSize(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10))
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "file.txt", -1, bytes.NewReader(content)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Content-Length"))
+	assert.Equal(t, "none", recorder.Header().Get("Accept-Ranges"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+
+	ctx, recorder = mockServeDataContext(t, "bytes=10-19")
+	assert.NoError(t, ServeData(ctx, "file.txt", -1, bytes.NewReader(content)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Content-Length"))
+	assert.Empty(t, recorder.Header().Get("Content-Range"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+}
+
+// shortReader returns at most a few bytes on every read, as streams which are only partially available do
+type shortReader struct {
+	r     io.Reader
+	reads int
+}
+
+func (s *shortReader) Read(p []byte) (int, error) {
+	s.reads++
+	if max := s.reads%7 + 1; len(p) > max {
+		p = p[:max]
+	}
+	return s.r.Read(p)
+}
+
+func TestServeDataShortReads(t *testing.T) {
+	sampleSize := setting.UI.SniffSampleSize
+	for _, length := range []int{0, 1, sampleSize - 1, sampleSize, sampleSize + 1, 10 * sampleSize} {
+		content := make([]byte, length)
+		for i := range content {
+			content[i] = byte('a' + i%26)
+		}
+
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, "file.txt", int64(length), &shortReader{r: bytes.NewReader(content)}), length)
+		assert.Equal(t, strconv.Itoa(length), recorder.Header().Get("Content-Length"), length)
+		assert.Equal(t, string(content), recorder.Body.String(), length)
+
+		// a stream which cannot seek is sent in full even if only a range of it is requested
+		ctx, recorder = mockServeDataContext(t, "bytes=0-1")
+		assert.NoError(t, ServeData(ctx, "file.txt", int64(length), &shortReader{r: bytes.NewReader(content)}), length)
+		assert.Equal(t, http.StatusOK, recorder.Code, length)
+		assert.Equal(t, string(content), recorder.Body.String(), length)
+	}
+
+	t.Run("Zip", func(t *testing.T) {
+		// the sample of a ZIP archive is extended to tell office documents apart
+		content := append([]byte("PK\x03\x04"), bytes.Repeat([]byte{0}, 4*sampleSize)...)
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, "file.zip", int64(len(content)), &shortReader{r: bytes.NewReader(content)}))
+		assert.Equal(t, content, recorder.Body.Bytes())
+	})
+
+	t.Run("Longer", func(t *testing.T) {
+		// nothing beyond the declared size is sent
+		content := []byte(strings.Repeat("0123456789", 300))
+		for _, size := range []int{10, 2000} {
+			ctx, recorder := mockServeDataContext(t, "")
+			assert.NoError(t, ServeData(ctx, "file.txt", int64(size), &shortReader{r: bytes.NewReader(content)}))
+			assert.Equal(t, content[:size], recorder.Body.Bytes())
+		}
+	})
+
+	t.Run("Shorter", func(t *testing.T) {
+		// a stream which ends early must not pass for the whole content
+		content := []byte(strings.Repeat("0123456789", 300))
+		ctx, _ := mockServeDataContext(t, "")
+		err := ServeData(ctx, "file.txt", int64(len(content))+1, &shortReader{r: bytes.NewReader(content)})
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestServeDataEmpty(t *testing.T) {
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "empty.txt", 0, bytes.NewReader(nil)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "0", recorder.Header().Get("Content-Length"))
+	assert.Empty(t, recorder.Header().Get("Content-Range"))
+	assert.Empty(t, recorder.Body.Bytes())
+
+	for _, rng := range []string{"bytes=0-", "bytes=0-0", "bytes=-10", "bytes=0-0,5-9"} {
+		ctx, recorder = mockServeDataContext(t, rng)
+		assert.NoError(t, ServeData(ctx, "empty.txt", 0, bytes.NewReader(nil)), rng)
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, recorder.Code, rng)
+		assert.Equal(t, "bytes */0", recorder.Header().Get("Content-Range"), rng)
+		assert.Empty(t, recorder.Body.Bytes(), rng)
+	}
+
+	// a Range header which cannot be understood is still ignored
+	ctx, recorder = mockServeDataContext(t, "items=0-")
+	assert.NoError(t, ServeData(ctx, "empty.txt", 0, bytes.NewReader(nil)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Body.Bytes())
+}
+
+func TestServeDataInlineContentTypes(t *testing.T) {
+	defer func(types []string) { setting.UI.InlineContentTypes = types }(setting.UI.InlineContentTypes)
+	pdf, _ := base64.StdEncoding.DecodeString("JVBERi0xLjYKJcOkw7zDtsOfCjIgMCBvYmoKPDwvTGVuZ3RoIDMgMCBSL0ZpbHRlci9GbGF0ZURlY29kZT4+CnN0cmVhbQp4nF3NPwsCMQwF8D2f4s2CNYk1baF0EHRwOwg4iJt/NsFb/PpevUE4Mjwe")
+	png, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+
+	serve := func(name string, content []byte) string {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder.Header().Get("Content-Disposition")
+	}
+
+	assert.Equal(t, `inline; filename="file.pdf"`, serve("file.pdf", pdf))
+	assert.Equal(t, `inline; filename="image.png"`, serve("image.png", png))
+
+	setting.UI.InlineContentTypes = []string{"image/*"}
+	assert.Equal(t, `attachment; filename="file.pdf"`, serve("file.pdf", pdf))
+	assert.Equal(t, `inline; filename="image.png"`, serve("image.png", png))
+
+	setting.UI.InlineContentTypes = []string{"Image/PNG"}
+	assert.Equal(t, `inline; filename="image.png"`, serve("image.png", png))
+
+	setting.UI.InlineContentTypes = nil
+	assert.Equal(t, `attachment; filename="image.png"`, serve("image.png", png))
+}
+
+func TestServeDataHeifImages(t *testing.T) {
+	defer func(inline bool) { setting.UI.InlineHEIC = inline }(setting.UI.InlineHEIC)
+	avif := []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf\x00\x00\x00\x08meta")
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic\x00\x00\x00\x08meta")
+
+	serve := func(name string, content []byte) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	setting.UI.InlineHEIC = false
+	recorder := serve("photo.avif", avif)
+	assert.Equal(t, "image/avif", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `inline; filename="photo.avif"`, recorder.Header().Get("Content-Disposition"))
+	recorder = serve("photo.heic", heic)
+	assert.Equal(t, "image/heic", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="photo.heic"`, recorder.Header().Get("Content-Disposition"))
+
+	setting.UI.InlineHEIC = true
+	recorder = serve("photo.heic", heic)
+	assert.Equal(t, `inline; filename="photo.heic"`, recorder.Header().Get("Content-Disposition"))
+}
+
+func TestServeDataPostScript(t *testing.T) {
+	ps := []byte("%!PS-Adobe-3.0\n%%Title: page\n/Helvetica findfont 12 scalefont setfont\nshowpage\n")
+
+	serve := func(name string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, name, int64(len(ps)), bytes.NewReader(ps)))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, ps, recorder.Body.Bytes())
+		return recorder
+	}
+
+	recorder := serve("page.ps")
+	assert.Equal(t, "application/postscript; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="page.ps"`, recorder.Header().Get("Content-Disposition"))
+
+	// even if every type may be displayed
+	defer func(types []string) { setting.UI.InlineContentTypes = types }(setting.UI.InlineContentTypes)
+	setting.UI.InlineContentTypes = []string{"*/*", "application/*"}
+	recorder = serve("figure.eps")
+	assert.Equal(t, `attachment; filename="figure.eps"`, recorder.Header().Get("Content-Disposition"))
+}
+
+func TestServeDataIcon(t *testing.T) {
+	ico := []byte("\x00\x00\x01\x00\x01\x00\x10\x10\x00\x00\x01\x00\x20\x00\x68\x04\x00\x00\x16\x00\x00\x00\x89PNG\r\n\x1a\n")
+
+	ctx, recorder := mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "favicon.ico", int64(len(ico)), bytes.NewReader(ico)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "image/x-icon", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `inline; filename="favicon.ico"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, ico, recorder.Body.Bytes())
+}
+
+func TestServeDataMaxInlineMediaSize(t *testing.T) {
+	defer func(image, pdf int64) {
+		setting.UI.MaxInlineMediaSize.Image = image
+		setting.UI.MaxInlineMediaSize.PDF = pdf
+	}(setting.UI.MaxInlineMediaSize.Image, setting.UI.MaxInlineMediaSize.PDF)
+	png, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+	pdf := []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+
+	disposition := func(name string, content []byte) string {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder.Header().Get("Content-Disposition")
+	}
+
+	kases := []struct {
+		name    string
+		content []byte
+		limit   *int64
+	}{
+		{"image.png", png, &setting.UI.MaxInlineMediaSize.Image},
+		{"document.pdf", pdf, &setting.UI.MaxInlineMediaSize.PDF},
+	}
+	for _, kase := range kases {
+		size := int64(len(kase.content))
+		setting.UI.MaxInlineMediaSize.Image = 0
+		setting.UI.MaxInlineMediaSize.PDF = 0
+		assert.Equal(t, `inline; filename="`+kase.name+`"`, disposition(kase.name, kase.content), kase.name)
+
+		*kase.limit = size
+		assert.Equal(t, `inline; filename="`+kase.name+`"`, disposition(kase.name, kase.content), kase.name)
+		*kase.limit = size - 1
+		assert.Equal(t, `attachment; filename="`+kase.name+`"`, disposition(kase.name, kase.content), kase.name)
+	}
+
+	// each category has a limit of its own
+	setting.UI.MaxInlineMediaSize.Image = 1
+	setting.UI.MaxInlineMediaSize.PDF = 0
+	assert.Equal(t, `inline; filename="document.pdf"`, disposition("document.pdf", pdf))
+	assert.Equal(t, `attachment; filename="image.svg"`, disposition("image.svg", []byte("<svg></svg>")))
+}
+
+func TestServeDataSaveData(t *testing.T) {
+	defer func(size int64) { setting.UI.SaveDataInlineMaxSize = size }(setting.UI.SaveDataInlineMaxSize)
+	png, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+	font := []byte("wOF2\x00\x01\x00\x00")
+
+	serve := func(name string, content []byte, saveData string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		if saveData != "" {
+			ctx.Req.Header.Set("Save-Data", saveData)
+		}
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	setting.UI.SaveDataInlineMaxSize = int64(len(png)) - 1
+	recorder := serve("image.png", png, "")
+	assert.Equal(t, `inline; filename="image.png"`, recorder.Header().Get("Content-Disposition"))
+	assert.Contains(t, recorder.Header().Values("Vary"), "Save-Data")
+
+	recorder = serve("image.png", png, "on")
+	assert.Equal(t, `attachment; filename="image.png"`, recorder.Header().Get("Content-Disposition"))
+	assert.Contains(t, recorder.Header().Values("Vary"), "Save-Data")
+	assert.Equal(t, png, recorder.Body.Bytes())
+
+	// fonts are needed to display pages, they are no previews
+	recorder = serve("font.woff2", font, "on")
+	assert.Equal(t, `inline; filename="font.woff2"`, recorder.Header().Get("Content-Disposition"))
+	assert.NotContains(t, recorder.Header().Values("Vary"), "Save-Data")
+
+	setting.UI.SaveDataInlineMaxSize = int64(len(png))
+	assert.Equal(t, `inline; filename="image.png"`, serve("image.png", png, "on").Header().Get("Content-Disposition"))
+
+	setting.UI.SaveDataInlineMaxSize = 0
+	recorder = serve("image.png", png, "on")
+	assert.Equal(t, `inline; filename="image.png"`, recorder.Header().Get("Content-Disposition"))
+	assert.NotContains(t, recorder.Header().Values("Vary"), "Save-Data")
+}
+
+func TestServeDataNotebook(t *testing.T) {
+	notebook := []byte(`{"cells": [{"cell_type": "markdown", "metadata": {}, "source": ["# Title"]},
+		{"cell_type": "code", "metadata": {}, "source": ["print(1)"], "outputs": [{"output_type": "stream", "text": ["1\n"]}]}],
+		"metadata": {}, "nbformat": 4, "nbformat_minor": 5}`)
+
+	serve := func(content []byte, form ...string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		for _, key := range form {
+			ctx.Req.Form.Set(key, "1")
+		}
+		assert.NoError(t, ServeData(ctx, "dir/notebook.ipynb", int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	recorder := serve(notebook, "render")
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox; frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, `inline; filename="notebook.ipynb"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, strconv.Itoa(recorder.Body.Len()), recorder.Header().Get("Content-Length"))
+	assert.Contains(t, recorder.Body.String(), `<h1 id="user-content-title">Title</h1>`)
+	assert.Contains(t, recorder.Body.String(), `<pre class="input"><code>print(1)</code></pre><pre class="output">1`)
+
+	for _, form := range [][]string{nil, {"render", "download"}} {
+		recorder = serve(notebook, form...)
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, notebook, recorder.Body.Bytes())
+	}
+
+	malformed := []byte(`{"cells": [`)
+	recorder = serve(malformed, "render")
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, malformed, recorder.Body.Bytes())
+}
+
+func TestServeDataMarkdown(t *testing.T) {
+	defer func(size int64) { setting.UI.MaxRenderFileSize = size }(setting.UI.MaxRenderFileSize)
+	content := []byte("# Title\n\nSome *emphasis*.\n\n<script>alert(1)</script>\n\n<a href=\"javascript:alert(1)\">link</a>\n")
+
+	serve := func(name string, form map[string]string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		for k, v := range form {
+			ctx.Req.Form.Set(k, v)
+		}
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	for _, kase := range []struct {
+		name string
+		form map[string]string
+	}{
+		{"README.md", map[string]string{"render": "1"}},
+		{"README.md", map[string]string{"render": "markdown"}},
+		{"README", map[string]string{"render": "markdown"}},
+	} {
+		recorder := serve(kase.name, kase.form)
+		assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"), kase.name)
+		assert.Equal(t, "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox; frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"), kase.name)
+		assert.Equal(t, strconv.Itoa(recorder.Body.Len()), recorder.Header().Get("Content-Length"), kase.name)
+		body := recorder.Body.String()
+		assert.Contains(t, body, `<h1 id="user-content-title">Title</h1>`, kase.name)
+		assert.Contains(t, body, `<em>emphasis</em>`, kase.name)
+		assert.NotContains(t, body, "<script", kase.name)
+		assert.NotContains(t, body, "javascript:", kase.name)
+	}
+
+	// raw text without ?render, with ?download, with ?render=0 and for files which aren't Markdown
+	for _, kase := range []struct {
+		name string
+		form map[string]string
+	}{
+		{"README.md", nil},
+		{"README.md", map[string]string{"render": "1", "download": "1"}},
+		{"README.md", map[string]string{"render": "0"}},
+		{"README.txt", map[string]string{"render": "1"}},
+	} {
+		recorder := serve(kase.name, kase.form)
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"), kase.name)
+		assert.Equal(t, content, recorder.Body.Bytes(), kase.name)
+	}
+
+	// the render size guard applies to Markdown as well
+	setting.UI.MaxRenderFileSize = int64(len(content)) - 1
+	recorder := serve("README.md", map[string]string{"render": "1"})
+	assert.Equal(t, "too-large", recorder.Header().Get("X-Gitea-Render-Skipped"))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+}
+
+func TestServeDataPreloadLinks(t *testing.T) {
+	defer func(assets []string) { setting.UI.PreloadRenderedAssets = assets }(setting.UI.PreloadRenderedAssets)
+	content := []byte("# Title\n")
+
+	serve := func(render string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		if render != "" {
+			ctx.Req.Form.Set("render", render)
+		}
+		assert.NoError(t, ServeData(ctx, "README.md", int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	// nothing is preloaded by default
+	setting.UI.PreloadRenderedAssets = nil
+	recorder := serve("1")
+	assert.Empty(t, recorder.Header().Values("Link"))
+	assert.Equal(t, "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox; frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"))
+
+	setting.UI.PreloadRenderedAssets = []string{"style.css", " fonts/body font.woff2", "logo.png", "data.json", "app.js"}
+	recorder = serve("1")
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	// scripts cannot run in the sandbox, so they aren't preloaded
+	assert.Equal(t, []string{
+		"<style.css>; rel=preload; as=style",
+		"<fonts/body%20font.woff2>; rel=preload; as=font; crossorigin",
+		"<logo.png>; rel=preload; as=image",
+		"<data.json>; rel=preload; as=fetch; crossorigin",
+	}, recorder.Header().Values("Link"))
+	// the policy lets the document use what has been preloaded
+	assert.Equal(t, "default-src 'none'; style-src 'unsafe-inline' 'self'; img-src data: 'self'; font-src 'self'; connect-src 'self'; sandbox; frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"))
+
+	setting.UI.PreloadRenderedAssets = []string{"style.css"}
+	recorder = serve("1")
+	assert.Equal(t, "default-src 'none'; style-src 'unsafe-inline' 'self'; img-src data:; sandbox; frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"))
+
+	// raw text isn't rendered, so it has nothing to preload
+	recorder = serve("")
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Empty(t, recorder.Header().Values("Link"))
+}
+
+func TestServeDataHighlight(t *testing.T) {
+	// the highlighter reads its mapping of file names to languages from the configuration
+	defer func(cfg *ini.File) { setting.Cfg = cfg }(setting.Cfg)
+	setting.Cfg = ini.Empty()
+	defer func(size int64) { setting.UI.MaxRenderFileSize = size }(setting.UI.MaxRenderFileSize)
+	content := []byte("package main\n\n// <script>alert(1)</script>\nfunc main() {}\n")
+
+	serve := func(name string, content []byte, render string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Form.Set("render", render)
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	recorder := serve("main.go", content, "highlight")
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox; frame-ancestors 'self'", recorder.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, strconv.Itoa(recorder.Body.Len()), recorder.Header().Get("Content-Length"))
+	body := recorder.Body.String()
+	assert.Contains(t, body, `<pre class="chroma"><code>`)
+	assert.Contains(t, body, `<span class="kd">func</span>`)
+	assert.Contains(t, body, ".chroma .kd {")
+	assert.NotContains(t, body, "<script")
+
+	// files of unknown languages and binary content are served as they are
+	for name, content := range map[string][]byte{
+		"file.unknown": content,
+		"main.go":      {0x00, 0x01, 0x02, 0x03},
+	} {
+		recorder := serve(name, content, "highlight")
+		assert.NotEqual(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"), name)
+		assert.Equal(t, content, recorder.Body.Bytes(), name)
+	}
+
+	// so is code which is too large to be rendered
+	setting.UI.MaxRenderFileSize = int64(len(content)) - 1
+	recorder = serve("main.go", content, "highlight")
+	assert.Equal(t, "too-large", recorder.Header().Get("X-Gitea-Render-Skipped"))
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, content, recorder.Body.Bytes())
+}
+
+func TestServeDataDigest(t *testing.T) {
+	defer func(enabled bool) { setting.UI.CompressServedContent = enabled }(setting.UI.CompressServedContent)
+	setting.UI.CompressServedContent = true
+
+	content := []byte(strings.Repeat("some digested text\n", 10))
+	sum := sha256.Sum256(content)
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+
+	serve := func(rng, acceptEncoding string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, rng)
+		if acceptEncoding != "" {
+			ctx.Req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		assert.NoError(t, ServeDataWithOptions(ctx, "file.txt", int64(len(content)), bytes.NewReader(content), ServeOptions{Digest: sum[:]}))
+		return recorder
+	}
+
+	recorder := serve("", "")
+	assert.Equal(t, "sha-256=:"+encoded+":", recorder.Header().Get("Repr-Digest"))
+	assert.Equal(t, "sha-256=:"+encoded+":", recorder.Header().Get("Content-Digest"))
+	assert.Equal(t, "SHA-256="+encoded, recorder.Header().Get("Digest"))
+
+	// a part of the content still belongs to the same representation
+	recorder = serve("bytes=0-9", "")
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, "sha-256=:"+encoded+":", recorder.Header().Get("Repr-Digest"))
+	assert.Empty(t, recorder.Header().Get("Content-Digest"))
+
+	recorder = serve("", "gzip")
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.Empty(t, recorder.Header().Get("Repr-Digest"))
+	assert.Empty(t, recorder.Header().Get("Content-Digest"))
+	assert.Empty(t, recorder.Header().Get("Digest"))
+}
+
+func TestServeBlobBackendBusy(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(timeout int) { setting.Git.Timeout.Serve = timeout }(setting.Git.Timeout.Serve)
+	defer func(dataAsync func(*git.Blob) (io.ReadCloser, error)) { blobDataAsync = dataAsync }(blobDataAsync)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+
+	// all git processes are busy until the request has given up
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	blobDataAsync = func(blob *git.Blob) (io.ReadCloser, error) {
+		<-release
+		return closeNotifier{Reader: strings.NewReader("hello\n"), closed: closed}, nil
+	}
+	setting.Git.Timeout.Serve = 1
+
+	assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	assert.NotContains(t, recorder.Body.String(), "hello")
+
+	// the blob opened too late is closed again
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the blob opened after the timeout has not been closed")
+	}
+
+	// a backend which responds in time serves the blob
+	blobDataAsync = (*git.Blob).DataAsync
+	ctx, recorder = mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+	assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello\n", recorder.Body.String())
+}
+
+type closeNotifier struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (c closeNotifier) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestBlobFilename(t *testing.T) {
+	defer func(template string) { setting.Repository.DownloadFilenameTemplate = template }(setting.Repository.DownloadFilenameTemplate)
+
+	ctx, _ := mockServeDataContext(t, "")
+	ctx.Repo = &context.Repository{
+		Repository: &repo_model.Repository{OwnerName: "user2", Name: "repo1"},
+		CommitID:   "65f1bf27bc3bf70f64657658635e66094edbcb4d",
+	}
+
+	setting.Repository.DownloadFilenameTemplate = ""
+	assert.Equal(t, "file.txt", BlobFilename(ctx, "dir/file.txt"))
+
+	setting.Repository.DownloadFilenameTemplate = "{owner}_{repo}-{ref}-{basename}"
+	assert.Equal(t, "user2_repo1-65f1bf27bc-file.txt", BlobFilename(ctx, "dir/file.txt"))
+
+	ctx.Repo.IsViewBranch, ctx.Repo.BranchName = true, "feature/new"
+	assert.Equal(t, "user2_repo1-feature-new-file.txt", BlobFilename(ctx, "dir/file.txt"))
+
+	ctx.Repo.IsViewBranch, ctx.Repo.IsViewTag, ctx.Repo.TagName = false, true, "v1.0"
+	assert.Equal(t, "user2_repo1-v1.0-file.txt", BlobFilename(ctx, "dir/file.txt"))
+
+	// content which doesn't belong to a repository keeps its name
+	ctx.Repo = nil
+	assert.Equal(t, "file.txt", BlobFilename(ctx, "dir/file.txt"))
+}
+
+func TestServeBlobFilenameTemplate(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(template string) { setting.Repository.DownloadFilenameTemplate = template }(setting.Repository.DownloadFilenameTemplate)
+	setting.Repository.DownloadFilenameTemplate = "{repo},{ref},{basename}"
+
+	ctx, recorder := mockServeDataContext(t, "")
+	ctx.Req.Form.Set("download", "1")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+	assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+
+	// the commas of the expanded template are kept like those of any other name
+	expected := fmt.Sprintf(`attachment; filename="repo20 %[1]s hi"; filename*=UTF-8''repo20%%2C%[1]s%%2Chi`, ctx.Repo.BranchName)
+	assert.Equal(t, expected, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "hello\n", recorder.Body.String())
+}
+
+func TestServeBlobMaxETagFileSize(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(size int64) { setting.Repository.MaxETagFileSize = size }(setting.Repository.MaxETagFileSize)
+
+	serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		if len(ifNoneMatch) > 0 {
+			ctx.Req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+		test.LoadRepoCommit(t, ctx)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+		return recorder
+	}
+
+	// the blob is 6 bytes large
+	setting.Repository.MaxETagFileSize = 6
+	recorder := serve("")
+	assert.Equal(t, `"ce013625030ba8dba906f756967f9e9ca394464a"`, recorder.Header().Get("Etag"))
+
+	setting.Repository.MaxETagFileSize = 5
+	recorder = serve("")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Etag"))
+	assert.NotEmpty(t, recorder.Header().Get("Cache-Control"))
+	assert.Equal(t, "hello\n", recorder.Body.String())
+
+	// without an ETag there is nothing the content could be revalidated with
+	recorder = serve(`"ce013625030ba8dba906f756967f9e9ca394464a"`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello\n", recorder.Body.String())
+}
+
+func TestServeBlobDigest(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(enabled bool) { setting.Repository.ServeContentDigest = enabled }(setting.Repository.ServeContentDigest)
+
+	serve := func() *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+		test.LoadRepoCommit(t, ctx)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+		return recorder
+	}
+
+	setting.Repository.ServeContentDigest = false
+	assert.Empty(t, serve().Header().Get("Repr-Digest"))
+
+	setting.Repository.ServeContentDigest = true
+	recorder := serve()
+	sum := sha256.Sum256([]byte("hello\n"))
+	assert.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":", recorder.Header().Get("Repr-Digest"))
+	assert.Equal(t, "hello\n", recorder.Body.String())
+}
+
+func TestServeBlobDigestDeferred(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(enabled bool) { setting.Repository.ServeContentDigest = enabled }(setting.Repository.ServeContentDigest)
+	defer func(size int64) { setting.Repository.MaxContentDigestFileSize = size }(setting.Repository.MaxContentDigestFileSize)
+	defer func(size int64) { setting.Service.MaxDownloadFileSize = size }(setting.Service.MaxDownloadFileSize)
+	defer func(dataAsync func(*git.Blob) (io.ReadCloser, error)) { blobDataAsync = dataAsync }(blobDataAsync)
+	setting.Repository.ServeContentDigest = true
+
+	opened := 0
+	blobDataAsync = func(blob *git.Blob) (io.ReadCloser, error) {
+		opened++
+		return blob.DataAsync()
+	}
+	serve := func(method string) *httptest.ResponseRecorder {
+		opened = 0
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Method = method
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+		test.LoadRepoCommit(t, ctx)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+		return recorder
+	}
+
+	t.Run("TooLargeToDownload", func(t *testing.T) {
+		setting.Service.MaxDownloadFileSize = 3
+		defer func() { setting.Service.MaxDownloadFileSize = 0 }()
+		recorder := serve(http.MethodGet)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Repr-Digest"))
+		// only the content itself has been opened, it hasn't been read to compute the digest
+		assert.Equal(t, 1, opened)
+	})
+
+	t.Run("TooLargeToDigest", func(t *testing.T) {
+		setting.Repository.MaxContentDigestFileSize = 3
+		defer func() { setting.Repository.MaxContentDigestFileSize = 100 << 20 }()
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			recorder := serve(method)
+			assert.Equal(t, http.StatusOK, recorder.Code, method)
+			assert.Empty(t, recorder.Header().Get("Repr-Digest"), method)
+			assert.Equal(t, 1, opened, method)
+		}
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		// without a cache the digest would have to be computed for every HEAD request
+		recorder := serve(http.MethodHead)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Repr-Digest"))
+		assert.Empty(t, recorder.Body.String())
+		assert.Equal(t, 1, opened)
+	})
+
+	t.Run("HeadCached", func(t *testing.T) {
+		assert.NoError(t, cache.NewContext())
+		sum := sha256.Sum256([]byte("hello\n"))
+		digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+
+		// the digest computed for a GET request is cached, a HEAD request gets it without reading the content
+		recorder := serve(http.MethodGet)
+		assert.Equal(t, digest, recorder.Header().Get("Repr-Digest"))
+		recorder = serve(http.MethodHead)
+		assert.Equal(t, digest, recorder.Header().Get("Repr-Digest"))
+		assert.Equal(t, 1, opened)
+	})
+}
+
+func TestServeBlobWantDigest(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+	defer func(enabled bool) { setting.Repository.ServeContentDigest = enabled }(setting.Repository.ServeContentDigest)
+	setting.Repository.ServeContentDigest = true
+
+	serve := func(wantDigest string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Header.Set("Want-Digest", wantDigest)
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+		test.LoadRepoCommit(t, ctx)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, "a/c/hi"))
+		return recorder
+	}
+
+	sum256 := sha256.Sum256([]byte("hello\n"))
+	encoded256 := base64.StdEncoding.EncodeToString(sum256[:])
+	sum512 := sha512.Sum512([]byte("hello\n"))
+	encoded512 := base64.StdEncoding.EncodeToString(sum512[:])
+
+	kases := map[string]string{
+		"sha-256":                    "sha-256",
+		"SHA-512":                    "sha-512",
+		"sha-256;q=0.5, sha-512":     "sha-512",
+		"sha-256, sha-512;q=0.5":     "sha-256",
+		"md5, sha-512;q=0.1":         "sha-512",
+		"md5":                        "sha-256",
+		"sha-512;q=0, unixsum":       "sha-256",
+		"":                           "sha-256",
+		"sha-256;q=0.2, sha-512;q=x": "sha-256",
+	}
+	for wantDigest, algorithm := range kases {
+		recorder := serve(wantDigest)
+		encoded := encoded256
+		if algorithm == "sha-512" {
+			encoded = encoded512
+		}
+		assert.Equal(t, algorithm+"=:"+encoded+":", recorder.Header().Get("Repr-Digest"), wantDigest)
+		assert.Equal(t, algorithm+"=:"+encoded+":", recorder.Header().Get("Content-Digest"), wantDigest)
+		assert.Equal(t, strings.ToUpper(algorithm)+"="+encoded, recorder.Header().Get("Digest"), wantDigest)
+		assert.Contains(t, recorder.Header().Values("Vary"), "Want-Digest", wantDigest)
+		assert.Equal(t, "hello\n", recorder.Body.String(), wantDigest)
+	}
+}
+
+func TestServeDataStripImageMetadata(t *testing.T) {
+	defer func(strip bool) { setting.UI.StripImageMetadata = strip }(setting.UI.StripImageMetadata)
+
+	original := createSampleImage(t, "jpeg", 16, 16)
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08GPS")
+	withExif := append([]byte{}, original[:2]...)
+	withExif = append(withExif, 0xff, 0xe1, 0, byte(len(exif)+2))
+	withExif = append(withExif, exif...)
+	withExif = append(withExif, original[2:]...)
+
+	serve := func(reader io.Reader) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		assert.NoError(t, ServeData(ctx, "image.jpg", int64(len(withExif)), reader))
+		return recorder
+	}
+
+	setting.UI.StripImageMetadata = false
+	recorder := serve(bytes.NewReader(withExif))
+	assert.Equal(t, withExif, recorder.Body.Bytes())
+
+	setting.UI.StripImageMetadata = true
+	for _, reader := range []io.Reader{bytes.NewReader(withExif), io.MultiReader(bytes.NewReader(withExif))} {
+		recorder = serve(reader)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, strconv.Itoa(len(original)), recorder.Header().Get("Content-Length"))
+		assert.NotContains(t, recorder.Body.String(), "Exif")
+		assert.Equal(t, original, recorder.Body.Bytes())
+	}
+
+	// a HEAD request doesn't read the image to strip it, so it gets the size of the stored one
+	ctx, recorder := mockServeDataContext(t, "")
+	ctx.Req.Method = http.MethodHead
+	assert.NoError(t, ServeData(ctx, "image.jpg", int64(len(withExif)), bytes.NewReader(withExif)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, strconv.Itoa(len(withExif)), recorder.Header().Get("Content-Length"))
+	assert.Empty(t, recorder.Body.Bytes())
+
+	// other content is left alone
+	text := []byte("Exif in a text file")
+	ctx, recorder = mockServeDataContext(t, "")
+	assert.NoError(t, ServeData(ctx, "exif.txt", int64(len(text)), bytes.NewReader(text)))
+	assert.Equal(t, text, recorder.Body.Bytes())
+
+	t.Run("Ranges", func(t *testing.T) {
+		// media which isn't stripped, like LFS objects in an object storage, can still serve ranges
+		video := []byte(strings.Repeat("\x00\x00\x00\x18ftypmp42", 100))
+		serve := func(reader io.Reader) *httptest.ResponseRecorder {
+			ctx, recorder := mockServeDataContext(t, "bytes=2-4")
+			assert.NoError(t, ServeData(ctx, "video.mp4", int64(len(video)), reader))
+			assert.Equal(t, http.StatusPartialContent, recorder.Code)
+			assert.Equal(t, video[2:5], recorder.Body.Bytes())
+			return recorder
+		}
+
+		rangeReader := &rangeReader{Reader: readerOnly{bytes.NewReader(video)}, content: video}
+		serve(rangeReader)
+		assert.Equal(t, [][2]int64{{0, 512}, {2, 3}}, rangeReader.read)
+
+		seekReader := &seekReader{reader: bytes.NewReader(video)}
+		serve(seekReader)
+		assert.Equal(t, []int64{0, 2}, seekReader.seeked)
+	})
+}
+
+// commitTestRepo creates a git repository with a single commit of the given files and points ctx.Repo at it
+func commitTestRepo(t *testing.T, ctx *context.Context, files map[string]string) *git.Commit {
+	repoPath := t.TempDir()
+	assert.NoError(t, git.InitRepository(gocontext.Background(), repoPath, false))
+	for name, content := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Join(repoPath, filepath.Dir(name)), os.ModePerm))
+		assert.NoError(t, os.WriteFile(filepath.Join(repoPath, name), []byte(content), 0o644))
+	}
+	assert.NoError(t, git.AddChanges(repoPath, true))
+	signature := &git.Signature{Name: "User Two", Email: "user2@example.com", When: time.Now()}
+	assert.NoError(t, git.CommitChanges(repoPath, git.CommitChangesOptions{Committer: signature, Author: signature, Message: "files"}))
+
+	gitRepo, err := git.OpenRepository(repoPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { gitRepo.Close() })
+	commit, err := gitRepo.GetBranchCommit("master")
+	assert.NoError(t, err)
+	ctx.Repo.GitRepo = gitRepo
+	ctx.Repo.Commit = commit
+	ctx.Repo.CommitID = commit.ID.String()
+	return commit
+}
+
+func TestServeBlobTextAttribute(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, _ := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	binary := "\x00\x01\x02\x03{}"
+	commit := commitTestRepo(t, ctx, map[string]string{
+		".gitattributes": "*.gen text\n*.min -text\n*.blob binary\n",
+		"data.gen":       binary,
+		"page.min":       "hello\n",
+		"page.blob":      "hello\n",
+		"page.txt":       "hello\n",
+	})
+	defer blobAttributesCache.Purge()
+	blobAttributesCache.Purge()
+
+	serve := func(treePath string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, commit, treePath))
+		return recorder
+	}
+
+	kases := map[string]struct {
+		contentType string
+		disposition string
+	}{
+		// marked as text although it would be sniffed as binary
+		"data.gen": {"text/plain; charset=utf-8", "inline"},
+		// marked as binary, they are downloaded and have no charset
+		"page.min":  {"text/plain", "attachment"},
+		"page.blob": {"text/plain", "attachment"},
+		"page.txt":  {"text/plain; charset=utf-8", "inline"},
+	}
+	for treePath, kase := range kases {
+		recorder := serve(treePath)
+		assert.Equal(t, http.StatusOK, recorder.Code, treePath)
+		assert.Equal(t, kase.contentType, recorder.Header().Get("Content-Type"), treePath)
+		assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Disposition"), kase.disposition), treePath)
+		assert.True(t, blobAttributesCache.Contains(blobAttributesKey{repoID: 31, commitID: commit.ID.String(), treePath: treePath}), treePath)
+	}
+
+	t.Run("Revalidated", func(t *testing.T) {
+		blobAttributesCache.Purge()
+		blob, err := commit.GetBlobByPath("page.min")
+		assert.NoError(t, err)
+		ctx.Req.Header.Set("If-None-Match", BlobETag(ctx, blob.ID.String()))
+		defer ctx.Req.Header.Del("If-None-Match")
+
+		recorder := serve("page.min")
+		assert.Equal(t, http.StatusNotModified, recorder.Code)
+		// the attributes are not needed to tell the client its copy is fresh
+		assert.Zero(t, blobAttributesCache.Len())
+	})
+}
+
+func TestServeBlobMimeTypeAttribute(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	binary := "\x00\x01\x02\x03{}"
+	commit := commitTestRepo(t, ctx, map[string]string{
+		".gitattributes": "*.dat gitea-mime-type=application/json\n*.bad gitea-mime-type=not/a/type\n*.txt gitea-mime-type=text/html\n",
+		"data.dat":       binary,
+		"data.bin":       binary,
+		"data.bad":       binary,
+		"page.txt":       "<script>alert(1)</script>",
+	})
+
+	serve := func(treePath string) {
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, commit, treePath))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, binary, recorder.Body.String())
+	}
+
+	t.Run("Declared", func(t *testing.T) {
+		// the content would be sniffed as binary
+		serve("data.dat")
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("MimeTypeMapping", func(t *testing.T) {
+		defer func(enabled bool, m map[string]string) {
+			setting.MimeTypeMap.Enabled = enabled
+			setting.MimeTypeMap.Map = m
+		}(setting.MimeTypeMap.Enabled, setting.MimeTypeMap.Map)
+		setting.MimeTypeMap.Enabled = true
+		setting.MimeTypeMap.Map = map[string]string{".dat": "application/x-dat"}
+		serve("data.dat")
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("Undeclared", func(t *testing.T) {
+		serve("data.bin")
+		assert.Equal(t, "application/octet-stream", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		serve("data.bad")
+		assert.Equal(t, "application/octet-stream", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("Scriptable", func(t *testing.T) {
+		// pushing a .gitattributes must not be enough to run scripts on the origin of Gitea
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, commit, "page.txt"))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"path"
+	"strings"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/util"
+)
+
+// maxSymlinkHops is the number of symbolic links followed before a chain of them is considered a loop
+const maxSymlinkHops = 10
+
+// resolveSymlink returns the path of the regular file the symbolic link at treePath ultimately points to,
+// or an empty path if it points outside the repository, to a directory or to nothing
+func resolveSymlink(commit *git.Commit, treePath string, entry *git.TreeEntry) (string, error) {
+	for i := 0; i < maxSymlinkHops; i++ {
+		link, err := entry.Blob().GetBlobContent()
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(link) {
+			return "", nil
+		}
+		treePath = path.Join(path.Dir(treePath), link)
+		if treePath == ".." || strings.HasPrefix(treePath, "../") {
+			return "", nil
+		}
+		entry, err = commit.GetTreeEntryByPath(treePath)
+		if err != nil {
+			if git.IsErrNotExist(err) {
+				return "", nil
+			}
+			return "", err
+		}
+		if entry.IsRegular() || entry.IsExecutable() {
+			return treePath, nil
+		}
+		if !entry.IsLink() {
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+// ServeSymlink serves the symbolic link entry found at treePath in the commit with the X-Gitea-Symlink header.
+// Depending on SERVE_SYMLINKS it either redirects to the file the link points to or serves the path it points
+// to as plain text, which is also done for a link which cannot be resolved to a file of the repository.
+func ServeSymlink(ctx *context.Context, commit *git.Commit, treePath string, entry *git.TreeEntry, opts ServeOptions) error {
+	ctx.Resp.Header().Set("X-Gitea-Symlink", "true")
+	ctx.Resp.Header().Add("Access-Control-Expose-Headers", "X-Gitea-Symlink")
+
+	if setting.Repository.ServeSymlinks == "redirect" {
+		target, err := resolveSymlink(commit, treePath, entry)
+		if err != nil {
+			return err
+		}
+		if len(target) > 0 {
+			link := symlinkTargetLink(ctx, commit, target)
+			if len(ctx.Req.URL.RawQuery) > 0 {
+				link += "?" + ctx.Req.URL.RawQuery
+			}
+			ctx.Redirect(link)
+			return nil
+		}
+	}
+
+	// the target is served as is, whatever it looks like
+	opts.ContentType = "text/plain; charset=utf-8"
+	opts.Text = util.OptionalBoolTrue
+	if opts.LastModified.IsZero() {
+		opts.LastModified = commit.Committer.When
+	}
+	return serveBlob(ctx, entry.Blob(), commit.ID.String(), treePath, opts)
+}
+
+// symlinkTargetLink returns the link to the raw file at target in the ref the symbolic link has been requested in,
+// through /media if the link has been requested through it. Links requested through the API, which has no routes
+// for refs, are redirected to the raw file in the commit. The API doesn't set the RepoLink of the context,
+// so the link of the repository is taken from the repository itself.
+func symlinkTargetLink(ctx *context.Context, commit *git.Commit, target string) string {
+	repoLink := ctx.Repo.Repository.Link()
+	route := "/raw/"
+	if strings.HasPrefix(ctx.Req.URL.Path, repoLink+"/media/") {
+		route = "/media/"
+	}
+	ref := "commit/" + util.PathEscapeSegments(commit.ID.String())
+	if ctx.Repo.IsViewBranch || ctx.Repo.IsViewTag || ctx.Repo.IsViewCommit {
+		ref = ctx.Repo.BranchNameSubURL()
+	}
+	return repoLink + route + ref + "/" + util.PathEscapeSegments(target)
+}
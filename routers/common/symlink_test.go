@@ -0,0 +1,109 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeSymlink(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	ctx, recorder := mockServeDataContext(t, "")
+	test.LoadRepo(t, ctx, 31)
+	test.LoadGitRepo(t, ctx)
+	defer ctx.Repo.GitRepo.Close()
+	test.LoadRepoCommit(t, ctx)
+	ctx.Repo.IsViewBranch = true
+	ctx.Repo.BranchName = "master"
+
+	serveURL := func(rawURL, treePath string) {
+		var err error
+		ctx.Req.URL, err = url.Parse(rawURL)
+		assert.NoError(t, err)
+		recorder = httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		assert.NoError(t, ServeBlobByPath(ctx, ctx.Repo.Commit, treePath))
+	}
+	serve := func(treePath, query string) {
+		serveURL("/user2/repo20/raw/branch/master/"+treePath+query, treePath)
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		serve("a/b/link_hi", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "true", recorder.Header().Get("X-Gitea-Symlink"))
+		assert.Contains(t, recorder.Header().Values("Access-Control-Expose-Headers"), "X-Gitea-Symlink")
+		assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "../c/hi", recorder.Body.String())
+	})
+
+	t.Run("Regular", func(t *testing.T) {
+		serve("a/c/hi", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("X-Gitea-Symlink"))
+		assert.Equal(t, "hello\n", recorder.Body.String())
+	})
+
+	defer func(mode string) { setting.Repository.ServeSymlinks = mode }(setting.Repository.ServeSymlinks)
+	setting.Repository.ServeSymlinks = "redirect"
+
+	t.Run("Redirect", func(t *testing.T) {
+		kases := map[string]string{
+			"a/b/link_hi": "/user2/repo20/raw/branch/master/a/c/hi?download=1",
+			"link_hi":     "/user2/repo20/raw/branch/master/a/c/hi?download=1",
+		}
+		for treePath, location := range kases {
+			serve(treePath, "?download=1")
+			assert.Equal(t, http.StatusFound, recorder.Code, treePath)
+			assert.Equal(t, "true", recorder.Header().Get("X-Gitea-Symlink"), treePath)
+			assert.Equal(t, location, recorder.Header().Get("Location"), treePath)
+		}
+	})
+
+	t.Run("RedirectRoutes", func(t *testing.T) {
+		// the link is requested through the deprecated route, with an escaped path the target isn't escaped like
+		serveURL("/user2/repo20/raw/a/b/%6Cink_hi", "a/b/link_hi")
+		assert.Equal(t, http.StatusFound, recorder.Code)
+		assert.Equal(t, "/user2/repo20/raw/branch/master/a/c/hi", recorder.Header().Get("Location"))
+
+		serveURL("/user2/repo20/media/branch/master/link_hi", "link_hi")
+		assert.Equal(t, "/user2/repo20/media/branch/master/a/c/hi", recorder.Header().Get("Location"))
+
+		// the API has no routes for refs, the file is linked in the commit
+		ctx.Repo.IsViewBranch = false
+		defer func() { ctx.Repo.IsViewBranch = true }()
+		serveURL("/api/v1/repos/user2/repo20/raw/link_hi?ref=master", "link_hi")
+		assert.Equal(t, "/user2/repo20/raw/commit/"+ctx.Repo.Commit.ID.String()+"/a/c/hi?ref=master", recorder.Header().Get("Location"))
+	})
+
+	t.Run("Unresolvable", func(t *testing.T) {
+		kases := map[string]string{
+			// broken
+			"link_d": "a/d",
+			// outside of the repository
+			"a/link_annex": "../.git/annex/objects/aaa/bbb/ccc",
+			// a directory, through another link
+			"link_link":  "link_b",
+			"a/b/link_c": "../c",
+		}
+		for treePath, link := range kases {
+			serve(treePath, "")
+			assert.Equal(t, http.StatusOK, recorder.Code, treePath)
+			assert.Equal(t, "true", recorder.Header().Get("X-Gitea-Symlink"), treePath)
+			assert.Equal(t, link, recorder.Body.String(), treePath)
+		}
+	})
+}
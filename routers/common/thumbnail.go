@@ -0,0 +1,137 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif" // for processing gif images
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/nfnt/resize"
+)
+
+// maxThumbnailSourcePixels limits the images which are decoded to create thumbnails,
+// a small file may still claim dimensions which need gigabytes of memory once it is decoded.
+// An image of this size takes 64MB decoded as RGBA.
+const maxThumbnailSourcePixels = 16 * 1000 * 1000
+
+// maxConcurrentThumbnails is the number of images which may be decoded to create thumbnails at the same time
+const maxConcurrentThumbnails = 4
+
+// thumbnailSemaphore holds a token for each image being decoded to create a thumbnail
+var thumbnailSemaphore = make(chan struct{}, maxConcurrentThumbnails)
+
+// errThumbnailsBusy is returned when maxConcurrentThumbnails images are being decoded already
+var errThumbnailsBusy = errors.New("too many thumbnails are being created")
+
+// thumbnailSize returns the bounding box of the thumbnail requested with ?thumb=WxH, reduced to the
+// configured maximum dimensions. It returns false if no thumbnail or one of an invalid size is requested.
+func thumbnailSize(ctx *context.Context) (width, height int, ok bool) {
+	thumb := ctx.FormString("thumb")
+	if len(thumb) == 0 {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.ToLower(thumb), "x", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return 0, 0, false
+	}
+	height, err = strconv.Atoi(parts[1])
+	if err != nil || height <= 0 {
+		return 0, 0, false
+	}
+	if width > setting.UI.Thumbnail.MaxWidth {
+		width = setting.UI.Thumbnail.MaxWidth
+	}
+	if height > setting.UI.Thumbnail.MaxHeight {
+		height = setting.UI.Thumbnail.MaxHeight
+	}
+	return width, height, true
+}
+
+// createThumbnail scales the image down to fit into width x height, keeping its aspect ratio. The thumbnail
+// of a JPEG image is a JPEG image, that of any other image a PNG image. It returns false if the content
+// isn't an image which can be decoded, and errThumbnailsBusy if too many images are being decoded already.
+func createThumbnail(content []byte, width, height int) ([]byte, string, bool, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxThumbnailSourcePixels {
+		return nil, "", false, nil
+	}
+
+	select {
+	case thumbnailSemaphore <- struct{}{}:
+		defer func() { <-thumbnailSemaphore }()
+	default:
+		return nil, "", false, errThumbnailsBusy
+	}
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		log.Debug("createThumbnail: unable to decode %s image: %v", format, err)
+		return nil, "", false, nil
+	}
+
+	// images which are already small enough are not enlarged
+	thumbnail := resize.Thumbnail(uint(width), uint(height), img, resize.Bilinear)
+
+	var buf bytes.Buffer
+	mimeType := "image/png"
+	if format == "jpeg" {
+		mimeType = "image/jpeg"
+		err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumbnail)
+	}
+	if err != nil {
+		log.Error("createThumbnail: unable to encode thumbnail: %v", err)
+		return nil, "", false, nil
+	}
+	return buf.Bytes(), mimeType, true, nil
+}
+
+// serveThumbnail serves a thumbnail of content which fits into width x height if the content is an image,
+// or responds 503 Service Unavailable if too many thumbnails are being created. It returns false without
+// writing anything if the content isn't an image.
+func serveThumbnail(ctx *context.Context, name string, content []byte, width, height int, opts ServeOptions) bool {
+	thumbnail, mimeType, ok, err := createThumbnail(content, width, height)
+	if err == errThumbnailsBusy {
+		log.Debug("serveThumbnail: %v, refusing to create a thumbnail of %s", err, name)
+		ctx.Resp.Header().Set("Retry-After", "1")
+		ctx.Error(http.StatusServiceUnavailable)
+		return true
+	}
+	if !ok {
+		return false
+	}
+
+	ctx.Resp.Header().Set("Content-Type", mimeType)
+	ctx.Resp.Header().Set("Content-Length", strconv.Itoa(len(thumbnail)))
+	ctx.Resp.Header().Set("X-Content-Type-Options", "nosniff")
+	ctx.Resp.Header().Set("Content-Disposition", contentDisposition("inline", opts.filename(name)))
+	setFrameAncestors(ctx.Resp.Header())
+	ctx.Resp.Header().Set("Cache-Control", opts.cacheControl(name, mimeType))
+	// ranges of the original image don't apply to its thumbnail
+	ctx.Resp.Header().Set("Accept-Ranges", "none")
+	ctx.Status(http.StatusOK)
+	if ctx.Req.Method == http.MethodHead {
+		return true
+	}
+	if _, err := ctx.Resp.Write(thumbnail); err != nil {
+		log.Error("serveThumbnail: unable to write thumbnail of %s: %v", name, err)
+	}
+	return true
+}
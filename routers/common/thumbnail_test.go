@@ -0,0 +1,153 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.gitea.io/gitea/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createSampleImage(t *testing.T, format string, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		assert.NoError(t, jpeg.Encode(&buf, img, nil))
+	} else {
+		assert.NoError(t, png.Encode(&buf, img))
+	}
+	return buf.Bytes()
+}
+
+func TestThumbnailSize(t *testing.T) {
+	defer func(width, height int) {
+		setting.UI.Thumbnail.MaxWidth = width
+		setting.UI.Thumbnail.MaxHeight = height
+	}(setting.UI.Thumbnail.MaxWidth, setting.UI.Thumbnail.MaxHeight)
+	setting.UI.Thumbnail.MaxWidth = 200
+	setting.UI.Thumbnail.MaxHeight = 100
+
+	kases := map[string][2]int{
+		"64x32":   {64, 32},
+		"64X32":   {64, 32},
+		"500x500": {200, 100},
+		"":        {0, 0},
+		"64":      {0, 0},
+		"64x":     {0, 0},
+		"0x32":    {0, 0},
+		"-1x32":   {0, 0},
+		"axb":     {0, 0},
+	}
+	for thumb, expected := range kases {
+		ctx, _ := mockServeDataContext(t, "")
+		ctx.Req.Form.Set("thumb", thumb)
+		width, height, ok := thumbnailSize(ctx)
+		assert.Equal(t, expected[0] > 0, ok, thumb)
+		assert.Equal(t, expected, [2]int{width, height}, thumb)
+	}
+}
+
+func TestServeDataThumbnail(t *testing.T) {
+	serve := func(name string, content []byte, thumb string) *httptest.ResponseRecorder {
+		ctx, recorder := mockServeDataContext(t, "")
+		ctx.Req.Form.Set("thumb", thumb)
+		assert.NoError(t, ServeData(ctx, name, int64(len(content)), bytes.NewReader(content)))
+		return recorder
+	}
+
+	for _, format := range []string{"png", "jpeg"} {
+		original := createSampleImage(t, format, 100, 50)
+		recorder := serve("image."+format, original, "20x20")
+		assert.Equal(t, http.StatusOK, recorder.Code, format)
+		assert.Equal(t, "image/"+format, recorder.Header().Get("Content-Type"), format)
+		assert.Equal(t, "none", recorder.Header().Get("Accept-Ranges"), format)
+
+		thumbnail, decodedFormat, err := image.Decode(bytes.NewReader(recorder.Body.Bytes()))
+		assert.NoError(t, err, format)
+		assert.Equal(t, format, decodedFormat)
+		// the aspect ratio is kept
+		assert.Equal(t, image.Rect(0, 0, 20, 10), thumbnail.Bounds(), format)
+		assert.Less(t, recorder.Body.Len(), len(original), format)
+	}
+
+	// images are never enlarged
+	original := createSampleImage(t, "png", 10, 10)
+	recorder := serve("small.png", original, "20x20")
+	thumbnail, _, err := image.Decode(bytes.NewReader(recorder.Body.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 10), thumbnail.Bounds())
+
+	// content which isn't an image ignores the parameter
+	text := []byte("not an image")
+	recorder = serve("file.txt", text, "20x20")
+	assert.Equal(t, "text/plain; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, text, recorder.Body.Bytes())
+
+	// so does an image which is too large
+	defer func(size int64) { setting.UI.Thumbnail.MaxSourceSize = size }(setting.UI.Thumbnail.MaxSourceSize)
+	original = createSampleImage(t, "png", 100, 50)
+	setting.UI.Thumbnail.MaxSourceSize = int64(len(original)) - 1
+	recorder = serve("image.png", original, "20x20")
+	assert.Equal(t, original, recorder.Body.Bytes())
+	setting.UI.Thumbnail.MaxSourceSize = int64(len(original))
+
+	t.Run("TooManyPixels", func(t *testing.T) {
+		// a small file which claims to be a huge image isn't decoded
+		header := []byte("\x89PNG\r\n\x1a\n")
+		ihdr := []byte("IHDR\x00\x00\x10\x00\x00\x00\x10\x00\x08\x06\x00\x00\x00")
+		header = append(header, 0, 0, 0, 13)
+		header = append(header, ihdr...)
+		crc := make([]byte, 4)
+		binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(ihdr))
+		header = append(header, crc...)
+		recorder := serve("huge.png", header, "20x20")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, header, recorder.Body.Bytes())
+	})
+
+	t.Run("Busy", func(t *testing.T) {
+		for i := 0; i < maxConcurrentThumbnails; i++ {
+			thumbnailSemaphore <- struct{}{}
+		}
+		defer func() {
+			for i := 0; i < maxConcurrentThumbnails; i++ {
+				<-thumbnailSemaphore
+			}
+		}()
+
+		recorder := serve("image.png", original, "20x20")
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+
+		// content which isn't an image is still served
+		recorder = serve("file.txt", text, "20x20")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, text, recorder.Body.Bytes())
+	})
+}
+
+func TestBlobETagThumbnail(t *testing.T) {
+	ctx, _ := mockServeDataContext(t, "")
+	assert.Equal(t, `"ce013625030ba8dba906f756967f9e9ca394464a"`, BlobETag(ctx, "ce013625030ba8dba906f756967f9e9ca394464a"))
+	ctx.Req.Form.Set("thumb", "64x32")
+	// the image is encoded anew, which need not result in the same bytes every time
+	assert.Equal(t, `W/"ce013625030ba8dba906f756967f9e9ca394464a-thumb-64x32"`, BlobETag(ctx, "ce013625030ba8dba906f756967f9e9ca394464a"))
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// throttledWriter limits the rate at which data is written to the underlying writer
+type throttledWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	rate    int64 // bytes per second
+	start   time.Time
+	written int64
+}
+
+// newThrottledWriter returns a writer writing at most rate bytes per second to w.
+// Writes waiting for their turn are aborted once ctx is done.
+func newThrottledWriter(ctx context.Context, w io.Writer, rate int64) *throttledWriter {
+	return &throttledWriter{
+		ctx:   ctx,
+		w:     w,
+		rate:  rate,
+		start: time.Now(),
+	}
+}
+
+func (t *throttledWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		// write at most a second worth of data at once to keep the output smooth
+		chunk := p
+		if int64(len(chunk)) > t.rate {
+			chunk = chunk[:t.rate]
+		}
+		written, err := t.w.Write(chunk)
+		n += written
+		t.written += int64(written)
+		if err != nil {
+			return n, err
+		}
+		p = p[written:]
+
+		due := t.start.Add(time.Duration(float64(t.written) / float64(t.rate) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-t.ctx.Done():
+				timer.Stop()
+				return n, t.ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	return n, nil
+}
+
+// contextWriter refuses to write to the underlying writer once ctx is done
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c *contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottledWriter(t *testing.T) {
+	var buf bytes.Buffer
+	tw := newThrottledWriter(context.Background(), &buf, 1000)
+
+	start := time.Now()
+	n, err := tw.Write(bytes.Repeat([]byte("x"), 1500))
+	elapsed := time.Since(start)
+	assert.NoError(t, err)
+	assert.Equal(t, 1500, n)
+	assert.Equal(t, 1500, buf.Len())
+	// 1500 bytes at 1000 bytes per second take about 1.5 seconds, allow for a slow test machine
+	assert.GreaterOrEqual(t, elapsed, 1400*time.Millisecond)
+	assert.Less(t, elapsed, 5*time.Second)
+}
+
+func TestThrottledWriterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	var buf bytes.Buffer
+	tw := newThrottledWriter(ctx, &buf, 100)
+
+	start := time.Now()
+	n, err := tw.Write(bytes.Repeat([]byte("x"), 1000))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 100, n)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestContextWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var buf bytes.Buffer
+	cw := &contextWriter{ctx: ctx, w: &buf}
+	n, err := cw.Write([]byte("before"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+
+	cancel()
+	n, err = cw.Write([]byte("after"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, n)
+	assert.Equal(t, "before", buf.String())
+}
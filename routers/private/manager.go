@@ -94,7 +94,7 @@ func AddLogger(ctx *context.PrivateContext) {
 		switch opts.Group {
 		case "access":
 			opts.Config["flags"] = log.FlagsFromString("")
-		case "router":
+		case "router", "download_audit":
 			opts.Config["flags"] = log.FlagsFromString("date,time")
 		default:
 			opts.Config["flags"] = log.FlagsFromString("stdflags")
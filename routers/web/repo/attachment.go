@@ -144,8 +144,10 @@ func GetAttachment(ctx *context.Context) {
 	}
 	defer fr.Close()
 
-	if err = common.ServeData(ctx, attach.Name, attach.Size, fr); err != nil {
-		ctx.ServerError("ServeData", err)
+	// an attachment cannot be changed once it is uploaded, unlike a file of a branch
+	opts := common.ServeOptions{Immutable: true, LastModified: attach.CreatedUnix.AsTime()}
+	if err = common.ServeAttachmentWithOptions(ctx, attach.Name, attach.Size, fr, opts); err != nil {
+		ctx.ServerError("ServeAttachment", err)
 		return
 	}
 }
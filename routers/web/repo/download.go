@@ -6,10 +6,11 @@
 package repo
 
 import (
+	"encoding/hex"
+
 	"code.gitea.io/gitea/models"
 	"code.gitea.io/gitea/modules/context"
 	"code.gitea.io/gitea/modules/git"
-	"code.gitea.io/gitea/modules/httpcache"
 	"code.gitea.io/gitea/modules/lfs"
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/setting"
@@ -18,8 +19,8 @@ import (
 )
 
 // ServeBlobOrLFS download a git.Blob redirecting to LFS if necessary
-func ServeBlobOrLFS(ctx *context.Context, blob *git.Blob) error {
-	if httpcache.HandleGenericETagCache(ctx.Req, ctx.Resp, `"`+blob.ID.String()+`"`) {
+func ServeBlobOrLFS(ctx *context.Context, blob *git.Blob, opts common.ServeOptions) error {
+	if common.HandleBlobETagCache(ctx, blob.ID.String(), blob.Size()) {
 		return nil
 	}
 
@@ -45,9 +46,9 @@ func ServeBlobOrLFS(ctx *context.Context, blob *git.Blob) error {
 				log.Error("ServeBlobOrLFS: Close: %v", err)
 			}
 			closed = true
-			return common.ServeBlob(ctx, blob)
+			return common.ServeBlobWithOptions(ctx, blob, opts)
 		}
-		if httpcache.HandleGenericETagCache(ctx.Req, ctx.Resp, `"`+pointer.Oid+`"`) {
+		if common.HandleBlobETagCache(ctx, pointer.Oid, meta.Size) {
 			return nil
 		}
 
@@ -60,7 +61,7 @@ func ServeBlobOrLFS(ctx *context.Context, blob *git.Blob) error {
 			}
 		}
 
-		lfsDataRc, err := lfs.ReadMetaObject(meta.Pointer)
+		lfsDataRc, err := lfs.ReadMetaObjectRanges(meta.Pointer)
 		if err != nil {
 			return err
 		}
@@ -69,45 +70,83 @@ func ServeBlobOrLFS(ctx *context.Context, blob *git.Blob) error {
 				log.Error("ServeBlobOrLFS: Close: %v", err)
 			}
 		}()
-		return common.ServeData(ctx, ctx.Repo.TreePath, meta.Size, lfsDataRc)
+		if setting.Repository.ServeContentDigest && len(opts.Digest) == 0 {
+			// the oid of an LFS object is the SHA-256 of its content, any other digest asked for
+			// with Want-Digest would require the whole object to be read in advance
+			opts.Digest, _ = hex.DecodeString(pointer.Oid)
+		}
+		if len(opts.Filename) == 0 {
+			opts.Filename = common.BlobFilename(ctx, ctx.Repo.TreePath)
+		}
+		// ranges of large media are read on their own, an object storage only transfers the parts asked for
+		if err := common.ServeDataWithOptions(ctx, ctx.Repo.TreePath, meta.Size, lfsDataRc, opts); err != nil {
+			return err
+		}
+		// the pointer identifies the content like a blob would
+		common.AuditDownload(ctx, blob.ID.String(), ctx.Repo.TreePath, meta.Size)
+		return nil
 	}
 	if err = dataRc.Close(); err != nil {
 		log.Error("ServeBlobOrLFS: Close: %v", err)
 	}
 	closed = true
 
-	return common.ServeBlob(ctx, blob)
+	return common.ServeBlobWithOptions(ctx, blob, opts)
+}
+
+// isPinnedCommit returns whether the file is referenced by the full id of a commit, which
+// unlike a branch, a tag or an abbreviated id can never point to different content
+func isPinnedCommit(ctx *context.Context) bool {
+	return ctx.Repo.IsViewCommit && ctx.Repo.Commit != nil && ctx.Repo.CommitID == ctx.Repo.Commit.ID.String()
 }
 
 // SingleDownload download a file by repos path
 func SingleDownload(ctx *context.Context) {
-	blob, err := ctx.Repo.Commit.GetBlobByPath(ctx.Repo.TreePath)
-	if err != nil {
-		if git.IsErrNotExist(err) {
-			ctx.NotFound("GetBlobByPath", nil)
-		} else {
-			ctx.ServerError("GetBlobByPath", err)
+	if ctx.FormBool("session") {
+		if err := common.RespondDownloadSession(ctx, ctx.Repo.Commit, ctx.Repo.TreePath, ctx.Repo.Repository.HTMLURL()+"/raw/session/"); err != nil {
+			ctx.ServerError("RespondDownloadSession", err)
 		}
 		return
 	}
-	if err = common.ServeBlob(ctx, blob); err != nil {
-		ctx.ServerError("ServeBlob", err)
+	opts := common.ServeOptions{Immutable: isPinnedCommit(ctx)}
+	if err := common.ServeBlobByPathWithOptions(ctx, ctx.Repo.Commit, ctx.Repo.TreePath, opts); err != nil {
+		ctx.ServerError("ServeBlobByPath", err)
 	}
 }
 
 // SingleDownloadOrLFS download a file by repos path redirecting to LFS if necessary
 func SingleDownloadOrLFS(ctx *context.Context) {
-	blob, err := ctx.Repo.Commit.GetBlobByPath(ctx.Repo.TreePath)
+	if ctx.FormBool("session") {
+		if err := common.RespondDownloadSession(ctx, ctx.Repo.Commit, ctx.Repo.TreePath, ctx.Repo.Repository.HTMLURL()+"/media/session/"); err != nil {
+			ctx.ServerError("RespondDownloadSession", err)
+		}
+		return
+	}
+	entry, err := ctx.Repo.Commit.GetTreeEntryByPath(ctx.Repo.TreePath)
 	if err != nil {
 		if git.IsErrNotExist(err) {
-			ctx.NotFound("GetBlobByPath", nil)
+			ctx.NotFound("GetTreeEntryByPath", nil)
 		} else {
-			ctx.ServerError("GetBlobByPath", err)
+			ctx.ServerError("GetTreeEntryByPath", err)
 		}
 		return
 	}
-	if err = ServeBlobOrLFS(ctx, blob); err != nil {
-		ctx.ServerError("ServeBlobOrLFS", err)
+	opts := common.ServeOptions{Immutable: isPinnedCommit(ctx)}
+	switch {
+	case entry.IsDir() && ctx.FormBool("list"):
+		if err = common.ServeDirectoryListing(ctx, ctx.Repo.Commit, ctx.Repo.TreePath); err != nil {
+			ctx.ServerError("ServeDirectoryListing", err)
+		}
+	case entry.IsDir() || entry.IsSubModule():
+		ctx.NotFound("GetTreeEntryByPath", nil)
+	case entry.IsLink():
+		if err = common.ServeSymlink(ctx, ctx.Repo.Commit, ctx.Repo.TreePath, entry, opts); err != nil {
+			ctx.ServerError("ServeSymlink", err)
+		}
+	default:
+		if err = ServeBlobOrLFS(ctx, entry.Blob(), opts); err != nil {
+			ctx.ServerError("ServeBlobOrLFS", err)
+		}
 	}
 }
 
@@ -122,7 +161,7 @@ func DownloadByID(ctx *context.Context) {
 		}
 		return
 	}
-	if err = common.ServeBlob(ctx, blob); err != nil {
+	if err = common.ServeBlobWithOptions(ctx, blob, common.ServeOptions{Immutable: true}); err != nil {
 		ctx.ServerError("ServeBlob", err)
 	}
 }
@@ -138,7 +177,35 @@ func DownloadByIDOrLFS(ctx *context.Context) {
 		}
 		return
 	}
-	if err = ServeBlobOrLFS(ctx, blob); err != nil {
+	if err = ServeBlobOrLFS(ctx, blob, common.ServeOptions{Immutable: true}); err != nil {
 		ctx.ServerError("ServeBlob", err)
 	}
 }
+
+// DownloadBySession download the file pinned by a download session token
+func DownloadBySession(ctx *context.Context) {
+	blob, err := common.LoadDownloadSession(ctx, ctx.Params("token"))
+	if err != nil {
+		ctx.ServerError("LoadDownloadSession", err)
+		return
+	} else if blob == nil {
+		return
+	}
+	if err = common.ServeDownloadSession(ctx, blob, common.ServeOptions{Immutable: true}); err != nil {
+		ctx.ServerError("ServeDownloadSession", err)
+	}
+}
+
+// DownloadBySessionOrLFS download the file pinned by a download session token taking account of LFS
+func DownloadBySessionOrLFS(ctx *context.Context) {
+	blob, err := common.LoadDownloadSession(ctx, ctx.Params("token"))
+	if err != nil {
+		ctx.ServerError("LoadDownloadSession", err)
+		return
+	} else if blob == nil {
+		return
+	}
+	if err = ServeBlobOrLFS(ctx, blob, common.ServeOptions{Immutable: true}); err != nil {
+		ctx.ServerError("ServeBlobOrLFS", err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 The Gitea Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSingleDownloadCacheControl(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	download := func(ref string) *httptest.ResponseRecorder {
+		ctx := test.MockContext(t, "user2/repo20/raw/"+ref+"/a/c/hi")
+		recorder := httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		ctx.Req.Header = make(http.Header)
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		defer ctx.Repo.GitRepo.Close()
+		test.LoadRepoCommit(t, ctx)
+		ctx.Repo.TreePath = "a/c/hi"
+		switch ref {
+		case "full":
+			ctx.Repo.IsViewCommit = true
+			ctx.Repo.CommitID = ctx.Repo.Commit.ID.String()
+		case "short":
+			ctx.Repo.IsViewCommit = true
+			ctx.Repo.CommitID = ctx.Repo.Commit.ID.String()[:7]
+		default:
+			ctx.Repo.IsViewBranch = true
+			ctx.Repo.CommitID = ctx.Repo.Commit.ID.String()
+		}
+		SingleDownload(ctx)
+		return recorder
+	}
+
+	kases := map[string]string{
+		"full":   "public,max-age=31536000,immutable",
+		"short":  "public,max-age=86400",
+		"branch": "public,max-age=86400",
+	}
+	for ref, expected := range kases {
+		recorder := download(ref)
+		assert.Equal(t, http.StatusOK, recorder.Code, ref)
+		assert.Equal(t, "hello\n", recorder.Body.String(), ref)
+		assert.Equal(t, expected, recorder.Header().Get("Cache-Control"), ref)
+	}
+}
+
+func TestDownloadBySession(t *testing.T) {
+	unittest.PrepareTestEnv(t)
+
+	newContext := func() (*context.Context, *httptest.ResponseRecorder) {
+		ctx := test.MockContext(t, "user2/repo20/raw/branch/master/a/c/hi")
+		recorder := httptest.NewRecorder()
+		ctx.Resp = context.NewResponse(recorder)
+		ctx.Req.Header = make(http.Header)
+		test.LoadRepo(t, ctx, 31)
+		test.LoadGitRepo(t, ctx)
+		test.LoadRepoCommit(t, ctx)
+		ctx.Repo.IsViewBranch = true
+		ctx.Repo.CommitID = ctx.Repo.Commit.ID.String()
+		ctx.Repo.TreePath = "a/c/hi"
+		return ctx, recorder
+	}
+
+	ctx, recorder := newContext()
+	ctx.Req.Form.Set("session", "1")
+	SingleDownload(ctx)
+	ctx.Repo.GitRepo.Close()
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var session struct {
+		URL string `json:"url"`
+		SHA string `json:"sha"`
+	}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &session))
+	assert.Equal(t, "ce013625030ba8dba906f756967f9e9ca394464a", session.SHA)
+	prefix := ctx.Repo.Repository.HTMLURL() + "/raw/session/"
+	assert.True(t, strings.HasPrefix(session.URL, prefix), session.URL)
+
+	// a resumed download gets the rest of the pinned content, even if the path has since been resolved differently
+	ctx, recorder = newContext()
+	defer ctx.Repo.GitRepo.Close()
+	ctx.Repo.TreePath = "a/b/link_hi"
+	ctx.SetParams("token", strings.TrimPrefix(session.URL, prefix))
+	ctx.Req.Header.Set("Range", "bytes=2-")
+	ctx.Req.Header.Set("If-Range", `"`+session.SHA+`"`)
+	DownloadBySession(ctx)
+	assert.Equal(t, http.StatusPartialContent, recorder.Code)
+	assert.Equal(t, "llo\n", recorder.Body.String())
+	assert.Equal(t, "bytes 2-5/6", recorder.Header().Get("Content-Range"))
+
+	// a stale validator gets the full content
+	ctx, recorder = newContext()
+	defer ctx.Repo.GitRepo.Close()
+	ctx.SetParams("token", strings.TrimPrefix(session.URL, prefix))
+	ctx.Req.Header.Set("Range", "bytes=2-")
+	ctx.Req.Header.Set("If-Range", `"0000000000000000000000000000000000000000"`)
+	DownloadBySession(ctx)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "hello\n", recorder.Body.String())
+	assert.Equal(t, "public,max-age=31536000,immutable", recorder.Header().Get("Cache-Control"))
+}
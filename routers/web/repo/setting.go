@@ -153,6 +153,7 @@ func SettingsPost(ctx *context.Context) {
 		repo.LowerName = strings.ToLower(newRepoName)
 		repo.Description = form.Description
 		repo.Website = form.Website
+		repo.MimeTypeMap = repo_model.ParseMimeTypeMap(form.MimeTypeMap)
 		repo.IsTemplate = form.Template
 
 		// Visibility of forked repository is forced sync with base repository.
@@ -116,6 +116,7 @@ type RepoSettingForm struct {
 	RepoName           string `binding:"Required;AlphaDashDot;MaxSize(100)"`
 	Description        string `binding:"MaxSize(255)"`
 	Website            string `binding:"ValidUrl;MaxSize(255)"`
+	MimeTypeMap        string `binding:"MaxSize(4096)"`
 	Interval           string
 	MirrorAddress      string
 	MirrorUsername     string
@@ -964,7 +964,7 @@ parsingLoop:
 			if buffer.Len() == 0 {
 				continue
 			}
-			charsetLabel, err := charset.DetectEncoding(buffer.Bytes())
+			charsetLabel, _, err := charset.DetectEncoding(buffer.Bytes())
 			if charsetLabel != "UTF-8" && err == nil {
 				encoding, _ := stdcharset.Lookup(charsetLabel)
 				if encoding != nil {
@@ -98,7 +98,7 @@ func detectEncodingAndBOM(entry *git.TreeEntry, repo *repo_model.Repository) (st
 		}
 	}
 
-	encoding, err := charset.DetectEncoding(buf)
+	encoding, _, err := charset.DetectEncoding(buf)
 	if err != nil {
 		// just default to utf-8 and no bom
 		return "UTF-8", false